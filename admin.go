@@ -0,0 +1,102 @@
+package smartsnippets
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"google.golang.org/appengine/user"
+)
+
+// AdminEndpoint serves the administration tasks.
+// Requests need the App AdminToken as a bearer token,
+// or an App Engine administrator or cron job when no token is configured, or a Root user.
+// Archives hold the password hashes of the users and grant any role, so SuperAdmin users are refused.
+type AdminEndpoint struct {
+	App *App
+}
+
+func NewAdminEndpoint(app *App) *AdminEndpoint {
+	return &AdminEndpoint{App: app}
+}
+
+func (endpoint AdminEndpoint) Connect(routeCollection *tiger.RouteCollection) {
+	routeCollection.
+		Use(endpoint.authorize).
+		Get("/export", endpoint.Export).
+		Get("/migrations", endpoint.MigrateBatches).
+		Post("/import", endpoint.Import)
+}
+
+func (endpoint AdminEndpoint) authorize(c tiger.Container, next tiger.Handler) {
+	container, ok := c.(ContextAwareContainer)
+	if !ok {
+		c.Error(fmt.Errorf("Container does not implement ContextAwareContainer"), http.StatusInternalServerError)
+		return
+	}
+	if token := endpoint.App.AdminToken; token != "" {
+		authorization := container.GetRequest().Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+token)) == 1 {
+			next(c)
+			return
+		}
+	} else if UsesDatastore(container.GetContext()) && (user.IsAdmin(container.GetContext()) ||
+		// App Engine removes the header from requests that do not come from its cron service
+		container.GetRequest().Header.Get("X-Appengine-Cron") == "true") {
+		next(c)
+		return
+	}
+	if container.GetCurrentUser() != nil {
+		allowed, err := HasRole(container.GetContext(), container.GetCurrentUser(), RoleRoot)
+		if err != nil {
+			container.Error(err, http.StatusInternalServerError)
+			return
+		} else if allowed {
+			next(c)
+			return
+		}
+	}
+	container.Error(fmt.Errorf("Administrator rights are required"), http.StatusForbidden)
+}
+
+// Export writes an archive of all the entities
+func (endpoint AdminEndpoint) Export(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	container.GetResponseWriter().Header().Set("Content-Type", "application/x-ndjson")
+	container.GetResponseWriter().Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="snipped-%s.jsonl"`, time.Now().UTC().Format("20060102-150405")))
+	if err := ExportArchive(container.GetContext(), container.GetResponseWriter()); err != nil {
+		// the response has already started, the error can only be logged
+		container.MustGetLogger().Log(tiger.Error, err)
+	}
+}
+
+// MigrateBatches executes the batch migrations for the budget of the App Migrator, it is called by the cron.
+// It answers 202 Accepted while batches remain
+func (endpoint AdminEndpoint) MigrateBatches(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	if err := endpoint.App.MigrateBatches(container.GetContext()); err == ErrMigrationsInProgress {
+		container.GetResponseWriter().WriteHeader(http.StatusAccepted)
+		return
+	} else if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+// Import restores the archive of the request body and writes the ImportReport
+func (endpoint AdminEndpoint) Import(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	report, err := ImportArchive(container.GetContext(), container.GetRequest().Body)
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	if err = json.NewEncoder(container.GetResponseWriter()).Encode(report); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
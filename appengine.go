@@ -0,0 +1,12 @@
+//go:build appengine
+// +build appengine
+
+package smartsnippets
+
+import "net/http"
+
+// on App Engine the app is served by the runtime,
+// see cmd/snipped to serve it with net/http
+func init() {
+	http.Handle("/", NewApp().Compile())
+}
@@ -0,0 +1,213 @@
+package smartsnippets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+)
+
+const (
+	ArchiveFormat  = "snipped-archive"
+	ArchiveVersion = 1
+	// archivePageSize is the number of entities read at once during an export
+	archivePageSize = 500
+)
+
+// ArchiveKind describes how the entities of a kind are archived
+type ArchiveKind struct {
+	Kind      string
+	Prototype interface{}
+	// ForeignKeys maps the fields referencing other entities to the kind they reference
+	ForeignKeys map[string]string
+	// Identity lists the fields identifying an entity already present in the imported repositories,
+	// such entities are reused instead of created
+	Identity []string
+}
+
+// ArchiveKinds are the archived kinds, referenced kinds come first.
+// Tokens, password reset tokens and unique reservations are not archived, reservations are rebuilt on import.
+var ArchiveKinds = []ArchiveKind{
+	{Kind: Kind.Migrations, Prototype: Migration{}, Identity: []string{"Name"}},
+	{Kind: Kind.Roles, Prototype: Role{}, Identity: []string{"Name"}},
+	{Kind: Kind.Users, Prototype: User{}, Identity: []string{"Nickname"}},
+	{Kind: Kind.UserRoles, Prototype: UserRole{}, ForeignKeys: map[string]string{"UserID": Kind.Users, "RoleID": Kind.Roles}, Identity: []string{"UserID", "RoleID"}},
+	{Kind: Kind.Categories, Prototype: Category{}, Identity: []string{"Title"}},
+	{Kind: Kind.Snippets, Prototype: Snippet{}, ForeignKeys: map[string]string{"CategoryID": Kind.Categories, "AuthorID": Kind.Users}},
+	{Kind: Kind.SnippetRevisions, Prototype: SnippetRevision{}, ForeignKeys: map[string]string{"SnippetID": Kind.Snippets, "CategoryID": Kind.Categories, "AuthorID": Kind.Users}},
+	{Kind: Kind.AuditEntries, Prototype: AuditEntry{}, ForeignKeys: map[string]string{"ActorID": Kind.Users, "UserID": Kind.Users}},
+}
+
+// ArchiveHeader is the first line of an archive
+type ArchiveHeader struct {
+	Format  string
+	Version int
+	Created time.Time
+}
+
+// ArchiveRecord is a line of an archive after the header
+type ArchiveRecord struct {
+	Kind   string
+	Entity json.RawMessage
+}
+
+// ImportReport counts the entities of each kind created or reused by an import
+type ImportReport struct {
+	Created map[string]int
+	Reused  map[string]int
+	// Dangling counts the references to entities missing from the archive, they are set to 0
+	Dangling map[string]int
+}
+
+// ExportArchive writes the entities of ArchiveKinds to w as JSON Lines
+func ExportArchive(ctx context.Context, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(ArchiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, Created: time.Now()}); err != nil {
+		return err
+	}
+	for _, archiveKind := range ArchiveKinds {
+		repository := NewRepository(ctx, archiveKind.Kind)
+		for cursor := ""; ; {
+			entities := reflect.New(reflect.SliceOf(reflect.PtrTo(archiveKind.prototypeType())))
+			next, err := repository.FindPage(Query{Limit: archivePageSize, Cursor: cursor}, entities.Interface())
+			if err != nil {
+				return err
+			}
+			for i := 0; i < entities.Elem().Len(); i++ {
+				entity, err := json.Marshal(entities.Elem().Index(i).Interface())
+				if err != nil {
+					return err
+				}
+				if err = encoder.Encode(ArchiveRecord{Kind: archiveKind.Kind, Entity: entity}); err != nil {
+					return err
+				}
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+	return nil
+}
+
+// ImportArchive creates the entities of an archive with the repositories of the context.
+// Entities get new IDs and the foreign keys listed in ArchiveKinds are remapped,
+// creation dates and versions are kept. The import is not atomic.
+func ImportArchive(ctx context.Context, r io.Reader) (ImportReport, error) {
+	report := ImportReport{Created: map[string]int{}, Reused: map[string]int{}, Dangling: map[string]int{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	header := ArchiveHeader{}
+	if !scanner.Scan() {
+		return report, fmt.Errorf("Empty archive")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != ArchiveFormat {
+		return report, fmt.Errorf("Not a %s", ArchiveFormat)
+	}
+	if header.Version > ArchiveVersion {
+		return report, fmt.Errorf("Unsupported archive version %d", header.Version)
+	}
+	archiveKinds := map[string]ArchiveKind{}
+	for _, archiveKind := range ArchiveKinds {
+		archiveKinds[archiveKind.Kind] = archiveKind
+	}
+	ids := map[string]map[int64]int64{}
+	// archived keeps the dates and version of the entity being created
+	var archived reflect.Value
+	preserve := signal.ListenerFunc(func(e signal.Event) error {
+		if event, ok := e.(BeforeEntityCreatedEvent); ok && archived.IsValid() {
+			entity := reflect.Indirect(reflect.ValueOf(event.Entity))
+			for _, name := range []string{"Created", "Updated", "Version"} {
+				if field := archived.FieldByName(name); field.IsValid() && !reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+					entity.FieldByName(name).Set(field)
+				}
+			}
+		}
+		return nil
+	})
+	for line := 2; scanner.Scan(); line++ {
+		record := ArchiveRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return report, fmt.Errorf("Line %d : %v", line, err)
+		}
+		archiveKind, ok := archiveKinds[record.Kind]
+		if !ok {
+			return report, fmt.Errorf("Line %d : unknown kind %q", line, record.Kind)
+		}
+		entity := reflect.New(archiveKind.prototypeType())
+		if err := json.Unmarshal(record.Entity, entity.Interface()); err != nil {
+			return report, fmt.Errorf("Line %d : %v", line, err)
+		}
+		archivedID := entity.Interface().(Entity).GetID()
+		for field, kind := range archiveKind.ForeignKeys {
+			reference := entity.Elem().FieldByName(field)
+			if reference.Int() == 0 {
+				continue
+			}
+			id, ok := ids[kind][reference.Int()]
+			if !ok {
+				report.Dangling[record.Kind]++
+			}
+			reference.SetInt(id)
+		}
+		if ids[record.Kind] == nil {
+			ids[record.Kind] = map[int64]int64{}
+		}
+		existing, err := archiveKind.findExisting(ctx, entity)
+		if err != nil {
+			return report, fmt.Errorf("Line %d : %v", line, err)
+		}
+		if existing != 0 {
+			ids[record.Kind][archivedID] = existing
+			report.Reused[record.Kind]++
+			continue
+		}
+		archived = reflect.New(entity.Elem().Type()).Elem()
+		archived.Set(entity.Elem())
+		err = NewRepository(ctx, record.Kind, preserve).Create(entity.Interface().(Entity))
+		archived = reflect.Value{}
+		if err != nil {
+			return report, fmt.Errorf("Line %d : %v", line, err)
+		}
+		ids[record.Kind][archivedID] = entity.Interface().(Entity).GetID()
+		report.Created[record.Kind]++
+	}
+	return report, scanner.Err()
+}
+
+func (archiveKind ArchiveKind) prototypeType() reflect.Type {
+	return reflect.Indirect(reflect.ValueOf(archiveKind.Prototype)).Type()
+}
+
+// findExisting returns the ID of the entity having the Identity of entity, or 0
+func (archiveKind ArchiveKind) findExisting(ctx context.Context, entity reflect.Value) (int64, error) {
+	if len(archiveKind.Identity) == 0 {
+		return 0, nil
+	}
+	if len(archiveKind.Identity) == 1 {
+		// unique fields are matched like their constraint compares them, case insensitive values included
+		field := archiveKind.Identity[0]
+		id, constrained, err := findUniqueEntityID(ctx, archiveKind.Kind, field, entity.Elem().FieldByName(field).Interface())
+		if constrained || err != nil {
+			return id, err
+		}
+	}
+	query := Query{Query: map[string]interface{}{}, Limit: 1}
+	for _, field := range archiveKind.Identity {
+		query.Query[field+"="] = entity.Elem().FieldByName(field).Interface()
+	}
+	existing := reflect.New(reflect.SliceOf(reflect.PtrTo(archiveKind.prototypeType())))
+	if err := NewRepository(ctx, archiveKind.Kind).FindBy(query, existing.Interface()); err != nil {
+		return 0, err
+	}
+	if existing.Elem().Len() == 0 {
+		return 0, nil
+	}
+	return existing.Elem().Index(0).Interface().(Entity).GetID(), nil
+}
@@ -0,0 +1,75 @@
+package smartsnippets_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestExportImportArchive(t *testing.T) {
+	source := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(source, app.GetMigrations()), nil)
+	category := &app.Category{Title: "Gophers", Description: "Gopher things"}
+	expect.Expect(t, app.NewCategoryRepository(source).Create(category), nil)
+	snippet := &app.Snippet{Title: "Hello", Content: "hello", CategoryID: category.ID}
+	expect.Expect(t, app.NewSnippetRepository(source).Create(snippet), nil)
+	snippet.Content = "hello world"
+	expect.Expect(t, app.NewSnippetRepository(source).Update(snippet), nil)
+	expect.Expect(t, app.NewUserRepository(source).Create(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}), nil)
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.ExportArchive(source, buffer), nil)
+
+	t.Log("Import into a repository with its own entities")
+	target := SetUpMemoryContext()
+	expect.Expect(t, app.NewCategoryRepository(target).Create(&app.Category{Title: "Other", Description: "Shifts the IDs"}), nil)
+	expect.Expect(t, app.ExecuteMigrations(target, app.GetMigrations()), nil)
+	report, err := app.ImportArchive(target, buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, report.Created[app.Kind.Categories], 1)
+	expect.Expect(t, report.Reused[app.Kind.Categories], 18, "seeded categories")
+	expect.Expect(t, report.Created[app.Kind.Users], 1)
+	expect.Expect(t, report.Created[app.Kind.UserRoles], 1)
+	expect.Expect(t, report.Created[app.Kind.SnippetRevisions], 2)
+	expect.Expect(t, len(report.Dangling), 0)
+
+	snippets := []*app.Snippet{}
+	expect.Expect(t, app.NewSnippetRepository(target).FindAll(&snippets), nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Version, int64(2))
+	expect.Expect(t, snippets[0].Created.Equal(snippet.Created), true)
+	imported := &app.Category{}
+	expect.Expect(t, app.NewCategoryRepository(target).FindByID(snippets[0].CategoryID, imported), nil)
+	expect.Expect(t, imported.Title, "Gophers")
+	revisions := []*app.SnippetRevision{}
+	expect.Expect(t, app.NewSnippetRepository(target).FindRevisions(snippets[0].ID, &revisions), nil)
+	expect.Expect(t, len(revisions), 2)
+
+	t.Log("Invalid archives")
+	_, err = app.ImportArchive(target, strings.NewReader(`{"Format":"other"}`))
+	expect.Expect(t, err != nil, true)
+	_, err = app.ImportArchive(target, strings.NewReader(`{"Format":"snipped-archive","Version":99}`))
+	expect.Expect(t, err != nil, true)
+}
+
+func TestImportArchive_IdentityIgnoresCase(t *testing.T) {
+	source := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(source, app.GetMigrations()), nil)
+	expect.Expect(t, app.NewCategoryRepository(source).Create(&app.Category{Title: "Gophers"}), nil)
+	expect.Expect(t, app.NewUserRepository(source).Create(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}), nil)
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.ExportArchive(source, buffer), nil)
+
+	target := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(target, app.GetMigrations()), nil)
+	expect.Expect(t, app.NewCategoryRepository(target).Create(&app.Category{Title: "gophers"}), nil)
+	expect.Expect(t, app.NewUserRepository(target).Create(&app.User{Nickname: "johndoe", Email: "john.doe@acme.com"}), nil)
+	report, err := app.ImportArchive(target, buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, report.Created[app.Kind.Categories], 0)
+	expect.Expect(t, report.Reused[app.Kind.Categories], 19)
+	expect.Expect(t, report.Created[app.Kind.Users], 0)
+	expect.Expect(t, report.Reused[app.Kind.Users], 2, "Anonymous and johndoe")
+}
@@ -0,0 +1,155 @@
+package smartsnippets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// DefaultTokenLifetime is the time a token issued by Login is valid
+const DefaultTokenLifetime = 14 * 24 * time.Hour
+
+var (
+	ErrInvalidCredentials = fmt.Errorf("ErrInvalidCredentials")
+	ErrInvalidToken       = fmt.Errorf("ErrInvalidToken")
+)
+
+// Credentials are posted to /users/login, Login is the nickname or the email of the user
+type Credentials struct {
+	Login    string
+	Password string
+}
+
+// dummyPassword is compared when the user does not exist so the response time does not reveal it,
+// it is hashed by EncryptPassword to have the cost of the stored passwords
+var dummyPassword, _ = EncryptPassword("dummy password")
+
+// Login verifies the credentials of a user and issues a token,
+// it returns ErrInvalidCredentials if the user does not exist or the password is wrong
+func Login(ctx context.Context, credentials Credentials) (user *User, value string, token *Token, err error) {
+	user, err = findUserByLogin(ctx, credentials.Login)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, "", nil, err
+	}
+	if user == nil || user.EncryptedPassworld == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPassword), []byte(credentials.Password))
+		return nil, "", nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.EncryptedPassworld), []byte(credentials.Password)) != nil {
+		return nil, "", nil, ErrInvalidCredentials
+	}
+	value, token, err = IssueToken(ctx, user, DefaultTokenLifetime)
+	return user, value, token, err
+}
+
+// findUserByLogin finds the user whose nickname or email is login, or returns datastore.ErrNoSuchEntity.
+// Logins are compared like the unique constraints of the fields compare them, regardless of case
+func findUserByLogin(ctx context.Context, login string) (*User, error) {
+	for _, field := range []string{"Nickname", "Email"} {
+		id, _, err := findUniqueEntityID(ctx, Kind.Users, field, login)
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			continue
+		}
+		user := &User{}
+		if err := NewUserRepository(ctx).FindByID(id, user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, datastore.ErrNoSuchEntity
+}
+
+// IssueToken creates a token for user, valid for lifetime.
+// Only a hash of the returned value is stored, so tokens cannot be read from the database
+func IssueToken(ctx context.Context, user *User, lifetime time.Duration) (string, *Token, error) {
+	value, err := GenerateRandomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := &Token{UserID: user.ID, Value: HashToken(value), Expiration: time.Now().Add(lifetime)}
+	if err = NewTokenRepository(ctx).Create(token); err != nil {
+		return "", nil, err
+	}
+	return value, token, nil
+}
+
+// HashToken returns the stored Value of a token
+func HashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindTokenUser returns the user of a valid token,
+// or ErrInvalidToken if the token does not exist, expired or was revoked
+func FindTokenUser(ctx context.Context, value string) (*User, *Token, error) {
+	token := &Token{}
+	err := NewTokenRepository(ctx).FindByValue(HashToken(value), token)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.Revoked || time.Now().After(token.Expiration) {
+		return nil, nil, ErrInvalidToken
+	}
+	user := &User{}
+	if err = NewUserRepository(ctx).FindByID(token.UserID, user); err == datastore.ErrNoSuchEntity {
+		return nil, nil, ErrInvalidToken
+	}
+	return user, token, err
+}
+
+// RevokeToken revokes a token, it returns ErrInvalidToken if the token is not valid
+func RevokeToken(ctx context.Context, value string) error {
+	_, token, err := FindTokenUser(ctx, value)
+	if err != nil {
+		return err
+	}
+	token.Revoked = true
+	return NewTokenRepository(ctx).Update(token)
+}
+
+// BearerToken returns the bearer token of the Authorization header of a request, or ""
+func BearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return ""
+}
+
+// CurrentUserContainer is a container holding the authenticated user of the request
+type CurrentUserContainer interface {
+	GetCurrentUser() *User
+	SetCurrentUser(*User)
+}
+
+// authenticate resolves the bearer token of the request into the current user of the container.
+// Requests without a valid token are served without user, the endpoints decide if one is required
+func (app *App) authenticate(c tiger.Container, next tiger.Handler) {
+	container := c.(*Container)
+	value := BearerToken(container.GetRequest())
+	if value == "" || (app.AdminToken != "" && value == app.AdminToken) {
+		next(c)
+		return
+	}
+	user, _, err := FindTokenUser(container.GetContext(), value)
+	if err != nil && err != ErrInvalidToken {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.SetCurrentUser(user)
+	next(c)
+}
@@ -0,0 +1,97 @@
+package smartsnippets_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestLogin(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	password, err := app.EncryptPassword("password")
+	expect.Expect(t, err, nil)
+	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", EncryptedPassworld: password}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(user), nil)
+
+	_, _, _, err = app.Login(ctx, app.Credentials{Login: "JohnDoe", Password: "wrong"})
+	expect.Expect(t, err, app.ErrInvalidCredentials)
+	_, _, _, err = app.Login(ctx, app.Credentials{Login: "Jane", Password: "password"})
+	expect.Expect(t, err, app.ErrInvalidCredentials)
+	loggedIn, _, _, err := app.Login(ctx, app.Credentials{Login: "johndoe", Password: "password"})
+	expect.Expect(t, err, nil, "Logins are case insensitive like the unique nicknames")
+	expect.Expect(t, loggedIn.ID, user.ID)
+	loggedIn, value, token, err := app.Login(ctx, app.Credentials{Login: "John.Doe@ACME.com", Password: "password"})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, loggedIn.ID, user.ID)
+	expect.Expect(t, token.Value, app.HashToken(value), "Only the hash of the token is stored")
+
+	found, _, err := app.FindTokenUser(ctx, value)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, found.ID, user.ID)
+	expect.Expect(t, app.RevokeToken(ctx, value), nil)
+	_, _, err = app.FindTokenUser(ctx, value)
+	expect.Expect(t, err, app.ErrInvalidToken)
+
+	t.Log("Expired tokens are invalid")
+	value, _, err = app.IssueToken(ctx, user, -time.Minute)
+	expect.Expect(t, err, nil)
+	_, _, err = app.FindTokenUser(ctx, value)
+	expect.Expect(t, err, app.ErrInvalidToken)
+}
+
+func TestMemoryApp_Login(t *testing.T) {
+	App := SetUpMemoryApp().Compile()
+	buffer := new(bytes.Buffer)
+	json.NewEncoder(buffer).Encode(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", Password: "password"})
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("POST", "/users/register", buffer))
+	expect.Expect(t, response.Code, http.StatusCreated, "Status", response.Body.String())
+
+	t.Log("POST /users/login")
+	buffer = new(bytes.Buffer)
+	json.NewEncoder(buffer).Encode(app.Credentials{Login: "JohnDoe", Password: "password"})
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("POST", "/users/login", buffer))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	login := struct{ Token string }{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&login), nil)
+
+	t.Log("GET /users/me")
+	request := httptest.NewRequest("GET", "/users/me", nil)
+	request.Header.Set("Authorization", "Bearer "+login.Token)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	me := &app.User{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(me), nil)
+	expect.Expect(t, me.Nickname, "JohnDoe")
+	expect.Expect(t, me.EncryptedPassworld, "")
+
+	t.Log("POST /users/logout")
+	for _, status := range []int{http.StatusNoContent, http.StatusUnauthorized} {
+		request = httptest.NewRequest("POST", "/users/logout", nil)
+		request.Header.Set("Authorization", "Bearer "+login.Token)
+		response = httptest.NewRecorder()
+		App.ServeHTTP(response, request)
+		expect.Expect(t, response.Code, status, "Status")
+	}
+	request = httptest.NewRequest("GET", "/users/me", nil)
+	request.Header.Set("Authorization", "Bearer "+login.Token)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusUnauthorized, "Status")
+
+	t.Log("POST /users/login with a wrong password")
+	buffer = new(bytes.Buffer)
+	json.NewEncoder(buffer).Encode(app.Credentials{Login: "JohnDoe", Password: "wrong"})
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("POST", "/users/login", buffer))
+	expect.Expect(t, response.Code, http.StatusUnauthorized, "Status")
+}
@@ -0,0 +1,118 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"net/http"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"golang.org/x/net/context"
+)
+
+// Roles seeded by 002-Roles
+const (
+	RoleRoot       = "Root"
+	RoleSuperAdmin = "SuperAdmin"
+	RoleUser       = "User"
+	RoleAnonymous  = "Anonymous"
+)
+
+var (
+	ErrAuthenticationRequired = fmt.Errorf("ErrAuthenticationRequired")
+	ErrForbidden              = fmt.Errorf("ErrForbidden")
+)
+
+// RoleHierarchy lists the roles included by a role,
+// users have the rights of their roles and of the roles they include
+var RoleHierarchy = map[string][]string{
+	RoleRoot:       {RoleSuperAdmin},
+	RoleSuperAdmin: {RoleUser},
+	RoleUser:       {RoleAnonymous},
+}
+
+// RoleIncludes returns true if role is required or includes it through RoleHierarchy
+func RoleIncludes(role, required string) bool {
+	visited := map[string]bool{}
+	roles := []string{role}
+	for len(roles) > 0 {
+		role, roles = roles[0], roles[1:]
+		if role == required {
+			return true
+		}
+		if !visited[role] {
+			visited[role] = true
+			roles = append(roles, RoleHierarchy[role]...)
+		}
+	}
+	return false
+}
+
+// UserRoleNames returns the names of the roles granted to user,
+// a request without user has the Anonymous role
+func UserRoleNames(ctx context.Context, user *User) ([]string, error) {
+	if user == nil {
+		return []string{RoleAnonymous}, nil
+	}
+	userRoles := []*UserRole{}
+	if err := NewUserRoleRepository(ctx).FindBy(Query{Query: map[string]interface{}{"UserID=": user.ID}}, &userRoles); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, userRole := range userRoles {
+		role := &Role{}
+		if err := NewRoleRepository(ctx).FindByID(userRole.RoleID, role); err != nil {
+			return nil, err
+		}
+		names = append(names, role.Name)
+	}
+	return names, nil
+}
+
+// HasRole returns true if one of the roles of user includes role
+func HasRole(ctx context.Context, user *User, role string) (bool, error) {
+	if role == RoleAnonymous {
+		return true, nil
+	}
+	names, err := UserRoleNames(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if RoleIncludes(name, role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Authorize checks that the current user of the container has role.
+// It answers 401 to a request without user and 403 to a user without the role, then returns false
+func Authorize(c tiger.Container, role string) bool {
+	container, ok := c.(ContextAwareContainer)
+	if !ok {
+		c.Error(fmt.Errorf("Container does not implement ContextAwareContainer"), http.StatusInternalServerError)
+		return false
+	}
+	user := container.GetCurrentUser()
+	allowed, err := HasRole(container.GetContext(), user, role)
+	switch {
+	case err != nil:
+		container.Error(err, http.StatusInternalServerError)
+	case allowed:
+		return true
+	case user == nil:
+		container.GetResponseWriter().Header().Set("WWW-Authenticate", "Bearer")
+		container.Error(ErrAuthenticationRequired, http.StatusUnauthorized)
+	default:
+		container.Error(ErrForbidden, http.StatusForbidden)
+	}
+	return false
+}
+
+// RequireRole is a middleware serving only the users having role
+func RequireRole(role string) tiger.Middleware {
+	return func(c tiger.Container, next tiger.Handler) {
+		if Authorize(c, role) {
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+// SetUpUserToken creates a user of App having roles and returns a bearer token of the user
+func SetUpUserToken(t *testing.T, App *app.App, nickname string, roles ...string) string {
+	ctx := App.ContextFactory.Create(httptest.NewRequest("GET", "/", nil))
+	expect.Expect(t, App.Migrate(ctx), nil)
+	user := &app.User{Nickname: nickname, Email: nickname + "@acme.com"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(user), nil)
+	for _, role := range roles {
+		expect.Expect(t, app.GrantRole(ctx, nil, user, role), nil)
+	}
+	value, _, err := app.IssueToken(ctx, user, time.Hour)
+	expect.Expect(t, err, nil)
+	return value
+}
+
+// NewAuthorizedRequest is httptest.NewRequest with token as bearer token
+func NewAuthorizedRequest(method, target string, body io.Reader, token string) *http.Request {
+	request := httptest.NewRequest(method, target, body)
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request
+}
+
+func TestRoleIncludes(t *testing.T) {
+	expect.Expect(t, app.RoleIncludes(app.RoleRoot, app.RoleAnonymous), true)
+	expect.Expect(t, app.RoleIncludes(app.RoleSuperAdmin, app.RoleSuperAdmin), true)
+	expect.Expect(t, app.RoleIncludes(app.RoleUser, app.RoleSuperAdmin), false)
+	expect.Expect(t, app.RoleIncludes("Editor", app.RoleUser), false)
+}
+
+func TestHasRole(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(user), nil)
+
+	for role, expected := range map[string]bool{app.RoleAnonymous: true, app.RoleUser: true, app.RoleSuperAdmin: false} {
+		allowed, err := app.HasRole(ctx, user, role)
+		expect.Expect(t, err, nil)
+		expect.Expect(t, allowed, expected, role)
+	}
+	allowed, err := app.HasRole(ctx, nil, app.RoleUser)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, allowed, false, "Requests without user are anonymous")
+
+	t.Log("GrantRole")
+	expect.Expect(t, app.GrantRole(ctx, nil, user, "Editor"), app.ErrRoleNotFound)
+	expect.Expect(t, app.GrantRole(ctx, nil, user, app.RoleSuperAdmin), nil)
+	expect.Expect(t, app.GrantRole(ctx, nil, user, app.RoleSuperAdmin), nil)
+	names, err := app.UserRoleNames(ctx, user)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(names), 2)
+	allowed, err = app.HasRole(ctx, user, app.RoleSuperAdmin)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, allowed, true)
+}
+
+func TestMemoryApp_Authorization(t *testing.T) {
+	App := SetUpMemoryApp()
+	handler := App.Compile()
+	userToken := SetUpUserToken(t, App, "JohnDoe")
+	adminToken := SetUpUserToken(t, App, "JaneDoe", app.RoleSuperAdmin)
+
+	for _, test := range []struct {
+		Token  string
+		Status int
+	}{
+		{"", http.StatusUnauthorized},
+		{userToken, http.StatusForbidden},
+		{adminToken, http.StatusSeeOther},
+	} {
+		t.Logf("POST /categories with token %q", test.Token)
+		request := httptest.NewRequest("POST", "/categories", strings.NewReader(`{"Title":"Gopher","Description":"Gopher things"}`))
+		if test.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.Token)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		expect.Expect(t, response.Code, test.Status, "Status", response.Body.String())
+		if test.Status == http.StatusUnauthorized {
+			expect.Expect(t, response.Header().Get("WWW-Authenticate"), "Bearer")
+		}
+	}
+
+	t.Log("GET /categories is public")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/categories", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+
+	t.Log("GET /migrations")
+	for token, status := range map[string]int{userToken: http.StatusForbidden, adminToken: http.StatusOK} {
+		request := httptest.NewRequest("GET", "/migrations", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		expect.Expect(t, response.Code, status, "Status")
+	}
+
+	t.Log("PUT /users/:id")
+	rootToken := SetUpUserToken(t, App, "Root", app.RoleRoot)
+	ctx := App.ContextFactory.Create(httptest.NewRequest("GET", "/", nil))
+	john, _, err := app.FindTokenUser(ctx, userToken)
+	expect.Expect(t, err, nil)
+	john.EncryptedPassworld = "hash"
+	expect.Expect(t, app.NewUserRepository(ctx).Update(john), nil)
+	body := fmt.Sprintf(`{"Nickname":"JohnDoe","Email":"john@acme.com","EncryptedPassworld":"forged","Version":%d}`, john.Version)
+	for token, status := range map[string]int{adminToken: http.StatusForbidden, rootToken: http.StatusOK} {
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, NewAuthorizedRequest("PUT", fmt.Sprintf("/users/%d", john.ID), strings.NewReader(body), token))
+		expect.Expect(t, response.Code, status, "Status", response.Body.String())
+	}
+	result := &app.User{}
+	expect.Expect(t, app.NewUserRepository(ctx).FindByID(john.ID, result), nil)
+	expect.Expect(t, result.Email, "john@acme.com")
+	expect.Expect(t, result.EncryptedPassworld, "hash", "Passwords are never written by /users")
+
+	t.Log("GET /users and GET /users/:id never return passwords")
+	for _, path := range []string{"/users", fmt.Sprintf("/users/%d", john.ID)} {
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, NewAuthorizedRequest("GET", path, nil, adminToken))
+		expect.Expect(t, response.Code, http.StatusOK, "Status", path)
+		expect.Expect(t, strings.Contains(response.Body.String(), "JohnDoe"), true, path)
+		expect.Expect(t, strings.Contains(response.Body.String(), "hash"), false, path)
+	}
+
+	t.Log("GET /admin/export is limited to Root users")
+	for token, status := range map[string]int{adminToken: http.StatusForbidden, rootToken: http.StatusOK} {
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, NewAuthorizedRequest("GET", "/admin/export", nil, token))
+		expect.Expect(t, response.Code, status, "Status")
+	}
+}
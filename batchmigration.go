@@ -0,0 +1,110 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultBatchSize is the number of entities of a batch when BatchTask.Size is 0
+	DefaultBatchSize = 100
+	// batchDeadlineMargin is kept between the last batch and the deadline of the context
+	batchDeadlineMargin = 5 * time.Second
+)
+
+// BatchTask is the task of a migration too long for a single request. It walks the entities of Kind
+// in batches of Size, each batch is processed in a transaction that also records the progress of the migration,
+// so a migration that fails or runs out of time resumes after the last processed batch
+type BatchTask struct {
+	Kind string
+	// Prototype is a pointer to the entity stored in Kind
+	Prototype Entity
+	Size      int
+	// Process processes an entity of Kind in the transaction of its batch
+	Process func(tx Repositories, entity Entity) error
+}
+
+// WithMigrationDeadline returns a context in which batch migrations stop
+// processing batches after deadline, to resume on the next execution
+func WithMigrationDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, MigrationDeadlineKey, deadline)
+}
+
+// migrationDeadline returns the time after which no batch is started, or a zero time
+func migrationDeadline(ctx context.Context) time.Time {
+	deadline, _ := ctx.Value(MigrationDeadlineKey).(time.Time)
+	if contextDeadline, ok := ctx.Deadline(); ok {
+		if contextDeadline = contextDeadline.Add(-batchDeadlineMargin); deadline.IsZero() || contextDeadline.Before(deadline) {
+			deadline = contextDeadline
+		}
+	}
+	return deadline
+}
+
+// executeBatchMigration processes the batches of a migration until the last one,
+// it returns ErrMigrationsInProgress if the deadline of the context comes first
+func executeBatchMigration(ctx context.Context, migration *Migration, checksum string) error {
+	deadline := migrationDeadline(ctx)
+	size := migration.Batch.Size
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return ErrMigrationsInProgress
+		}
+		if err := renewMigrationLock(ctx); err != nil {
+			return err
+		}
+		done := false
+		var processErr error
+		err := RunInTransaction(ctx, func(tx Repositories) error {
+			record, err := findMigrationRecord(tx, migration.Name)
+			if err != nil {
+				return err
+			}
+			if done, err = checkAppliedMigration(tx, record, checksum); done || err != nil {
+				return err
+			}
+			if record.Status == MigrationRolledBack {
+				record.Cursor, record.Processed, record.Total = "", 0, 0
+			}
+			repository := tx.Kind(migration.Batch.Kind)
+			if record.Cursor == "" && record.Processed == 0 {
+				total, err := repository.Count(Query{})
+				if err != nil {
+					return err
+				}
+				record.Total = int64(total)
+			}
+			entities := reflect.New(reflect.SliceOf(reflect.TypeOf(migration.Batch.Prototype)))
+			next, err := repository.FindPage(Query{Limit: size, Cursor: record.Cursor}, entities.Interface())
+			if err != nil {
+				return err
+			}
+			for i := 0; i < entities.Elem().Len(); i++ {
+				if processErr = migration.Batch.Process(tx, entities.Elem().Index(i).Interface().(Entity)); processErr != nil {
+					return processErr
+				}
+			}
+			record.Cursor, record.Processed = next, record.Processed+int64(entities.Elem().Len())
+			record.Status, record.Error = MigrationRunning, ""
+			if next == "" {
+				record.Status, record.Checksum, done = MigrationApplied, checksum, true
+			}
+			return saveMigrationRecord(tx, record)
+		})
+		if err != nil && err == processErr {
+			if recordErr := recordMigrationError(ctx, migration.Name, MigrationFailed, err); recordErr != nil {
+				return recordErr
+			}
+			return fmt.Errorf("Migration %s : %v", migration.Name, err)
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package smartsnippets
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/memcache"
+)
+
+// Cache stores the results of repository reads
+type Cache interface {
+	// Get decodes the value stored at key into value, it returns ErrCacheMiss if there is none
+	Get(ctx context.Context, key string, value interface{}) error
+	Set(ctx context.Context, key string, value interface{}) error
+	Delete(ctx context.Context, key string) error
+	// Increment adds delta to the counter stored at key and returns its new value,
+	// a missing counter starts from a value it never had before
+	Increment(ctx context.Context, key string, delta int64) (uint64, error)
+	Stats(ctx context.Context) (CacheStats, error)
+}
+
+// CacheStats are the hit and miss counts of a Cache
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Items  uint64
+}
+
+var (
+	ErrCacheMiss     = fmt.Errorf("ErrCacheMiss")
+	ErrCacheNotFound = fmt.Errorf("ErrCacheNotFound")
+)
+
+// WithCache returns a context in which repositories are cached in cache
+func WithCache(ctx context.Context, cache Cache) context.Context {
+	return context.WithValue(ctx, CacheKey, cache)
+}
+
+// GetCache returns the Cache of the context,
+// datastore backed contexts default to App Engine memcache
+func GetCache(ctx context.Context) (Cache, error) {
+	if cache, ok := ctx.Value(CacheKey).(Cache); ok {
+		return cache, nil
+	}
+	if UsesDatastore(ctx) {
+		return AppengineCache{}, nil
+	}
+	return nil, ErrCacheNotFound
+}
+
+// CachedRepository is a Repository decorator caching FindByID, FindBy and Count.
+//
+// Entities are cached by id. Query results are cached under a generation of the kind
+// that is incremented on each create, update or delete, which invalidates them all.
+// The cache is bypassed in transactions.
+type CachedRepository struct {
+	Repository
+	Cache   Cache
+	Kind    string
+	Context context.Context
+}
+
+// NewCachedRepository creates a CachedRepository
+func NewCachedRepository(ctx context.Context, kind string, repository Repository, cache Cache) *CachedRepository {
+	return &CachedRepository{Repository: repository, Cache: cache, Kind: kind, Context: ctx}
+}
+
+// Errors of the cache are ignored, reads then go to the repository
+
+func (repository CachedRepository) FindByID(id int64, entity Entity) error {
+	if InTransaction(repository.Context) {
+		return repository.Repository.FindByID(id, entity)
+	}
+	key := cacheEntityKey(repository.Kind, id)
+	if repository.get(key, entity) == nil {
+		return nil
+	}
+	if err := repository.Repository.FindByID(id, entity); err != nil {
+		return err
+	}
+	repository.Cache.Set(repository.Context, key, entity)
+	return nil
+}
+
+func (repository CachedRepository) FindBy(query Query, result interface{}) error {
+	if InTransaction(repository.Context) {
+		return repository.Repository.FindBy(query, result)
+	}
+	key, err := repository.queryKey("FindBy", query, result)
+	if err != nil {
+		return repository.Repository.FindBy(query, result)
+	}
+	if repository.get(key, result) == nil {
+		return nil
+	}
+	if err = repository.Repository.FindBy(query, result); err != nil {
+		return err
+	}
+	repository.Cache.Set(repository.Context, key, result)
+	return nil
+}
+
+func (repository CachedRepository) Count(query Query) (int, error) {
+	if InTransaction(repository.Context) {
+		return repository.Repository.Count(query)
+	}
+	var count int
+	key, err := repository.queryKey("Count", query, count)
+	if err != nil {
+		return repository.Repository.Count(query)
+	}
+	if repository.get(key, &count) == nil {
+		return count, nil
+	}
+	if count, err = repository.Repository.Count(query); err != nil {
+		return count, err
+	}
+	repository.Cache.Set(repository.Context, key, count)
+	return count, nil
+}
+
+// The signal listener invalidates the cache before writes, writes are invalidated again
+// once done so that a concurrent read cannot cache what was read in between
+
+func (repository CachedRepository) Create(entity Entity) error {
+	return repository.invalidateAfter(entity, repository.Repository.Create(entity))
+}
+
+func (repository CachedRepository) Update(entity Entity) error {
+	return repository.invalidateAfter(entity, repository.Repository.Update(entity))
+}
+
+func (repository CachedRepository) Delete(entity Entity) error {
+	return repository.invalidateAfter(entity, repository.Repository.Delete(entity))
+}
+
+func (repository CachedRepository) invalidateAfter(entity Entity, err error) error {
+	if err == nil && !InTransaction(repository.Context) {
+		invalidateCache(repository.Context, repository.Cache, repository.Kind, entity.GetID())
+	}
+	return err
+}
+
+// get reads a cached value into value, which is zeroed first
+// since decoding leaves the fields missing from the cached value untouched
+func (repository CachedRepository) get(key string, value interface{}) error {
+	target := reflect.ValueOf(value).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	return repository.Cache.Get(repository.Context, key, value)
+}
+
+// queryKey returns the key of the result of a query in the current generation of the kind
+func (repository CachedRepository) queryKey(operation string, query Query, result interface{}) (string, error) {
+	generation, err := repository.Cache.Increment(repository.Context, cacheGenerationKey(repository.Kind), 0)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s:%T:%x", repository.Kind, generation, operation, result, sha1.Sum(encoded)), nil
+}
+
+func cacheEntityKey(kind string, id int64) string {
+	return kind + ":" + strconv.FormatInt(id, 10)
+}
+
+func cacheGenerationKey(kind string) string {
+	return kind + ":generation"
+}
+
+// invalidateCache removes an entity and the query results of its kind from cache
+func invalidateCache(ctx context.Context, cache Cache, kind string, id int64) {
+	cache.Delete(ctx, cacheEntityKey(kind, id))
+	cache.Increment(ctx, cacheGenerationKey(kind), 1)
+}
+
+// NewCacheListener invalidates the cache of a kind when an entity is created, updated or deleted.
+// In transactions the invalidation is postponed until the transaction is committed.
+func NewCacheListener(ctx context.Context, cache Cache, kind string) signal.Listener {
+	return signal.ListenerFunc(func(e signal.Event) error {
+		var entity Entity
+		switch event := e.(type) {
+		case BeforeEntityCreatedEvent:
+			entity = event.Entity
+		case BeforeEntityUpdatedEvent:
+			entity = event.Old
+		case BeforeEntityDeletedEvent:
+			entity = event.Entity
+		default:
+			return nil
+		}
+		id := entity.GetID()
+		afterCommit(ctx, func() { invalidateCache(ctx, cache, kind, id) })
+		return nil
+	})
+}
+
+// afterCommit runs f once the transaction of ctx is committed, or immediately outside of transactions
+func afterCommit(ctx context.Context, f func()) {
+	if invalidations, ok := ctx.Value(CacheInvalidationsKey).(*cacheInvalidations); ok {
+		invalidations.Add(f)
+	} else {
+		f()
+	}
+}
+
+// cacheInvalidations are the invalidations, and the other side effects of a transaction,
+// postponed until the end of a transaction
+type cacheInvalidations struct {
+	mutex         sync.Mutex
+	invalidations []func()
+}
+
+func (invalidations *cacheInvalidations) Add(f func()) {
+	invalidations.mutex.Lock()
+	defer invalidations.mutex.Unlock()
+	invalidations.invalidations = append(invalidations.invalidations, f)
+}
+
+func (invalidations *cacheInvalidations) Apply() {
+	invalidations.mutex.Lock()
+	defer invalidations.mutex.Unlock()
+	for _, invalidate := range invalidations.invalidations {
+		invalidate()
+	}
+	invalidations.invalidations = nil
+}
+
+// AppengineCache is a Cache backed by App Engine memcache
+type AppengineCache struct {
+	// Expiration of the cached values, 0 means no expiration
+	Expiration time.Duration
+}
+
+func (cache AppengineCache) Get(ctx context.Context, key string, value interface{}) error {
+	_, err := memcache.Gob.Get(ctx, key, value)
+	if err == memcache.ErrCacheMiss {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+func (cache AppengineCache) Set(ctx context.Context, key string, value interface{}) error {
+	return memcache.Gob.Set(ctx, &memcache.Item{Key: key, Object: value, Expiration: cache.Expiration})
+}
+
+func (cache AppengineCache) Delete(ctx context.Context, key string) error {
+	err := memcache.Delete(ctx, key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+func (cache AppengineCache) Increment(ctx context.Context, key string, delta int64) (uint64, error) {
+	// evicted counters restart from the current time so they never go back to a previous value
+	return memcache.Increment(ctx, key, delta, uint64(time.Now().UnixNano()))
+}
+
+func (cache AppengineCache) Stats(ctx context.Context) (CacheStats, error) {
+	statistics, err := memcache.Stats(ctx)
+	if err != nil {
+		return CacheStats{}, err
+	}
+	return CacheStats{Hits: statistics.Hits, Misses: statistics.Misses, Items: statistics.Items}, nil
+}
@@ -0,0 +1,78 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := app.NewLRUCache(2)
+	expect.Expect(t, cache.Set(ctx, "a", &app.Category{Title: "A"}), nil)
+	expect.Expect(t, cache.Set(ctx, "b", &app.Category{Title: "B"}), nil)
+	category := &app.Category{}
+	expect.Expect(t, cache.Get(ctx, "a", category), nil)
+	expect.Expect(t, category.Title, "A")
+	t.Log("The least recently used value is evicted")
+	expect.Expect(t, cache.Set(ctx, "c", &app.Category{Title: "C"}), nil)
+	expect.Expect(t, cache.Get(ctx, "b", category), app.ErrCacheMiss)
+	expect.Expect(t, cache.Delete(ctx, "a"), nil)
+	expect.Expect(t, cache.Get(ctx, "a", category), app.ErrCacheMiss)
+	generation, err := cache.Increment(ctx, "generation", 1)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, generation, uint64(1))
+	stats, err := cache.Stats(ctx)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, stats, app.CacheStats{Hits: 1, Misses: 2, Items: 1})
+}
+
+func TestCachedRepository(t *testing.T) {
+	cache := app.NewLRUCache(100)
+	ctx := app.WithCache(SetUpMemoryContext(), cache)
+	repository := app.NewCategoryRepository(ctx)
+	category := &app.Category{Title: "Go"}
+	expect.Expect(t, repository.Create(category), nil)
+	for i := 0; i < 2; i++ {
+		result := &app.Category{}
+		expect.Expect(t, repository.FindByID(category.ID, result), nil)
+		expect.Expect(t, result.Title, "Go")
+		count, err := repository.Count(app.Query{Query: map[string]interface{}{"Title=": "Go"}})
+		expect.Expect(t, err, nil)
+		expect.Expect(t, count, 1)
+	}
+	stats, _ := cache.Stats(ctx)
+	expect.Expect(t, stats.Hits, uint64(2))
+	expect.Expect(t, stats.Misses, uint64(2))
+
+	t.Log("Updates invalidate cached entities and queries")
+	category.Title = "Golang"
+	expect.Expect(t, repository.Update(category), nil)
+	result := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, result), nil)
+	expect.Expect(t, result.Title, "Golang")
+	count, err := repository.Count(app.Query{Query: map[string]interface{}{"Title=": "Go"}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+
+	t.Log("Transactions invalidate the cache once committed")
+	err = app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		return tx.Categories().Create(&app.Category{Title: "Go"})
+	})
+	expect.Expect(t, err, nil)
+	count, err = repository.Count(app.Query{Query: map[string]interface{}{"Title=": "Go"}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 1)
+	err = app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		if err := tx.Categories().Delete(category); err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	expect.Expect(t, err != nil, true)
+	expect.Expect(t, repository.FindByID(category.ID, result), nil)
+	expect.Expect(t, result.Title, "Golang")
+}
@@ -1,7 +1,9 @@
 package smartsnippets
 
 import (
+	stdlog "log"
 	"net/http"
+	"os"
 
 	tiger "github.com/Mparaiso/tiger-go-framework"
 	"golang.org/x/net/context"
@@ -15,6 +17,16 @@ type Container struct {
 	context.Context
 	containerOptions ContainerOptions
 	logger           tiger.Logger
+	currentUser      *User
+}
+
+// GetCurrentUser returns the user authenticated by the bearer token of the request, or nil
+func (c *Container) GetCurrentUser() *User {
+	return c.currentUser
+}
+
+func (c *Container) SetCurrentUser(user *User) {
+	c.currentUser = user
 }
 
 func (c Container) IsDebug() bool {
@@ -36,9 +48,16 @@ func (c *Container) Error(err error, statusCode int) {
 		c.MustGetLogger().Log(tiger.Error, err)
 	}
 }
+
+// GetLogger returns the logger of the container,
+// the App Engine logger is used unless a ContextFactory was provided
 func (c *Container) GetLogger() (tiger.Logger, error) {
 	if c.logger == nil {
-		c.logger = NewAppEngineLogger(c.GetContext())
+		if c.ContextFactory != nil {
+			c.logger = NewStandardLogger(stdlog.New(os.Stderr, "", stdlog.LstdFlags))
+		} else {
+			c.logger = NewAppEngineLogger(c.GetContext())
+		}
 	}
 	return c.logger, nil
 }
@@ -63,3 +82,25 @@ func (c *Container) GetContext() context.Context {
 	}
 	return c.Context
 }
+
+// ContextFactoryFunc is a function implementing ContextFactory
+type ContextFactoryFunc func(r *http.Request) context.Context
+
+func (f ContextFactoryFunc) Create(r *http.Request) context.Context {
+	return f(r)
+}
+
+// ContextDecorator adds values to a request context
+type ContextDecorator func(ctx context.Context) context.Context
+
+// NewRepositoryContextFactory creates a ContextFactory that derives the request context
+// and stores factory in it, so the app can be served without the App Engine runtime
+func NewRepositoryContextFactory(factory RepositoryFactory, decorators ...ContextDecorator) ContextFactory {
+	return ContextFactoryFunc(func(r *http.Request) context.Context {
+		ctx := WithRepositoryFactory(r.Context(), factory)
+		for _, decorate := range decorators {
+			ctx = decorate(ctx)
+		}
+		return ctx
+	})
+}
@@ -0,0 +1,195 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines kept around the changes of a hunk
+const diffContext = 3
+
+// diffLine is a line of a diff, Operation is ' ', '-' or '+'
+type diffLine struct {
+	Operation byte
+	Text      string
+}
+
+// UnifiedDiff returns the unified diff of two texts, or an empty string if they are equal
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	lines := diffLines(splitLines(from), splitLines(to))
+	diff := &strings.Builder{}
+	fmt.Fprintf(diff, "--- %s\n+++ %s\n", fromName, toName)
+	// fromLine and toLine are the numbers of the lines of both texts at start
+	fromLine, toLine := 1, 1
+	for start := 0; start < len(lines); {
+		// a hunk starts diffContext lines before a change and ends when
+		// more than 2*diffContext unchanged lines follow the last change
+		change := start
+		for change < len(lines) && lines[change].Operation == ' ' {
+			change++
+		}
+		if change == len(lines) {
+			break
+		}
+		first := change - diffContext
+		if first < start {
+			first = start
+		}
+		last, unchanged := change, 0
+		for end := change; end < len(lines) && unchanged <= 2*diffContext; end++ {
+			if lines[end].Operation == ' ' {
+				unchanged++
+			} else {
+				last, unchanged = end, 0
+			}
+		}
+		end := last + 1 + diffContext
+		if end > len(lines) {
+			end = len(lines)
+		}
+		// the lines between the hunks are unchanged
+		fromLine, toLine = fromLine+first-start, toLine+first-start
+		fromLine, toLine = writeDiffHunk(diff, lines[first:end], fromLine, toLine)
+		start = end
+	}
+	return diff.String()
+}
+
+// writeDiffHunk writes lines with their @@ header, the first line being the line fromLine
+// of the first text and toLine of the second. It returns the numbers of the lines following the hunk
+func writeDiffHunk(diff *strings.Builder, lines []diffLine, fromLine, toLine int) (int, int) {
+	fromCount, toCount := 0, 0
+	for _, line := range lines {
+		if line.Operation != '+' {
+			fromCount++
+		}
+		if line.Operation != '-' {
+			toCount++
+		}
+	}
+	fromStart, toStart := fromLine, toLine
+	if fromCount == 0 {
+		fromStart--
+	}
+	if toCount == 0 {
+		toStart--
+	}
+	fmt.Fprintf(diff, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+	for _, line := range lines {
+		diff.WriteByte(line.Operation)
+		diff.WriteString(line.Text)
+		diff.WriteByte('\n')
+	}
+	return fromLine + fromCount, toLine + toCount
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// maxDiffCells bounds the size of the table of the longest common subsequence computed by diffLines
+const maxDiffCells = 1 << 20
+
+// diffLines computes the shortest edit from one list of lines to another
+// with the longest common subsequence of both lists. The common first and last lines are
+// left out of the computation, if the lines between them exceed maxDiffCells they are
+// all replaced, the edit is then not the shortest
+func diffLines(from, to []string) []diffLine {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	lines := []diffLine{}
+	for _, line := range from[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	lines = append(lines, diffChangedLines(from[prefix:len(from)-suffix], to[prefix:len(to)-suffix])...)
+	for _, line := range from[len(from)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// diffChangedLines computes the edit between the lines of diffLines that differ
+func diffChangedLines(from, to []string) []diffLine {
+	lines := []diffLine{}
+	if (len(from)+1)*(len(to)+1) > maxDiffCells {
+		for _, line := range from {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range to {
+			lines = append(lines, diffLine{'+', line})
+		}
+		return lines
+	}
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, diffLine{' ', from[i]})
+			i, j = i+1, j+1
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, diffLine{'-', from[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, diffLine{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, diffLine{'+', to[j]})
+	}
+	return lines
+}
+
+// DiffSnippetRevisions returns the unified diff of each field that changed between two revisions
+func DiffSnippetRevisions(from, to *SnippetRevision) string {
+	fields := []struct {
+		Name     string
+		From, To string
+	}{
+		{"Title", from.Title, to.Title},
+		{"Prefix", from.Prefix, to.Prefix},
+		{"Scope", from.Scope, to.Scope},
+		{"Description", from.Description, to.Description},
+		{"CategoryID", fmt.Sprint(from.CategoryID), fmt.Sprint(to.CategoryID)},
+		{"Content", from.Content, to.Content},
+	}
+	diff := &strings.Builder{}
+	for _, field := range fields {
+		diff.WriteString(UnifiedDiff(
+			fmt.Sprintf("snippets/%d/revisions/%d/%s", from.SnippetID, from.Version, field.Name),
+			fmt.Sprintf("snippets/%d/revisions/%d/%s", to.SnippetID, to.Version, field.Name),
+			field.From, field.To,
+		))
+	}
+	return diff.String()
+}
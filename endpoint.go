@@ -6,6 +6,9 @@ import (
 	"net/http"
 	"path"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	tiger "github.com/Mparaiso/tiger-go-framework"
 	"github.com/Mparaiso/tiger-go-framework/signal"
@@ -13,8 +16,22 @@ import (
 
 type EndPointOptions struct {
 	Commands map[string]bool
+	// PageSize is the number of entities listed by Index when the limit parameter is missing,
+	// DefaultPageSize if 0
+	PageSize int
+	// RequireIfMatch rejects PUT and DELETE requests without an If-Match header
+	// with 428 Precondition Required
+	RequireIfMatch bool
+	// Roles are the roles required by the commands, keyed by command,
+	// commands without role are served to everyone
+	Roles map[string]string
 }
 
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
 // EndPoint is a rest endpoint
 type EndPoint struct {
 	Prototype                 interface{}
@@ -44,19 +61,33 @@ func (e EndPoint) Connect(routeCollection *tiger.RouteCollection) {
 			next(e.EndPointContainerFactory.Create(c))
 		})
 	if len(e.Options.Commands) == 0 || e.Options.Commands["INDEX"] {
-		routeCollection.Get("/", e.Wrap(e.Index))
+		routeCollection.Get("/", e.authorize("INDEX", e.Index))
 	}
 	if len(e.Options.Commands) == 0 || e.Options.Commands["POST"] {
-		routeCollection.Post("/", e.Wrap(e.Post))
+		routeCollection.Post("/", e.authorize("POST", e.Post))
 	}
 	if len(e.Options.Commands) == 0 || e.Options.Commands["PUT"] {
-		routeCollection.Put("/:id", e.Wrap(e.Put))
+		routeCollection.Put("/:id", e.authorize("PUT", e.Put))
 	}
 	if len(e.Options.Commands) == 0 || e.Options.Commands["DELETE"] {
-		routeCollection.Delete("/:id", e.Wrap(e.Delete))
+		routeCollection.Delete("/:id", e.authorize("DELETE", e.Delete))
 	}
 	if len(e.Options.Commands) == 0 || e.Options.Commands["GET"] {
-		routeCollection.Get("/:id", e.Wrap(e.Get))
+		routeCollection.Get("/:id", e.authorize("GET", e.Get))
+	}
+}
+
+// authorize wraps the handler of command, checking the role the options require for it
+func (e EndPoint) authorize(command string, handler func(c EndPointContainer)) func(c tiger.Container) {
+	wrapped := e.Wrap(handler)
+	role, ok := e.Options.Roles[command]
+	if !ok {
+		return wrapped
+	}
+	return func(c tiger.Container) {
+		if Authorize(c, role) {
+			wrapped(c)
+		}
 	}
 }
 
@@ -72,20 +103,80 @@ func (e EndPoint) Index(container EndPointContainer) {
 		e.IndexHandler(container)
 		return
 	}
+	request := container.GetRequest()
+	query, err := e.indexQuery(request, container.GetPrototype())
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
 	repository := container.GetRepository()
 	entities := reflect.New(reflect.SliceOf(container.GetPrototype())).Interface()
-	err := repository.FindAll(entities)
+	next, err := repository.FindPage(query, entities)
+	if err == ErrInvalidCursor {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
+	if len(query.Fields) > 0 {
+		SetEqualityFilteredFields(query, entities)
+	}
+	for slice, i := reflect.ValueOf(entities).Elem(), 0; i < slice.Len(); i++ {
+		if err = container.GetSignal().Dispatch(&AfterResourceReadEvent{slice.Index(i).Addr().Interface().(Entity)}); err != nil {
+			e.dispatchError(container, err)
+			return
+		}
+	}
+	if next != "" {
+		parameters := request.URL.Query()
+		for key := range parameters {
+			if strings.HasPrefix(key, ":") {
+				parameters.Del(key)
+			}
+		}
+		parameters.Set("limit", strconv.Itoa(query.Limit))
+		parameters.Set("cursor", next)
+		container.GetResponseWriter().Header().Add("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, request.URL.Path, parameters.Encode()))
+	}
+	if count, _ := strconv.ParseBool(request.URL.Query().Get("count")); count {
+		query.Limit, query.Cursor, query.Fields = 0, "", nil
+		total, err := repository.Count(query)
+		if err != nil {
+			container.Error(err, http.StatusInternalServerError)
+			return
+		}
+		container.GetResponseWriter().Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
 	err = json.NewEncoder(container.GetResponseWriter()).Encode(entities)
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 	}
 }
 
-// Get fetches a resource
+// indexQuery reads the filter, sort, fields, limit and cursor parameters of an Index request
+func (e EndPoint) indexQuery(request *http.Request, prototype reflect.Type) (Query, error) {
+	query, err := ParseIndexQuery(request.URL.Query(), prototype)
+	if err != nil {
+		return query, err
+	}
+	query.Limit, query.Cursor = e.Options.PageSize, request.URL.Query().Get("cursor")
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+	if limit := request.URL.Query().Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > MaxPageSize {
+			return query, fmt.Errorf("limit should be a number between 1 and %d", MaxPageSize)
+		}
+		query.Limit = value
+	}
+	return query, nil
+}
+
+// Get fetches a resource.
+// It sets the ETag and Last-Modified headers and answers 304 when If-None-Match matches the ETag
 func (e EndPoint) Get(container EndPointContainer) {
 
 	var id int64
@@ -101,13 +192,24 @@ func (e EndPoint) Get(container EndPointContainer) {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
+	if err = container.GetSignal().Dispatch(&AfterResourceReadEvent{entity.(Entity)}); err != nil {
+		e.dispatchError(container, err)
+		return
+	}
+	etag := setValidators(container.GetResponseWriter(), entity.(Entity))
+	if ifNoneMatch := container.GetRequest().Header.Get("If-None-Match"); etag != "" && ifNoneMatch != "" && matchETag(ifNoneMatch, etag, true) {
+		container.GetResponseWriter().WriteHeader(http.StatusNotModified)
+		return
+	}
 	err = json.NewEncoder(container.GetResponseWriter()).Encode(entity)
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 	}
 }
 
-// Put updates a resource
+// Put updates a resource.
+// When If-Match matches the ETag of the resource, the version of the body is ignored,
+// otherwise 412 is returned. A stale version in the body gives 409.
 func (e EndPoint) Put(container EndPointContainer) {
 	entity := reflect.New(container.GetPrototype()).Interface()
 	var id int64
@@ -122,24 +224,39 @@ func (e EndPoint) Put(container EndPointContainer) {
 		container.Error(err, http.StatusNotFound)
 		return
 	}
+	if !e.checkIfMatch(container, entity.(Entity)) {
+		return
+	}
 	candidate := reflect.New(container.GetPrototype()).Interface()
 	json.NewDecoder(container.GetRequest().Body).Decode(candidate)
 	candidate.(Entity).SetID(id)
-	err = container.GetSignal().Dispatch(&BeforeEntityUpdatedEvent{})
+	if versioned, ok := candidate.(VersionedEntity); ok && container.GetRequest().Header.Get("If-Match") != "" {
+		versioned.SetVersion(entity.(VersionedEntity).GetVersion())
+	}
+	err = container.GetSignal().Dispatch(&BeforeResourceUpdateEvent{Old: entity.(Entity), New: candidate.(Entity)})
 	if err != nil {
-		container.Error(err, http.StatusInternalServerError)
+		e.dispatchError(container, err)
 		return
 	}
 	err = repository.Update(candidate.(Entity))
+	if _, ok := err.(VersionMismatchError); ok {
+		e.writeVersionConflict(container, id, err, http.StatusConflict)
+		return
+	}
+	if _, ok := err.(ErrUniqueViolation); ok {
+		container.Error(err, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
 
-	if err = container.GetSignal().Dispatch(&AfterResourceUpdateEvent{}); err != nil {
-		container.Error(err, http.StatusInternalServerError)
+	if err = container.GetSignal().Dispatch(&AfterResourceUpdateEvent{candidate.(Entity)}); err != nil {
+		e.dispatchError(container, err)
 		return
 	}
+	setValidators(container.GetResponseWriter(), candidate.(Entity))
 	container.GetResponseWriter().WriteHeader(http.StatusOK)
 }
 
@@ -160,19 +277,31 @@ func (e EndPoint) Delete(container EndPointContainer) {
 		container.Error(err, http.StatusNotFound)
 		return
 	}
-	err = container.GetSignal().Dispatch(&BeforeResourceDeleteEvent{})
+	if !e.checkIfMatch(container, entity.(Entity)) {
+		return
+	}
+	err = container.GetSignal().Dispatch(&BeforeResourceDeleteEvent{entity.(Entity)})
 	if err != nil {
-		container.Error(err, http.StatusInternalServerError)
+		e.dispatchError(container, err)
 		return
 	}
 	err = repository.Delete(entity.(Entity))
+	if violation, ok := err.(ErrReferenceViolation); ok {
+		container.GetResponseWriter().Header().Set("Content-Type", "application/json")
+		container.GetResponseWriter().WriteHeader(http.StatusConflict)
+		json.NewEncoder(container.GetResponseWriter()).Encode(struct {
+			Error      string
+			References []Reference
+		}{violation.Error(), violation.References})
+		return
+	}
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
-	err = container.GetSignal().Dispatch(&AfterResourceDeleteEvent{})
+	err = container.GetSignal().Dispatch(&AfterResourceDeleteEvent{entity.(Entity)})
 	if err != nil {
-		container.Error(err, http.StatusInternalServerError)
+		e.dispatchError(container, err)
 		return
 	}
 	container.GetResponseWriter().WriteHeader(http.StatusOK)
@@ -189,13 +318,122 @@ func (e EndPoint) Post(container EndPointContainer) {
 		return
 	}
 	repository := container.GetRepository()
-
+	if err = container.GetSignal().Dispatch(&BeforeResourceCreateEvent{entity.(Entity)}); err != nil {
+		e.dispatchError(container, err)
+		return
+	}
 	err = repository.Create(entity.(Entity))
+	if _, ok := err.(ErrUniqueViolation); ok {
+		container.Error(err, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
+	if err = container.GetSignal().Dispatch(&AfterResourceCreateEvent{entity.(Entity)}); err != nil {
+		e.dispatchError(container, err)
+		return
+	}
 	location := path.Join(container.GetRequest().URL.Path, fmt.Sprintf("%d", entity.(Entity).GetID()))
 	container.GetRequest().Method = "GET"
 	http.Redirect(container.GetResponseWriter(), container.GetRequest(), location, 303)
 }
+
+// dispatchError answers with the status of an error returned by a resource event listener
+func (e EndPoint) dispatchError(container EndPointContainer, err error) {
+	switch err {
+	case ErrAuthenticationRequired:
+		container.GetResponseWriter().Header().Set("WWW-Authenticate", "Bearer")
+		container.Error(err, http.StatusUnauthorized)
+	case ErrForbidden:
+		container.Error(err, http.StatusForbidden)
+	default:
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// VersionConflict is the body of 409 and 412 responses,
+// it describes the current version of the resource
+type VersionConflict struct {
+	Error   string
+	ID      int64
+	Version int64
+	ETag    string
+	Current interface{}
+}
+
+// checkIfMatch compares the If-Match header with the ETag of entity,
+// it writes a 412 or 428 response and returns false if the request should not proceed
+func (e EndPoint) checkIfMatch(container EndPointContainer, entity Entity) bool {
+	ifMatch := container.GetRequest().Header.Get("If-Match")
+	if ifMatch == "" {
+		if e.Options.RequireIfMatch {
+			container.Error(fmt.Errorf("If-Match header is required"), http.StatusPreconditionRequired)
+			return false
+		}
+		return true
+	}
+	if etag := entityETag(entity); etag == "" || !matchETag(ifMatch, etag, false) {
+		e.writeVersionConflict(container, entity.GetID(), fmt.Errorf("If-Match does not match the current version"), http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// writeVersionConflict writes a VersionConflict with the current version of the entity id
+func (e EndPoint) writeVersionConflict(container EndPointContainer, id int64, err error, status int) {
+	current := reflect.New(container.GetPrototype()).Interface()
+	if findErr := container.GetRepository().FindByID(id, current.(Entity)); findErr != nil {
+		container.Error(findErr, http.StatusInternalServerError)
+		return
+	}
+	conflict := VersionConflict{Error: err.Error(), ID: id, ETag: entityETag(current.(Entity)), Current: current}
+	if versioned, ok := current.(VersionedEntity); ok {
+		conflict.Version = versioned.GetVersion()
+	}
+	setValidators(container.GetResponseWriter(), current.(Entity))
+	container.GetResponseWriter().Header().Set("Content-Type", "application/json")
+	container.GetResponseWriter().WriteHeader(status)
+	json.NewEncoder(container.GetResponseWriter()).Encode(conflict)
+}
+
+// entityETag returns the strong ETag of a versioned entity, or an empty string
+func entityETag(entity Entity) string {
+	if versioned, ok := entity.(VersionedEntity); ok {
+		return fmt.Sprintf(`"%d"`, versioned.GetVersion())
+	}
+	return ""
+}
+
+// setValidators sets the ETag and Last-Modified headers of entity and returns the ETag
+func setValidators(w http.ResponseWriter, entity Entity) string {
+	etag := entityETag(entity)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if updated := reflect.Indirect(reflect.ValueOf(entity)).FieldByName("Updated"); updated.IsValid() {
+		if date, ok := updated.Interface().(time.Time); ok && !date.IsZero() {
+			w.Header().Set("Last-Modified", date.UTC().Format(http.TimeFormat))
+		}
+	}
+	return etag
+}
+
+// matchETag returns true if header, a list of ETags or *, contains etag.
+// Weak ETags of the header only match when weak is true.
+func matchETag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
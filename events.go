@@ -13,11 +13,49 @@ type BeforeEntityCreatedEvent struct {
 	Entity
 }
 
-type BeforeResourceCreateEvent struct{}
-type AfterResourceCreateEvent struct{}
+// After entity events are dispatched once an entity is written. In a transaction the write
+// is not committed yet, listeners postpone their side effects with afterCommit
 
-type BeforeResourceUpdateEvent struct{}
-type AfterResourceUpdateEvent struct{}
+type AfterEntityCreatedEvent struct {
+	Entity
+}
+
+type AfterEntityUpdatedEvent struct {
+	Entity
+}
+
+type AfterEntityDeletedEvent struct {
+	Entity
+}
+
+// Resource events are dispatched by EndPoint on the signal of its container,
+// a listener returning ErrAuthenticationRequired or ErrForbidden denies the request
+
+type BeforeResourceCreateEvent struct {
+	Entity
+}
+type AfterResourceCreateEvent struct {
+	Entity
+}
 
-type BeforeResourceDeleteEvent struct{}
-type AfterResourceDeleteEvent struct{}
+// BeforeResourceUpdateEvent holds the stored resource and the resource of the request
+type BeforeResourceUpdateEvent struct {
+	Old Entity
+	New Entity
+}
+type AfterResourceUpdateEvent struct {
+	Entity
+}
+
+// AfterResourceReadEvent is dispatched for each resource written by Index and Get,
+// before it is encoded in the response
+type AfterResourceReadEvent struct {
+	Entity
+}
+
+type BeforeResourceDeleteEvent struct {
+	Entity
+}
+type AfterResourceDeleteEvent struct {
+	Entity
+}
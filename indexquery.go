@@ -0,0 +1,152 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryParameterError is returned when a request parameter cannot be translated into a Query
+type QueryParameterError struct {
+	Parameter string
+	Message   string
+}
+
+func (e QueryParameterError) Error() string {
+	return fmt.Sprintf("Invalid parameter %s : %s", e.Parameter, e.Message)
+}
+
+var (
+	filterParameter = regexp.MustCompile(`^filter\[(\w+)\](?:\[(\w+)\])?$`)
+	filterOperators = map[string]string{"": "=", "eq": "=", "ne": "!=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+)
+
+// ParseIndexQuery translates the parameters of an index request into a Query :
+//
+//	filter[CategoryID]=12&filter[Created][gte]=2016-10-26T00:00:00Z&sort=-Updated,Title&fields=Title,Updated
+//
+// Only the fields of prototype tagged with `query:"filter"`, `query:"sort"`
+// or `query:"field"` can be respectively filtered, sorted or selected.
+// Fields filtered by equality are not selected. Queries combining filters, sorts and fields
+// need a composite index on App Engine, see index.yaml.
+func ParseIndexQuery(parameters url.Values, prototype reflect.Type) (Query, error) {
+	query := Query{}
+	for parameter, values := range parameters {
+		matches := filterParameter.FindStringSubmatch(parameter)
+		if matches == nil {
+			continue
+		}
+		field, ok := queryField(prototype, matches[1], "filter")
+		if !ok {
+			return query, QueryParameterError{parameter, fmt.Sprintf("%s is not filterable", matches[1])}
+		}
+		operator, ok := filterOperators[matches[2]]
+		if !ok {
+			return query, QueryParameterError{parameter, fmt.Sprintf("unknown operator %s", matches[2])}
+		}
+		value, err := parseQueryValue(values[0], field.Type)
+		if err != nil {
+			return query, QueryParameterError{parameter, err.Error()}
+		}
+		if query.Query == nil {
+			query.Query = map[string]interface{}{}
+		}
+		query.Query[field.Name+operator] = value
+	}
+	if sort := parameters.Get("sort"); sort != "" {
+		for _, order := range strings.Split(sort, ",") {
+			order = strings.TrimSpace(order)
+			if _, ok := queryField(prototype, strings.TrimPrefix(order, "-"), "sort"); !ok {
+				return query, QueryParameterError{"sort", fmt.Sprintf("%s is not sortable", strings.TrimPrefix(order, "-"))}
+			}
+			query.Order = append(query.Order, order)
+		}
+	}
+	if fields := parameters.Get("fields"); fields != "" {
+		// the ID is always selected so projected entities can still be linked to
+		query.Fields = []string{"ID"}
+		for _, name := range strings.Split(fields, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := queryField(prototype, name, "field"); !ok {
+				return query, QueryParameterError{"fields", fmt.Sprintf("%s cannot be selected", name)}
+			}
+			if _, ok := query.Query[name+"="]; ok {
+				// the datastore cannot project a property filtered by equality, its value is the filter,
+				// set by SetEqualityFilteredFields
+				continue
+			}
+			query.Fields = append(query.Fields, name)
+		}
+	}
+	return query, nil
+}
+
+// SetEqualityFilteredFields sets the fields of entities, a pointer to a slice, filtered by equality
+// in query to the value of their filter, so projected entities get the fields that were not selected
+func SetEqualityFilteredFields(query Query, entities interface{}) {
+	slice := reflect.Indirect(reflect.ValueOf(entities))
+	for key, value := range query.Query {
+		name := strings.TrimSuffix(key, "=")
+		if name == key || strings.ContainsAny(name, "!<>") {
+			continue
+		}
+		for i := 0; i < slice.Len(); i++ {
+			field := reflect.Indirect(slice.Index(i)).FieldByName(name)
+			if field.IsValid() && field.CanSet() && reflect.TypeOf(value).AssignableTo(field.Type()) {
+				field.Set(reflect.ValueOf(value))
+			}
+		}
+	}
+}
+
+// queryField returns the field of prototype named name if its query tag contains option
+func queryField(prototype reflect.Type, name string, option string) (reflect.StructField, bool) {
+	field, ok := prototype.FieldByName(name)
+	if !ok || len(field.Index) != 1 {
+		return field, false
+	}
+	for _, tagOption := range strings.Split(field.Tag.Get("query"), ",") {
+		if tagOption == option {
+			return field, true
+		}
+	}
+	return field, false
+}
+
+// parseQueryValue converts a parameter into a value of fieldType
+func parseQueryValue(value string, fieldType reflect.Type) (interface{}, error) {
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a RFC3339 date", value)
+		}
+		return date, nil
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", value)
+		}
+		return reflect.ValueOf(number).Convert(fieldType).Interface(), nil
+	case reflect.Float32, reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return reflect.ValueOf(number).Convert(fieldType).Interface(), nil
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", value)
+		}
+		return boolean, nil
+	case reflect.String:
+		return value, nil
+	}
+	return nil, fmt.Errorf("fields of type %s cannot be filtered", fieldType)
+}
@@ -0,0 +1,38 @@
+package smartsnippets_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestParseIndexQuery(t *testing.T) {
+	parameters, _ := url.ParseQuery("filter[CategoryID]=12&filter[Title][gte]=A&sort=-Updated,Title&fields=Title,Updated&limit=10")
+	query, err := app.ParseIndexQuery(parameters, reflect.TypeOf(app.Snippet{}))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, query.Query, map[string]interface{}{"CategoryID=": int64(12), "Title>=": "A"})
+	expect.Expect(t, query.Order, []string{"-Updated", "Title"})
+	expect.Expect(t, query.Fields, []string{"ID", "Title", "Updated"})
+
+	t.Log("Fields filtered by equality are not selected")
+	parameters, _ = url.ParseQuery("filter[Title]=Go&fields=Title,CategoryID")
+	query, err = app.ParseIndexQuery(parameters, reflect.TypeOf(app.Snippet{}))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, query.Fields, []string{"ID", "CategoryID"})
+
+	for _, invalid := range []string{
+		"filter[Content]=Hello",
+		"filter[CategoryID]=Go",
+		"filter[Title][like]=A",
+		"sort=Content",
+		"fields=Password",
+	} {
+		parameters, _ = url.ParseQuery(invalid)
+		_, err = app.ParseIndexQuery(parameters, reflect.TypeOf(app.Snippet{}))
+		_, ok := err.(app.QueryParameterError)
+		expect.Expect(t, ok, true, invalid)
+	}
+}
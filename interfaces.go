@@ -31,6 +31,11 @@ type VersionedEntity interface {
 	GetVersion() int64
 }
 
+// IDCreator is implemented by repositories that can create an entity with the ID it already has
+type IDCreator interface {
+	CreateWithID(entity Entity) error
+}
+
 type LockedEntity interface {
 	IsLocked() bool
 }
@@ -43,9 +48,20 @@ type Repository interface {
 	FindByID(id int64, entity Entity) error
 	FindAll(entities interface{}) error
 	FindBy(query Query, result interface{}) error
+	FindPage(query Query, result interface{}) (next string, err error)
 	Count(query Query) (int, error)
 }
 
+// RepositoryFactory creates the Repository of a kind,
+// it allows the storage backend to be swapped (datastore, memory...)
+//
+// RunInTransaction runs f in a transaction, the repositories created
+// with the context given to f are part of the transaction
+type RepositoryFactory interface {
+	Create(ctx context.Context, kind string, listeners ...signal.Listener) Repository
+	RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error
+}
+
 // ContextFactory creates a ContextProvider
 type ContextFactory interface {
 	Create(r *http.Request) context.Context
@@ -74,6 +90,7 @@ type ExistingEntityValidatorProvider interface {
 type ContextAwareContainer interface {
 	tiger.Container
 	ContextProvider
+	CurrentUserContainer
 }
 
 type EndPointContainer interface {
@@ -0,0 +1,185 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	RegisterSnippetFormat("jupyter", JupyterFormat{})
+}
+
+// JupyterFormat imports the code cells of Jupyter notebooks and exports snippets as a nbformat 4 notebook.
+//
+// Each code cell is a snippet of the language of the notebook kernel. The markdown cells before a code cell
+// are its description, their first heading is its title. A single notebook or a zip archive of *.ipynb
+// notebooks is imported.
+type JupyterFormat struct{}
+
+// notebook is a nbformat 4 notebook
+type notebook struct {
+	Cells         []notebookCell   `json:"cells"`
+	Metadata      notebookMetadata `json:"metadata"`
+	NBFormat      int              `json:"nbformat"`
+	NBFormatMinor int              `json:"nbformat_minor"`
+}
+
+type notebookMetadata struct {
+	KernelSpec   *notebookKernelSpec   `json:"kernelspec,omitempty"`
+	LanguageInfo *notebookLanguageInfo `json:"language_info,omitempty"`
+}
+
+type notebookKernelSpec struct {
+	DisplayName string `json:"display_name"`
+	Language    string `json:"language,omitempty"`
+	Name        string `json:"name"`
+}
+
+type notebookLanguageInfo struct {
+	Name string `json:"name"`
+}
+
+// notebookCell is a cell of a notebook, source is a string or an array of lines
+type notebookCell struct {
+	CellType       string                     `json:"cell_type"`
+	ExecutionCount *int                       `json:"execution_count"`
+	Metadata       map[string]json.RawMessage `json:"metadata"`
+	Outputs        []json.RawMessage          `json:"outputs"`
+	Source         json.RawMessage            `json:"source"`
+}
+
+// MarshalJSON writes the execution count and the outputs of code cells only, as nbformat requires
+func (cell notebookCell) MarshalJSON() ([]byte, error) {
+	if cell.Metadata == nil {
+		cell.Metadata = map[string]json.RawMessage{}
+	}
+	if cell.CellType != "code" {
+		return json.Marshal(struct {
+			CellType string                     `json:"cell_type"`
+			Metadata map[string]json.RawMessage `json:"metadata"`
+			Source   json.RawMessage            `json:"source"`
+		}{cell.CellType, cell.Metadata, cell.Source})
+	}
+	if cell.Outputs == nil {
+		cell.Outputs = []json.RawMessage{}
+	}
+	type plainCell notebookCell
+	return json.Marshal(plainCell(cell))
+}
+
+func (JupyterFormat) ContentType() string { return "application/x-ipynb+json" }
+
+func (format JupyterFormat) Decode(r io.Reader) ([]*Snippet, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isZip(source) {
+		return decodeSnippetZip(source, []string{".ipynb"}, format.decode)
+	}
+	return format.decode("", bytes.NewReader(source))
+}
+
+// decode reads the code cells of a notebook, cells without a title are named after the notebook
+func (JupyterFormat) decode(name string, r io.Reader) ([]*Snippet, error) {
+	book := notebook{}
+	if err := json.NewDecoder(r).Decode(&book); err != nil {
+		return nil, err
+	}
+	if book.NBFormat < 4 {
+		return nil, fmt.Errorf("nbformat %d is not supported, expected 4", book.NBFormat)
+	}
+	language := ""
+	if book.Metadata.KernelSpec != nil {
+		language = book.Metadata.KernelSpec.Language
+	}
+	if language == "" && book.Metadata.LanguageInfo != nil {
+		language = book.Metadata.LanguageInfo.Name
+	}
+	if name == "" {
+		name = "Cell"
+	}
+	snippets := []*Snippet{}
+	markdown := []string{}
+	for i, cell := range book.Cells {
+		lines, err := stringOrStrings(cell.Source)
+		if err != nil {
+			return nil, fmt.Errorf("cell %d : invalid source : %v", i, err)
+		}
+		// the lines of a source keep their line breaks
+		text := strings.Join(lines, "")
+		switch cell.CellType {
+		case "markdown":
+			markdown = append(markdown, strings.TrimSpace(text))
+		case "code":
+			snippet := &Snippet{Content: text}
+			snippet.Title, snippet.Description = markdownTitle(strings.TrimSpace(strings.Join(markdown, "\n\n")))
+			if snippet.Title == "" {
+				snippet.Title = fmt.Sprintf("%s %d", name, len(snippets)+1)
+			}
+			if language != "" {
+				snippet.Category = &Category{Title: language}
+			}
+			snippets = append(snippets, snippet)
+			markdown = []string{}
+		}
+	}
+	return snippets, nil
+}
+
+// markdownTitle splits text into the heading of its first line and the rest of the text
+func markdownTitle(text string) (title string, description string) {
+	lines := strings.SplitN(text, "\n", 2)
+	heading := strings.TrimLeft(lines[0], "#")
+	if heading == lines[0] || (heading != "" && !strings.HasPrefix(heading, " ")) {
+		return "", text
+	}
+	if len(lines) > 1 {
+		description = strings.TrimSpace(lines[1])
+	}
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(heading), "#")), description
+}
+
+// Encode writes a notebook with a markdown cell, holding the title and the description,
+// and a code cell per snippet. The kernel is the one of the language of the first snippet with a category
+func (JupyterFormat) Encode(w io.Writer, snippets []*Snippet) error {
+	book := notebook{Cells: []notebookCell{}, NBFormat: 4, NBFormatMinor: 4}
+	for _, snippet := range snippets {
+		if snippet.Category != nil && book.Metadata.KernelSpec == nil {
+			language := CategoryLanguage(snippet.Category)
+			book.Metadata.KernelSpec = &notebookKernelSpec{DisplayName: snippet.Category.Title, Language: language, Name: language}
+			if language == "python" {
+				book.Metadata.KernelSpec.Name = "python3"
+			}
+			book.Metadata.LanguageInfo = &notebookLanguageInfo{Name: language}
+		}
+		markdown := "## " + strings.Replace(snippet.Title, "\n", " ", -1)
+		if description := strings.TrimSpace(snippet.Description); description != "" {
+			markdown += "\n\n" + description
+		}
+		for _, cell := range []struct{ cellType, text string }{{"markdown", markdown}, {"code", snippet.Content}} {
+			source, err := json.Marshal(notebookLines(cell.text))
+			if err != nil {
+				return err
+			}
+			book.Cells = append(book.Cells, notebookCell{CellType: cell.cellType, Source: source})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(book)
+}
+
+// notebookLines splits text into lines that keep their line break, as notebooks store sources
+func notebookLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
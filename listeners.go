@@ -7,6 +7,19 @@ import (
 	"github.com/Mparaiso/tiger-go-framework/signal"
 )
 
+// ErrLockedEntity is returned when a locked entity is updated or deleted
+var ErrLockedEntity = fmt.Errorf("Entity is locked and cannot be modified")
+
+// VersionMismatchError is returned when an entity is updated from another version than the stored one
+type VersionMismatchError struct {
+	Current  int64
+	Received int64
+}
+
+func (e VersionMismatchError) Error() string {
+	return fmt.Sprintf("Versions do not match old : %d , new : %d", e.Current, e.Received)
+}
+
 func BeforeEntityCreatedListener(e signal.Event) error {
 	switch event := e.(type) {
 	case BeforeEntityCreatedEvent:
@@ -26,12 +39,12 @@ func BeforeEntityUpdatedListener(e signal.Event) error {
 	case BeforeEntityUpdatedEvent:
 		if entity, ok := event.Old.(LockedEntity); ok {
 			if entity.IsLocked() {
-				return fmt.Errorf("Entity is locked and cannot be modified")
+				return ErrLockedEntity
 			}
 		}
 		if entity, ok := event.Old.(VersionedEntity); ok {
 			if old, new := entity, event.New.(VersionedEntity); old.GetVersion() != new.GetVersion() {
-				return fmt.Errorf("Versions do not match old : %d , new : %d", old.GetVersion(), new.GetVersion())
+				return VersionMismatchError{Current: old.GetVersion(), Received: new.GetVersion()}
 			} else {
 				new.SetVersion(old.GetVersion() + 1)
 			}
@@ -49,7 +62,7 @@ func BeforeEntityDeletedListener(e signal.Event) error {
 	case BeforeEntityDeletedEvent:
 		if entity, ok := event.Entity.(LockedEntity); ok {
 			if entity.IsLocked() {
-				return fmt.Errorf("Entity is locked and cannot be modified")
+				return ErrLockedEntity
 			}
 		}
 	}
@@ -0,0 +1,264 @@
+package smartsnippets
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Lock is a lease held by an owner until it expires or is released,
+// its ID is derived from its name so instances competing for it write the same entity
+type Lock struct {
+	ID      int64
+	Name    string
+	Owner   string
+	Expires time.Time
+	Created time.Time
+	Updated time.Time
+}
+
+func (l Lock) GetID() int64               { return l.ID }
+func (l *Lock) SetID(id int64)            { l.ID = id }
+func (l *Lock) SetCreated(date time.Time) { l.Created = date }
+func (l *Lock) SetUpdated(date time.Time) { l.Updated = date }
+
+// lockID hashes the name of a lock into a positive ID
+func lockID(name string) int64 {
+	hash := fnv.New64a()
+	fmt.Fprint(hash, name)
+	if id := int64(hash.Sum64() & (1<<63 - 1)); id != 0 {
+		return id
+	}
+	return 1
+}
+
+// AcquireLock acquires or renews the lock named name for owner, for lease.
+// It returns false if another owner holds the lock
+func AcquireLock(ctx context.Context, name string, owner string, lease time.Duration) (acquired bool, err error) {
+	err = RunInTransaction(ctx, func(tx Repositories) error {
+		locks := GetRepositoryFactory(tx.GetContext()).Create(tx.GetContext(), Kind.Locks)
+		lock := &Lock{}
+		err := locks.FindByID(lockID(name), lock)
+		if err == datastore.ErrNoSuchEntity {
+			creator, ok := locks.(IDCreator)
+			if !ok {
+				return fmt.Errorf("Repository of kind %s cannot create entities with a given ID", Kind.Locks)
+			}
+			acquired = true
+			return creator.CreateWithID(&Lock{ID: lockID(name), Name: name, Owner: owner, Expires: time.Now().Add(lease)})
+		}
+		if err != nil {
+			return err
+		}
+		if lock.Owner != owner && lock.Owner != "" && time.Now().Before(lock.Expires) {
+			return nil
+		}
+		acquired = true
+		lock.Owner, lock.Expires = owner, time.Now().Add(lease)
+		return locks.Update(lock)
+	})
+	return acquired && err == nil, err
+}
+
+// ReleaseLock releases the lock named name if owner holds it
+func ReleaseLock(ctx context.Context, name string, owner string) error {
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		locks := GetRepositoryFactory(tx.GetContext()).Create(tx.GetContext(), Kind.Locks)
+		lock := &Lock{}
+		err := locks.FindByID(lockID(name), lock)
+		if err == datastore.ErrNoSuchEntity || (err == nil && lock.Owner != owner) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lock.Owner, lock.Expires = "", time.Time{}
+		return locks.Update(lock)
+	})
+}
+
+// ErrMigrationsInProgress is returned while migrations are executed by another request or instance
+var ErrMigrationsInProgress = fmt.Errorf("ErrMigrationsInProgress")
+
+const (
+	// MigrationLockName is the name of the lock held while migrations are executed
+	MigrationLockName = "migrations"
+	// DefaultMigrationLease is the time an instance may take to execute a migration or a batch
+	// before another instance takes over, the lease is renewed after each of them.
+	// It is the deadline of App Engine requests, so the lock of an interrupted request expires quickly
+	DefaultMigrationLease = time.Minute
+	// DefaultMigrationRetryAfter is the time clients are asked to wait while migrations are executed
+	DefaultMigrationRetryAfter = 5 * time.Second
+	// DefaultMigrationBudget is the time given to batch migrations by each execution
+	DefaultMigrationBudget = 30 * time.Second
+)
+
+// Migrator executes migrations once, in the instance that acquires the migration lock.
+// Other instances wait until the schema is ready, that is until every migration but the batch migrations
+// is applied. Batch migrations are executed apart by MigrateBatches, so requests do not wait for backfills
+type Migrator struct {
+	Migrations []*Migration
+	// Owner identifies the instance in the migration lock
+	Owner string
+	// Lease must exceed the time taken by a migration or a batch
+	Lease time.Duration
+	// RetryAfter is the time to wait before checking the migrations again after a failure
+	RetryAfter time.Duration
+	// Budget is the time given to batch migrations by each execution, the next one resumes them
+	Budget time.Duration
+
+	ready   int32
+	running int32
+	// failed and err are only accessed while running
+	failed time.Time
+	err    error
+}
+
+// NewMigrator creates a Migrator with a random owner
+func NewMigrator(migrations []*Migration) *Migrator {
+	owner := make([]byte, 8)
+	if _, err := rand.Read(owner); err != nil {
+		// the owner only needs to differ between instances
+		binary.BigEndian.PutUint64(owner, uint64(time.Now().UnixNano()))
+	}
+	return &Migrator{
+		Migrations: migrations,
+		Owner:      hex.EncodeToString(owner),
+		Lease:      DefaultMigrationLease,
+		RetryAfter: DefaultMigrationRetryAfter,
+		Budget:     DefaultMigrationBudget,
+	}
+}
+
+// Ready tells whether the schema is ready, every migration but the batch migrations being applied
+func (migrator *Migrator) Ready() bool {
+	return atomic.LoadInt32(&migrator.ready) == 1
+}
+
+// Migrate executes the migrations, batch migrations excepted, if the migration lock is acquired and returns nil
+// once they are applied. It returns ErrMigrationsInProgress while another request or instance executes them,
+// and the error of a failed execution until RetryAfter has passed
+func (migrator *Migrator) Migrate(ctx context.Context) error {
+	if migrator.Ready() {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&migrator.running, 0, 1) {
+		return ErrMigrationsInProgress
+	}
+	defer atomic.StoreInt32(&migrator.running, 0)
+	if migrator.err != nil && time.Since(migrator.failed) < migrator.RetryAfter {
+		return migrator.err
+	}
+	err := migrator.migrate(ctx)
+	if err != nil && err != ErrMigrationsInProgress {
+		migrator.err, migrator.failed = err, time.Now()
+		return err
+	}
+	migrator.err = nil
+	if err == nil {
+		atomic.StoreInt32(&migrator.ready, 1)
+	}
+	return err
+}
+
+func (migrator *Migrator) migrate(ctx context.Context) (err error) {
+	migrations := schemaMigrations(migrator.Migrations)
+	acquired, err := AcquireLock(ctx, MigrationLockName, migrator.Owner, migrator.Lease)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		// another instance migrates, the schema is ready once it is done
+		statuses, err := MigrationStatuses(ctx, migrations)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Status != MigrationApplied {
+				return ErrMigrationsInProgress
+			}
+		}
+		return nil
+	}
+	defer migrator.release(ctx, &err)
+	return ExecuteMigrations(WithMigrationLock(ctx, migrator.Owner, migrator.Lease), migrations)
+}
+
+// MigrateBatches executes every migration, batch migrations included, for Budget if the migration lock
+// is acquired. It returns ErrMigrationsInProgress while batches remain or another request or instance
+// executes the migrations. It is called by warmup requests, the cron and the command line
+func (migrator *Migrator) MigrateBatches(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapInt32(&migrator.running, 0, 1) {
+		return ErrMigrationsInProgress
+	}
+	defer atomic.StoreInt32(&migrator.running, 0)
+	acquired, err := AcquireLock(ctx, MigrationLockName, migrator.Owner, migrator.Lease)
+	if err != nil {
+		return err
+	} else if !acquired {
+		return ErrMigrationsInProgress
+	}
+	defer migrator.release(ctx, &err)
+	migrationCtx := WithMigrationLock(ctx, migrator.Owner, migrator.Lease)
+	if migrator.Budget > 0 {
+		migrationCtx = WithMigrationDeadline(migrationCtx, time.Now().Add(migrator.Budget))
+	}
+	if err := ExecuteMigrations(migrationCtx, migrator.Migrations); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&migrator.ready, 1)
+	return nil
+}
+
+// release releases the migration lock, its error is returned in err unless err is already set
+func (migrator *Migrator) release(ctx context.Context, err *error) {
+	if releaseErr := ReleaseLock(ctx, MigrationLockName, migrator.Owner); *err == nil {
+		*err = releaseErr
+	}
+}
+
+// migrationLock is the migration lock held by the owner of a context
+type migrationLock struct {
+	Owner string
+	Lease time.Duration
+}
+
+// WithMigrationLock returns a context in which owner, who holds the migration lock,
+// renews it for lease between migrations and between batches
+func WithMigrationLock(ctx context.Context, owner string, lease time.Duration) context.Context {
+	return context.WithValue(ctx, MigrationLockKey, migrationLock{owner, lease})
+}
+
+// renewMigrationLock renews the migration lock of the context, if any.
+// It returns ErrMigrationsInProgress if the lease expired and another owner acquired the lock
+func renewMigrationLock(ctx context.Context) error {
+	lock, ok := ctx.Value(MigrationLockKey).(migrationLock)
+	if !ok {
+		return nil
+	}
+	acquired, err := AcquireLock(ctx, MigrationLockName, lock.Owner, lock.Lease)
+	if err != nil {
+		return err
+	} else if !acquired {
+		return ErrMigrationsInProgress
+	}
+	return nil
+}
+
+// schemaMigrations returns the migrations without Batch task
+func schemaMigrations(migrations []*Migration) []*Migration {
+	result := []*Migration{}
+	for _, migration := range migrations {
+		if migration.Batch == nil {
+			result = append(result, migration)
+		}
+	}
+	return result
+}
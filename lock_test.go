@@ -0,0 +1,129 @@
+package smartsnippets_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestAcquireLock(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	acquired, err := app.AcquireLock(ctx, "lock", "a", time.Minute)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, acquired, true)
+	acquired, err = app.AcquireLock(ctx, "lock", "b", time.Minute)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, acquired, false, "The lock is held by a")
+	acquired, err = app.AcquireLock(ctx, "lock", "a", time.Minute)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, acquired, true, "The owner renews its lease")
+
+	t.Log("Released locks are acquired")
+	expect.Expect(t, app.ReleaseLock(ctx, "lock", "b"), nil)
+	acquired, _ = app.AcquireLock(ctx, "lock", "b", time.Minute)
+	expect.Expect(t, acquired, false, "Only the owner releases a lock")
+	expect.Expect(t, app.ReleaseLock(ctx, "lock", "a"), nil)
+	acquired, _ = app.AcquireLock(ctx, "lock", "b", -time.Second)
+	expect.Expect(t, acquired, true)
+
+	t.Log("Expired locks are acquired")
+	acquired, _ = app.AcquireLock(ctx, "lock", "a", time.Minute)
+	expect.Expect(t, acquired, true)
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	other, migrator := app.NewMigrator(app.GetMigrations()), app.NewMigrator(app.GetMigrations())
+	acquired, err := app.AcquireLock(ctx, app.MigrationLockName, other.Owner, time.Minute)
+	expect.Expect(t, acquired, true)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, migrator.Migrate(ctx), app.ErrMigrationsInProgress)
+	expect.Expect(t, migrator.Ready(), false)
+
+	t.Log("The instance holding the lock migrates")
+	expect.Expect(t, other.Migrate(ctx), nil)
+	expect.Expect(t, other.Ready(), true)
+	expect.Expect(t, migrator.Migrate(ctx), nil)
+	expect.Expect(t, migrator.Ready(), true)
+	count, err := app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 18, "Categories are seeded once")
+}
+
+func TestWithMigrationLock(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	migrationCtx := app.WithMigrationLock(ctx, "a", time.Minute)
+	acquired, err := app.AcquireLock(ctx, app.MigrationLockName, "b", time.Minute)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, acquired, true)
+
+	t.Log("Migrations stop once another owner acquired the lock")
+	expect.Expect(t, app.ExecuteMigrations(migrationCtx, app.GetMigrations()), app.ErrMigrationsInProgress)
+	count, err := app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+
+	t.Log("The lease is renewed between migrations")
+	expect.Expect(t, app.ReleaseLock(ctx, app.MigrationLockName, "b"), nil)
+	acquired, _ = app.AcquireLock(ctx, app.MigrationLockName, "a", time.Nanosecond)
+	expect.Expect(t, acquired, true)
+	expect.Expect(t, app.ExecuteMigrations(migrationCtx, app.GetMigrations()), nil)
+	acquired, _ = app.AcquireLock(ctx, app.MigrationLockName, "b", time.Minute)
+	expect.Expect(t, acquired, false)
+}
+
+func TestMigrator_MigrateBatches(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	processed := 0
+	migrations := append(app.GetMigrations(), &app.Migration{Name: "backfill", Source: "Categories", Created: time.Now(), Batch: &app.BatchTask{
+		Kind: app.Kind.Categories, Prototype: &app.Category{}, Size: 5,
+		Process: func(tx app.Repositories, entity app.Entity) error {
+			processed++
+			return nil
+		},
+	}})
+	migrator := app.NewMigrator(migrations)
+
+	t.Log("The schema is ready before the batch migrations are applied")
+	expect.Expect(t, migrator.Migrate(ctx), nil)
+	expect.Expect(t, migrator.Ready(), true)
+	expect.Expect(t, processed, 0)
+	statuses, err := app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statuses[len(statuses)-1].Status, app.MigrationPending)
+
+	t.Log("Batch migrations stop at the end of the budget")
+	migrator.Budget = time.Nanosecond
+	expect.Expect(t, migrator.MigrateBatches(ctx), app.ErrMigrationsInProgress)
+	expect.Expect(t, processed, 0)
+	migrator.Budget = 0
+	expect.Expect(t, migrator.MigrateBatches(ctx), nil)
+	expect.Expect(t, processed, 18)
+}
+
+func TestMemoryApp_MigrationLock(t *testing.T) {
+	factory := app.NewMemoryRepositoryFactory()
+	ctx := app.WithRepositoryFactory(SetUpMemoryContext(), factory)
+	App := app.NewApp()
+	App.ContextFactory = app.NewRepositoryContextFactory(factory)
+	handler := App.Compile()
+	_, err := app.AcquireLock(ctx, app.MigrationLockName, "another instance", time.Minute)
+	expect.Expect(t, err, nil)
+
+	t.Log("GET / while another instance migrates")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	expect.Expect(t, response.Code, http.StatusServiceUnavailable, "Status")
+	expect.Expect(t, response.Header().Get("Retry-After"), "5")
+
+	t.Log("GET /_ah/warmup once the lock is released")
+	expect.Expect(t, app.ReleaseLock(ctx, app.MigrationLockName, "another instance"), nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/_ah/warmup", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	expect.Expect(t, App.Ready(), true)
+}
@@ -1,6 +1,9 @@
 package smartsnippets
 
 import (
+	"fmt"
+	stdlog "log"
+
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
 )
@@ -22,7 +25,7 @@ func NewAppEngineLogger(context context.Context) *AppEngineLogger {
 }
 
 func (logger AppEngineLogger) Log(level int, messages ...interface{}) {
-	logger.LogF(level, "%v", messages)
+	logger.LogF(level, "%s", fmt.Sprint(messages...))
 }
 func (logger AppEngineLogger) LogF(level int, format string, messages ...interface{}) {
 	switch level {
@@ -38,3 +41,21 @@ func (logger AppEngineLogger) LogF(level int, format string, messages ...interfa
 		log.Criticalf(logger.context, format, messages...)
 	}
 }
+
+var levelNames = map[int]string{Debug: "DEBUG", Info: "INFO", Warning: "WARNING", Error: "ERROR", Critical: "CRITICAL"}
+
+// StandardLogger logs with a logger of the standard library, outside of App Engine
+type StandardLogger struct {
+	logger *stdlog.Logger
+}
+
+func NewStandardLogger(logger *stdlog.Logger) *StandardLogger {
+	return &StandardLogger{logger}
+}
+
+func (logger StandardLogger) Log(level int, messages ...interface{}) {
+	logger.LogF(level, "%s", fmt.Sprint(messages...))
+}
+func (logger StandardLogger) LogF(level int, format string, messages ...interface{}) {
+	logger.logger.Printf(levelNames[level]+" "+format, messages...)
+}
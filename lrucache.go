@@ -0,0 +1,93 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// LRUCache is an in process Cache keeping its most recently used values
+type LRUCache struct {
+	mutex    sync.Mutex
+	capacity int
+	values   map[string]*list.Element
+	order    *list.List
+	counters map[string]uint64
+	stats    CacheStats
+}
+
+// lruEntry is a value of a LRUCache, encoded so cached entities are never shared
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// NewLRUCache creates a LRUCache holding at most capacity values,
+// counters are not values and are never evicted
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{capacity: capacity, values: map[string]*list.Element{}, order: list.New(), counters: map[string]uint64{}}
+}
+
+func (cache *LRUCache) Get(ctx context.Context, key string, value interface{}) error {
+	cache.mutex.Lock()
+	element, ok := cache.values[key]
+	if !ok {
+		cache.stats.Misses++
+		cache.mutex.Unlock()
+		return ErrCacheMiss
+	}
+	cache.stats.Hits++
+	cache.order.MoveToFront(element)
+	encoded := element.Value.(*lruEntry).value
+	cache.mutex.Unlock()
+	return gob.NewDecoder(bytes.NewReader(encoded)).Decode(value)
+}
+
+func (cache *LRUCache) Set(ctx context.Context, key string, value interface{}) error {
+	buffer := new(bytes.Buffer)
+	if err := gob.NewEncoder(buffer).Encode(value); err != nil {
+		return err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.values[key]; ok {
+		element.Value.(*lruEntry).value = buffer.Bytes()
+		cache.order.MoveToFront(element)
+		return nil
+	}
+	cache.values[key] = cache.order.PushFront(&lruEntry{key, buffer.Bytes()})
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.values, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+func (cache *LRUCache) Delete(ctx context.Context, key string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.values[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.values, key)
+	}
+	return nil
+}
+
+func (cache *LRUCache) Increment(ctx context.Context, key string, delta int64) (uint64, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.counters[key] += uint64(delta)
+	return cache.counters[key], nil
+}
+
+func (cache *LRUCache) Stats(ctx context.Context) (CacheStats, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	stats := cache.stats
+	stats.Items = uint64(cache.order.Len())
+	return stats, nil
+}
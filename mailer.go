@@ -0,0 +1,162 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"fmt"
+	stdlog "log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/delay"
+	appenginemail "google.golang.org/appengine/mail"
+)
+
+var (
+	ErrMailerNotFound = fmt.Errorf("ErrMailerNotFound")
+)
+
+// Message is a plain text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// WithMailer returns a context in which emails are sent with mailer
+func WithMailer(ctx context.Context, mailer Mailer) context.Context {
+	return context.WithValue(ctx, MailerKey, mailer)
+}
+
+// GetMailer returns the Mailer of the context,
+// datastore backed contexts default to the App Engine mail service
+func GetMailer(ctx context.Context) (Mailer, error) {
+	if mailer, ok := ctx.Value(MailerKey).(Mailer); ok {
+		return mailer, nil
+	}
+	if UsesDatastore(ctx) {
+		return AppengineMailer{}, nil
+	}
+	return nil, ErrMailerNotFound
+}
+
+// sendMailTask sends an email from an App Engine task, with the mailer of the task context
+var sendMailTask = delay.Func("send-mail", func(ctx context.Context, message Message) error {
+	mailer, err := GetMailer(ctx)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(ctx, message)
+})
+
+// SendMailLater sends message outside of the current request, so the response time does not depend
+// on the mailer. Datastore backed contexts without mailer send it from a task, the others from a goroutine
+// which logs the errors
+func SendMailLater(ctx context.Context, message Message) error {
+	mailer, err := GetMailer(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Value(MailerKey).(Mailer); !ok && UsesDatastore(ctx) {
+		return sendMailTask.Call(ctx, message)
+	}
+	go func() {
+		if err := mailer.Send(context.Background(), message); err != nil {
+			stdlog.Printf("ERROR Sending an email to %v : %s", message.To, err)
+		}
+	}()
+	return nil
+}
+
+// SMTPMailer sends emails with an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	From string
+	// Auth may be nil if the server does not require authentication
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer returns a mailer authenticating with username and password if username is not empty
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	mailer := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (mailer SMTPMailer) Send(ctx context.Context, message Message) error {
+	to := []string{}
+	for _, recipient := range message.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		to = append(to, address.Address)
+	}
+	from, err := mail.ParseAddress(mailer.From)
+	if err != nil {
+		return err
+	}
+	data := &bytes.Buffer{}
+	fmt.Fprintf(data, "From: %s\r\n", from.String())
+	fmt.Fprintf(data, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(data, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(data, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("\r\n")
+	data.WriteString(strings.Replace(strings.Replace(message.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return smtp.SendMail(mailer.Addr, mailer.Auth, from.Address, to, data.Bytes())
+}
+
+// AppengineMailer sends emails with the App Engine mail service,
+// Sender defaults to noreply@<app id>.appspotmail.com
+type AppengineMailer struct {
+	Sender string
+}
+
+func (mailer AppengineMailer) Send(ctx context.Context, message Message) error {
+	sender := mailer.Sender
+	if sender == "" {
+		sender = fmt.Sprintf("noreply@%s.appspotmail.com", appengine.AppID(ctx))
+	}
+	return appenginemail.Send(ctx, &appenginemail.Message{Sender: sender, To: message.To, Subject: message.Subject, Body: message.Body})
+}
+
+// MemoryMailer keeps the emails it sends in memory, for tests
+type MemoryMailer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mailer *MemoryMailer) Send(ctx context.Context, message Message) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.messages = append(mailer.messages, message)
+	return nil
+}
+
+// Messages returns the sent emails, oldest first
+func (mailer *MemoryMailer) Messages() []Message {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	return append([]Message{}, mailer.messages...)
+}
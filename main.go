@@ -4,10 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
-
-	"sync"
-
-	"google.golang.org/appengine/log"
+	"strconv"
+	"time"
 
 	tiger "github.com/Mparaiso/tiger-go-framework"
 	"github.com/Mparaiso/tiger-go-framework/signal"
@@ -15,47 +13,93 @@ import (
 
 // App is the web application
 type App struct {
-	*sync.Once
+	// Migrator executes the migrations before the first requests are served
+	*Migrator
 	Debug bool
 	*tiger.Router
+	// ContextFactory creates the context of each request,
+	// if nil the App Engine context is used
+	ContextFactory ContextFactory
+	// Logger is the logger of each request,
+	// if nil the container chooses one
+	Logger tiger.Logger
+	// AdminToken is the bearer token of the /admin endpoints,
+	// if empty only App Engine administrators are allowed
+	AdminToken string
 }
 
 func (a App) GetContainer(w http.ResponseWriter, r *http.Request) tiger.Container {
-	return NewContainer(w, r)
+	container := NewContainer(w, r)
+	container.ContextFactory = a.ContextFactory
+	container.logger = a.Logger
+	return container
 }
 
 func NewApp() *App {
 	app := new(App)
 	app.Debug = true
 	app.Router = tiger.NewRouter()
-	app.Once = new(sync.Once)
+	app.Migrator = NewMigrator(GetMigrations())
 
 	app.ContainerFactory = app
 
-	snippetEndpoint := NewEndpoint(new(SnippetEndPointContainerFactory))
-	categoryEndpoint := NewEndpoint(new(CategoryEndPointContainerFactory))
-	userEndpoint := NewEndpoint(new(UserEndPointContainerFactory))
-	migrationEndpoint := NewEndpoint(new(MigrationEndPointContainerFactory), EndPointOptions{Commands: map[string]bool{"INDEX": true}})
+	// users write their own snippets, see NewSnippetOwnershipListener
+	snippetEndpoint := NewEndpoint(new(SnippetEndPointContainerFactory), EndPointOptions{
+		Roles: map[string]string{"POST": RoleUser, "PUT": RoleUser, "DELETE": RoleUser},
+	})
+	// categories are shared by all the users, only administrators edit them
+	categoryEndpoint := NewEndpoint(new(CategoryEndPointContainerFactory), EndPointOptions{
+		Roles: map[string]string{"POST": RoleSuperAdmin, "PUT": RoleSuperAdmin, "DELETE": RoleSuperAdmin},
+	})
+	// editing the email of a user takes over their account, only Root users can edit users of any role
+	userEndpoint := NewEndpoint(new(UserEndPointContainerFactory), EndPointOptions{
+		Roles: map[string]string{"INDEX": RoleSuperAdmin, "GET": RoleSuperAdmin, "POST": RoleRoot, "PUT": RoleRoot, "DELETE": RoleRoot},
+	})
+	migrationEndpoint := NewEndpoint(new(MigrationEndPointContainerFactory), EndPointOptions{
+		Commands: map[string]bool{"INDEX": true},
+		Roles:    map[string]string{"INDEX": RoleSuperAdmin},
+	})
+	auditEndpoint := NewEndpoint(new(AuditEndPointContainerFactory), EndPointOptions{
+		Commands: map[string]bool{"INDEX": true},
+		Roles:    map[string]string{"INDEX": RoleSuperAdmin},
+	})
+	roleEndpoint := NewRoleEndpoint()
 	usersModule := NewUserEndpoint(NewUserEndpointContainerFactory())
+	searchEndpoint := NewSearchEndpoint()
+	revisionEndpoint := NewSnippetRevisionEndpoint()
+	snippetFormatEndpoint := NewSnippetFormatEndpoint()
+	adminEndpoint := NewAdminEndpoint(app)
 	app.Use(func(c tiger.Container, next tiger.Handler) {
 		container := c.(*Container)
 		container.SetContainerOptions(ContainerOptions{Debug: app.Debug})
-		app.Do(func() {
-			ctx := container.GetContext()
-			if err := ExecuteMigrations(ctx, GetMigrations()); err != nil {
-				log.Errorf(ctx, "Error during migration '%s'.", err.Error())
-			} else {
-				log.Infof(ctx, "Migrations done")
+		if !app.Ready() {
+			// requests wait for the schema, while another request or instance migrates they are asked to retry
+			if err := app.Migrate(container.GetContext()); err != nil {
+				if err != ErrMigrationsInProgress {
+					container.MustGetLogger().Log(Error, fmt.Sprintf("Error during migration '%s'.", err.Error()))
+				}
+				container.GetResponseWriter().Header().Set("Retry-After", strconv.Itoa(int(app.RetryAfter/time.Second)))
+				container.Error(err, http.StatusServiceUnavailable)
+				return
 			}
-		})
+			container.MustGetLogger().Log(Info, "Migrations done")
+		}
 		next(c)
 	}).
+		Use(app.authenticate).
 		Get("/", index).
+		Get("/_ah/warmup", app.warmup).
 		Mount("/users/", usersModule).
+		Mount("/snippets/", searchEndpoint).
+		Mount("/snippets/", revisionEndpoint).
+		Mount("/snippets/", snippetFormatEndpoint).
 		Mount("/snippets", snippetEndpoint).
 		Mount("/categories", categoryEndpoint).
 		Mount("/users", userEndpoint).
-		Mount("/migrations", migrationEndpoint)
+		Mount("/migrations", migrationEndpoint).
+		Mount("/roles", roleEndpoint).
+		Mount("/audit", auditEndpoint).
+		Mount("/admin", adminEndpoint)
 	return app
 }
 
@@ -69,25 +113,58 @@ func (MigrationEndPointContainerFactory) Create(container tiger.Container) EndPo
 	)
 }
 
+type AuditEndPointContainerFactory struct{}
+
+func (AuditEndPointContainerFactory) Create(container tiger.Container) EndPointContainer {
+	return NewDefaultEndPointContainer(
+		Kind.AuditEntries,
+		reflect.TypeOf(AuditEntry{}),
+		container.(*Container),
+	)
+}
+
 type UserEndPointContainerFactory struct {
 }
 
 func (UserEndPointContainerFactory) Create(container tiger.Container) EndPointContainer {
-	return NewDefaultEndPointContainer(
+	endpointContainer := NewDefaultEndPointContainer(
 		Kind.Users,
 		reflect.TypeOf(User{}),
 		container.(*Container),
 	)
+	endpointContainer.GetSignal().Add(NewUserPasswordListener())
+	return endpointContainer
 }
 
 type SnippetEndPointContainerFactory struct{}
 
 func (SnippetEndPointContainerFactory) Create(container tiger.Container) EndPointContainer {
-	return NewDefaultEndPointContainer(
+	endpointContainer := NewDefaultEndPointContainer(
 		Kind.Snippets,
 		reflect.TypeOf(Snippet{}),
-		container.(*Container),
+		container.(ContextAwareContainer),
 	)
+	// snippets go through SnippetRepository so their revisions are recorded
+	endpointContainer.RepositoryProvider = &SnippetRepositoryProvider{ContextProvider: endpointContainer.ContextAwareContainer}
+	endpointContainer.GetSignal().Add(NewSnippetOwnershipListener(endpointContainer.ContextAwareContainer))
+	return endpointContainer
+}
+
+// SnippetRepositoryProvider provides a SnippetRepository
+type SnippetRepositoryProvider struct {
+	ContextProvider
+	repository *SnippetRepository
+}
+
+func (provider *SnippetRepositoryProvider) GetRepository() Repository {
+	return provider.GetSnippetRepository()
+}
+
+func (provider *SnippetRepositoryProvider) GetSnippetRepository() *SnippetRepository {
+	if provider.repository == nil {
+		provider.repository = NewSnippetRepository(provider.GetContext())
+	}
+	return provider.repository
 }
 
 type CategoryEndPointContainerFactory struct{}
@@ -142,15 +219,21 @@ func NewAppengineRepositoryProvider(contextProvider ContextProvider, kind string
 
 func (provider *AppengineRepositoryProvider) GetRepository() Repository {
 	if provider.Repository == nil {
-		provider.Repository = NewDefaultRepository(provider.GetContext(), provider.Kind, provider.listeners...)
+		provider.Repository = NewRepository(provider.GetContext(), provider.Kind, provider.listeners...)
 	}
 	return provider.Repository
 }
 
-func index(c tiger.Container) {
-	fmt.Fprint(c.GetResponseWriter(), "Hello Smart Snippets")
+// warmup lets App Engine run the migrations before the instance serves user traffic,
+// the schema is migrated by the middleware then the batch migrations get the budget of the Migrator
+func (app *App) warmup(c tiger.Container) {
+	container := c.(*Container)
+	if err := app.MigrateBatches(container.GetContext()); err != nil && err != ErrMigrationsInProgress {
+		container.MustGetLogger().Log(Error, fmt.Sprintf("Error during batch migration '%s'.", err.Error()))
+	}
+	fmt.Fprint(c.GetResponseWriter(), "OK")
 }
 
-func init() {
-	http.Handle("/", NewApp().Compile())
+func index(c tiger.Container) {
+	fmt.Fprint(c.GetResponseWriter(), "Hello Smart Snippets")
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	stdlog "log"
 	"strings"
 	"testing"
 
@@ -12,6 +13,7 @@ import (
 
 	"github.com/Mparaiso/expect-go"
 	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine/aetest"
 )
 
@@ -33,11 +35,11 @@ func TestIndex(t *testing.T) {
 	compiledRouter := App.Compile()
 	compiledRouter.ServeHTTP(response, request)
 	expect.Expect(t, response.Code, 200, "Status should be 200")
-	SubTestPostSnippets(t, instance, compiledRouter)
-	SubTestUsersRegister(t, instance, compiledRouter)
+	token := SubTestUsersRegister(t, instance, compiledRouter)
+	SubTestPostSnippets(t, instance, compiledRouter, token)
 }
 
-func SubTestPostSnippets(t *testing.T, instance aetest.Instance, App http.Handler) {
+func SubTestPostSnippets(t *testing.T, instance aetest.Instance, App http.Handler, token string) {
 	t.Log("POST /snippets/")
 	response := httptest.NewRecorder()
 	snippet := &app.Snippet{Title: "Snippet Title", Description: "Snippet Description"}
@@ -46,6 +48,7 @@ func SubTestPostSnippets(t *testing.T, instance aetest.Instance, App http.Handle
 	expect.Expect(t, err, nil)
 	request, err := instance.NewRequest("POST", "/snippets", buffer)
 	expect.Expect(t, err, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
 	App.ServeHTTP(response, request)
 	expect.Expect(t, response.Code, 303, "Status")
 	location := response.HeaderMap.Get("Location")
@@ -77,7 +80,8 @@ func SubTestListSnippets(t *testing.T, instance aetest.Instance, App http.Handle
 	expect.Expect(t, response.Code, http.StatusOK, "Status")
 }
 
-func SubTestUsersRegister(t *testing.T, instance aetest.Instance, App http.Handler) {
+// SubTestUsersRegister registers a user and returns the token of its login
+func SubTestUsersRegister(t *testing.T, instance aetest.Instance, App http.Handler) string {
 	t.Log("POST /users/register")
 	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", Password: "password"}
 	buffer := new(bytes.Buffer)
@@ -89,4 +93,389 @@ func SubTestUsersRegister(t *testing.T, instance aetest.Instance, App http.Handl
 	t.Log("Response : ", response.Body.String())
 	expect.Expect(t, response.Code, http.StatusCreated, "Status code")
 
+	t.Log("POST /users/login")
+	buffer = new(bytes.Buffer)
+	json.NewEncoder(buffer).Encode(app.Credentials{Login: user.Nickname, Password: "password"})
+	response = httptest.NewRecorder()
+	request, err = instance.NewRequest("POST", "/users/login", buffer)
+	expect.Expect(t, err, nil)
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusOK, "Status code")
+	login := struct{ Token string }{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&login), nil)
+	return login.Token
+}
+
+func SetUpMemoryApp() *app.App {
+	App := app.NewApp()
+	index, cache := app.NewMemorySearchIndex(), app.NewLRUCache(1000)
+	App.ContextFactory = app.NewRepositoryContextFactory(app.NewMemoryRepositoryFactory(), func(ctx context.Context) context.Context {
+		return app.WithCache(app.WithSearchIndex(ctx, index), cache)
+	})
+	return App
+}
+
+func TestStandardLogger_Log(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	app.NewStandardLogger(stdlog.New(buffer, "", 0)).Log(app.Info, "Migrations ", "done")
+	expect.Expect(t, buffer.String(), "INFO Migrations done\n")
+}
+
+func TestMemoryApp(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	token := SetUpUserToken(t, memoryApp, "JaneDoe")
+	App := memoryApp.Compile()
+
+	t.Log("POST /snippets/")
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, json.NewEncoder(buffer).Encode(&app.Snippet{Title: "Snippet Title", Description: "Snippet Description"}), nil)
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("POST", "/snippets", buffer, token))
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+	location := response.Header().Get("Location")
+	expect.Expect(t, strings.HasPrefix(location, "/snippets/"), true, location)
+
+	t.Logf("GET %s", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location, nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	result := &app.Snippet{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(result), nil)
+	expect.Expect(t, result.Title, "Snippet Title", "Snippet.Title")
+	expect.Expect(t, result.Version, int64(1), "Snippet.Version")
+
+	t.Log("POST /users/register")
+	buffer = new(bytes.Buffer)
+	json.NewEncoder(buffer).Encode(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", Password: "password"})
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("POST", "/users/register", buffer))
+	expect.Expect(t, response.Code, http.StatusCreated, "Status code", response.Body.String())
+}
+
+func TestMemoryApp_IndexPagination(t *testing.T) {
+	App := SetUpMemoryApp().Compile()
+	t.Log("GET /categories?limit=5&count=true")
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/categories?limit=5&count=true", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	categories := []*app.Category{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&categories), nil)
+	expect.Expect(t, len(categories), 5)
+	expect.Expect(t, response.Header().Get("X-Total-Count"), "18", "Categories seeded by 001-categories")
+	link := response.Header().Get("Link")
+	expect.Expect(t, strings.HasSuffix(link, `>; rel="next"`), true, link)
+
+	next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	t.Logf("GET %s", next)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", next, nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	nextCategories := []*app.Category{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&nextCategories), nil)
+	expect.Expect(t, len(nextCategories), 5)
+	expect.Expect(t, nextCategories[0].ID != categories[0].ID, true)
+
+	t.Log("GET /categories?cursor=invalid")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/categories?cursor=invalid", nil))
+	expect.Expect(t, response.Code, http.StatusBadRequest, "Status")
+}
+
+func TestMemoryApp_IndexFilter(t *testing.T) {
+	App := SetUpMemoryApp().Compile()
+	t.Log("GET /categories?filter[Title]=Go&fields=Title")
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/categories?filter[Title]=Go&fields=Title", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	categories := []*app.Category{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&categories), nil)
+	expect.Expect(t, len(categories), 1)
+	expect.Expect(t, categories[0].Title, "Go")
+	expect.Expect(t, categories[0].Description, "")
+
+	t.Log("GET /categories?filter[Description]=Go")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/categories?filter[Description]=Go", nil))
+	expect.Expect(t, response.Code, http.StatusBadRequest, "Status")
+}
+
+func TestMemoryApp_Search(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	token := SetUpUserToken(t, memoryApp, "JaneDoe")
+	App := memoryApp.Compile()
+	for _, snippet := range []*app.Snippet{
+		{Title: "Reverse a string", Description: "Reverses the runes of a string", Content: "func reverse(s string) string"},
+		{Title: "Read a file", Description: "Reads a whole file into a string", Content: "ioutil.ReadFile(name)"},
+	} {
+		buffer := new(bytes.Buffer)
+		expect.Expect(t, json.NewEncoder(buffer).Encode(snippet), nil)
+		response := httptest.NewRecorder()
+		App.ServeHTTP(response, NewAuthorizedRequest("POST", "/snippets", buffer, token))
+		expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+	}
+
+	t.Log("GET /snippets/search?q=string")
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/search?q=string", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	results := []app.SearchResult{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&results), nil)
+	expect.Expect(t, len(results), 2)
+	expect.Expect(t, results[0].Snippet.Title, "Reverse a string", "Title matches rank first")
+	expect.Expect(t, results[0].Highlights["Title"], "Reverse a <b>string</b>")
+
+	t.Log("GET /snippets/search?q=title:file")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/search?q=title:file", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	results = []app.SearchResult{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&results), nil)
+	expect.Expect(t, len(results), 1)
+	expect.Expect(t, results[0].Snippet.Title, "Read a file")
+
+	t.Log("GET /snippets/search")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/search", nil))
+	expect.Expect(t, response.Code, http.StatusBadRequest, "Status")
+}
+
+func TestMemoryApp_Revisions(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	token := SetUpUserToken(t, memoryApp, "JaneDoe")
+	App := memoryApp.Compile()
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, json.NewEncoder(buffer).Encode(&app.Snippet{Title: "Hello", Content: "hello\nworld\n"}), nil)
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("POST", "/snippets", buffer, token))
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+	location := response.Header().Get("Location")
+
+	t.Logf("PUT %s", location)
+	buffer = new(bytes.Buffer)
+	expect.Expect(t, json.NewEncoder(buffer).Encode(&app.Snippet{Title: "Hello", Content: "hello\ngopher\n", Version: 1}), nil)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("PUT", location, buffer, token))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+
+	t.Logf("GET %s/revisions", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location+"/revisions", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	revisions := []*app.SnippetRevision{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&revisions), nil)
+	expect.Expect(t, len(revisions), 2)
+
+	t.Logf("GET %s/revisions/1", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location+"/revisions/1", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	revision := &app.SnippetRevision{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(revision), nil)
+	expect.Expect(t, revision.Content, "hello\nworld\n")
+
+	t.Logf("GET %s/diff?from=1", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location+"/diff?from=1", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	expect.Expect(t, strings.Contains(response.Body.String(), "@@ -1,2 +1,2 @@\n hello\n-world\n+gopher\n"), true, response.Body.String())
+
+	t.Logf("POST %s/revert/1", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("POST", location+"/revert/1", nil, token))
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status", response.Body.String())
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location, nil))
+	snippet := &app.Snippet{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(snippet), nil)
+	expect.Expect(t, snippet.Content, "hello\nworld\n")
+	expect.Expect(t, snippet.Version, int64(3))
+
+	t.Logf("GET %s/revisions/9", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location+"/revisions/9", nil))
+	expect.Expect(t, response.Code, http.StatusNotFound, "Status")
+}
+
+func TestMemoryApp_ETag(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	authorization := "Bearer " + SetUpUserToken(t, memoryApp, "JaneDoe", app.RoleSuperAdmin)
+	App := memoryApp.Compile()
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, json.NewEncoder(buffer).Encode(&app.Category{Title: "Gopher", Description: "Gopher things"}), nil)
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/categories", buffer)
+	request.Header.Set("Authorization", authorization)
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+	location := response.Header().Get("Location")
+
+	t.Logf("GET %s", location)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", location, nil))
+	expect.Expect(t, response.Header().Get("ETag"), `"1"`)
+	expect.Expect(t, response.Header().Get("Last-Modified") != "", true)
+
+	t.Log("If-None-Match")
+	request = httptest.NewRequest("GET", location, nil)
+	request.Header.Set("If-None-Match", `W/"1"`)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusNotModified, "Status")
+
+	t.Log("PUT with a matching If-Match")
+	request = httptest.NewRequest("PUT", location, strings.NewReader(`{"Title":"Gophers","Description":"Gopher things"}`))
+	request.Header.Set("If-Match", `"1"`)
+	request.Header.Set("Authorization", authorization)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	expect.Expect(t, response.Header().Get("ETag"), `"2"`)
+
+	t.Log("PUT with a stale If-Match")
+	request = httptest.NewRequest("PUT", location, strings.NewReader(`{"Title":"Go","Description":"Gopher things"}`))
+	request.Header.Set("If-Match", `"1"`)
+	request.Header.Set("Authorization", authorization)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusPreconditionFailed, "Status")
+	conflict := &app.VersionConflict{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(conflict), nil)
+	expect.Expect(t, conflict.Version, int64(2))
+	expect.Expect(t, conflict.ETag, `"2"`)
+
+	t.Log("PUT with a stale version")
+	request = httptest.NewRequest("PUT", location, strings.NewReader(`{"Title":"Go","Description":"Gopher things","Version":1}`))
+	request.Header.Set("Authorization", authorization)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusConflict, "Status")
+
+	t.Log("DELETE with a stale If-Match")
+	request = httptest.NewRequest("DELETE", location, nil)
+	request.Header.Set("If-Match", `"1"`)
+	request.Header.Set("Authorization", authorization)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusPreconditionFailed, "Status")
+}
+
+func TestMemoryApp_UniqueViolation(t *testing.T) {
+	App := SetUpMemoryApp().Compile()
+	for i, nickname := range []string{"JaneDoe", "janedoe"} {
+		buffer := new(bytes.Buffer)
+		json.NewEncoder(buffer).Encode(&app.User{Nickname: nickname, Email: fmt.Sprintf("jane.doe%d@acme.com", i), Password: "password"})
+		response := httptest.NewRecorder()
+		App.ServeHTTP(response, httptest.NewRequest("POST", "/users/register", buffer))
+		if i == 0 {
+			expect.Expect(t, response.Code, http.StatusCreated, "Status", response.Body.String())
+		} else {
+			expect.Expect(t, response.Code, http.StatusConflict, "Status", response.Body.String())
+		}
+	}
+}
+
+func TestMemoryApp_ReferenceViolation(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	authorization := "Bearer " + SetUpUserToken(t, memoryApp, "JaneDoe", app.RoleSuperAdmin)
+	App := memoryApp.Compile()
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/snippets", strings.NewReader(`{"Title":"Hello","CategoryID":1}`))
+	request.Header.Set("Authorization", authorization)
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+
+	t.Log("DELETE /categories/1")
+	request = httptest.NewRequest("DELETE", "/categories/1", nil)
+	request.Header.Set("Authorization", authorization)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusConflict, "Status", response.Body.String())
+	body := struct{ References []app.Reference }{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&body), nil)
+	expect.Expect(t, len(body.References), 1)
+	expect.Expect(t, body.References[0].Kind, app.Kind.Snippets)
+}
+
+func TestMemoryApp_Admin(t *testing.T) {
+	App := SetUpMemoryApp()
+	App.AdminToken = "secret"
+	handler := App.Compile()
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/admin/export", nil))
+	expect.Expect(t, response.Code, http.StatusForbidden, "Status")
+
+	request := httptest.NewRequest("GET", "/admin/export", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	expect.Expect(t, strings.HasPrefix(response.Body.String(), `{"Format":"snipped-archive","Version":1`), true)
+
+	request = httptest.NewRequest("POST", "/admin/import", bytes.NewReader(response.Body.Bytes()))
+	request.Header.Set("Authorization", "Bearer secret")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	report := &app.ImportReport{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(report), nil)
+	expect.Expect(t, report.Reused[app.Kind.Categories], 18)
+}
+
+func TestMemoryApp_SnippetImportExport(t *testing.T) {
+	memoryApp := SetUpMemoryApp()
+	token := SetUpUserToken(t, memoryApp, "JaneDoe")
+	App := memoryApp.Compile()
+	response := httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("POST", "/snippets/import?format=vscode", strings.NewReader(`{
+		"Print": {"scope": "javascript", "prefix": "log", "body": ["console.log(${1:value});", "$0"]}
+	}`), token))
+	expect.Expect(t, response.Code, http.StatusCreated, "Status", response.Body.String())
+	imported := []*app.Snippet{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&imported), nil)
+	expect.Expect(t, len(imported), 1)
+
+	t.Log("GET /snippets/export?format=vscode&category=Javascript")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/export?format=vscode&category=Javascript", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	exported := map[string]map[string]interface{}{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&exported), nil)
+	expect.Expect(t, exported["Print"]["scope"], "javascript")
+	expect.Expect(t, exported["Print"]["prefix"], "log")
+	expect.Expect(t, exported["Print"]["body"], []interface{}{"console.log(${1:value});", "$0"})
+
+	t.Log("GET /snippets/export?format=sublime")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/export?format=sublime", nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	expect.Expect(t, response.Header().Get("Content-Type"), "application/zip")
+	snippets, err := app.SublimeFormat{}.Decode(response.Body)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Scope, "source.javascript")
+
+	url := fmt.Sprintf("/snippets/export?format=jupyter&ids=%d", imported[0].ID)
+	t.Logf("GET %s", url)
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	snippets, err = app.JupyterFormat{}.Decode(response.Body)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Title, "Print")
+
+	t.Log("GET /snippets/export?format=jupyter&ids=1000")
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, httptest.NewRequest("GET", "/snippets/export?format=jupyter&ids=1000", nil))
+	expect.Expect(t, response.Code, http.StatusNotFound, "Status")
+
+	for _, url := range []string{"/snippets/export?format=vim", "/snippets/export?format=jupyter&ids=one", "/snippets/export?format=vscode&category=Cobol"} {
+		t.Logf("GET %s", url)
+		response = httptest.NewRecorder()
+		App.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+		expect.Expect(t, response.Code, http.StatusBadRequest, "Status")
+	}
+	response = httptest.NewRecorder()
+	App.ServeHTTP(response, NewAuthorizedRequest("POST", "/snippets/import?format=vscode", strings.NewReader("{"), token))
+	expect.Expect(t, response.Code, http.StatusBadRequest, "Status")
 }
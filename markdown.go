@@ -0,0 +1,151 @@
+package smartsnippets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	RegisterSnippetFormat("markdown", MarkdownFormat{})
+}
+
+// MarkdownFormat imports the fenced code blocks of Markdown documents and exports snippets as a cheat sheet.
+//
+// Each fenced code block is a snippet, the language of its info string is its category,
+// the closest heading before it is its title and the text between that heading and the block is its description :
+//
+//	## Reverse a string
+//
+//	Reverses the runes of a string.
+//
+//	```go
+//	func reverse(s string) string
+//	```
+//
+// A single document or a zip archive of *.md and *.markdown documents is imported.
+type MarkdownFormat struct{}
+
+func (MarkdownFormat) ContentType() string { return "text/markdown; charset=utf-8" }
+
+func (format MarkdownFormat) Decode(r io.Reader) ([]*Snippet, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isZip(source) {
+		return decodeSnippetZip(source, []string{".md", ".markdown"}, format.decode)
+	}
+	return format.decode("", bytes.NewReader(source))
+}
+
+// decode extracts the fenced code blocks of a document,
+// name is the title of the blocks found before the first heading
+func (MarkdownFormat) decode(name string, r io.Reader) ([]*Snippet, error) {
+	snippets := []*Snippet{}
+	title, text := name, []string{}
+	var fence, indent string
+	var block *Snippet
+	content := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxSnippetImportSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " ")
+		if block != nil {
+			if closing := strings.TrimSpace(trimmed); len(line)-len(trimmed) < 4 && strings.HasPrefix(closing, fence) &&
+				strings.Trim(closing, fence[:1]) == "" {
+				block.Content = strings.Join(content, "\n")
+				snippets = append(snippets, block)
+				block, content, text = nil, []string{}, []string{}
+				continue
+			}
+			// the indentation of the opening fence is removed from the lines of the block
+			for i := 0; i < len(indent) && strings.HasPrefix(line, " "); i++ {
+				line = line[1:]
+			}
+			content = append(content, line)
+			continue
+		}
+		if len(line)-len(trimmed) < 4 {
+			if marker := markdownFence(trimmed); marker != "" {
+				info := strings.Fields(strings.TrimSpace(trimmed[len(marker):]))
+				fence, indent = marker, line[:len(line)-len(trimmed)]
+				block = &Snippet{Title: title, Description: strings.TrimSpace(strings.Join(text, "\n"))}
+				if len(info) > 0 {
+					block.Category = &Category{Title: strings.Trim(info[0], "{}.")}
+				}
+				if block.Title == "" {
+					block.Title = fmt.Sprintf("Snippet %d", len(snippets)+1)
+				}
+				continue
+			}
+			if strings.HasPrefix(trimmed, "#") {
+				if heading := strings.TrimLeft(trimmed, "#"); heading == "" || strings.HasPrefix(heading, " ") {
+					title, text = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(heading), "#")), []string{}
+					continue
+				}
+			}
+		}
+		text = append(text, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// an unclosed block runs until the end of the document
+	if block != nil {
+		block.Content = strings.Join(content, "\n")
+		snippets = append(snippets, block)
+	}
+	return snippets, nil
+}
+
+// markdownFence returns the fence opening a code block at the start of line, or ""
+func markdownFence(line string) string {
+	for _, char := range []string{"`", "~"} {
+		fence := line[:len(line)-len(strings.TrimLeft(line, char))]
+		if len(fence) >= 3 {
+			// the info string of a backtick fence cannot contain backticks
+			if char == "`" && strings.Contains(line[len(fence):], "`") {
+				return ""
+			}
+			return fence
+		}
+	}
+	return ""
+}
+
+// Encode writes a cheat sheet, titled after the category of the snippets if they share one
+func (MarkdownFormat) Encode(w io.Writer, snippets []*Snippet) error {
+	title := "Snippets"
+	for i, snippet := range snippets {
+		if snippet.Category == nil || (i > 0 && snippets[0].Category.ID != snippet.Category.ID) {
+			title = "Snippets"
+			break
+		}
+		title = snippet.Category.Title + " cheat sheet"
+	}
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "# %s\n", title)
+	for _, snippet := range snippets {
+		fmt.Fprintf(buffer, "\n## %s\n\n", strings.Replace(snippet.Title, "\n", " ", -1))
+		if description := strings.TrimSpace(snippet.Description); description != "" {
+			fmt.Fprintf(buffer, "%s\n\n", description)
+		}
+		language := ""
+		if snippet.Category != nil {
+			language = CategoryLanguage(snippet.Category)
+		}
+		// the fence is longer than any run of backticks of the content
+		fence := "```"
+		for strings.Contains(snippet.Content, fence) {
+			fence += "`"
+		}
+		fmt.Fprintf(buffer, "%s%s\n%s\n%s\n", fence, language, snippet.Content, fence)
+	}
+	_, err := buffer.WriteTo(w)
+	return err
+}
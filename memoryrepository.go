@@ -0,0 +1,511 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// MemoryStore holds the entities of MemoryRepository instances, by kind and by id
+type MemoryStore struct {
+	mutex       sync.RWMutex
+	transaction sync.Mutex
+	entities    map[string]map[int64]reflect.Value
+	ids         map[string]int64
+}
+
+// NewMemoryStore creates a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entities: map[string]map[int64]reflect.Value{}, ids: map[string]int64{}}
+}
+
+func (store *MemoryStore) allocateID(kind string) int64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.ids[kind]++
+	return store.ids[kind]
+}
+
+func (store *MemoryStore) get(kind string, id int64) (reflect.Value, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	value, ok := store.entities[kind][id]
+	return value, ok
+}
+
+// put stores value, the previous value is recorded in undo if it is not nil
+func (store *MemoryStore) put(kind string, id int64, value reflect.Value, undo *memoryUndoLog) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	undo.record(store, kind, id)
+	if store.entities[kind] == nil {
+		store.entities[kind] = map[int64]reflect.Value{}
+	}
+	store.entities[kind][id] = value
+}
+
+// delete removes an entity, its value is recorded in undo if it is not nil
+func (store *MemoryStore) delete(kind string, id int64, undo *memoryUndoLog) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	undo.record(store, kind, id)
+	delete(store.entities[kind], id)
+}
+
+// all returns the entities of a kind in key order
+func (store *MemoryStore) all(kind string) []reflect.Value {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	ids := make([]int64, 0, len(store.entities[kind]))
+	for id := range store.entities[kind] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	values := make([]reflect.Value, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, store.entities[kind][id])
+	}
+	return values
+}
+
+// memoryUndoLog records the values a transaction overwrote, so only the entities
+// written by the transaction are restored when it fails
+type memoryUndoLog struct {
+	entries []memoryUndoEntry
+}
+
+type memoryUndoEntry struct {
+	kind    string
+	id      int64
+	value   reflect.Value
+	existed bool
+}
+
+// record keeps the current value of an entity, it is called with the lock of the store held
+func (undo *memoryUndoLog) record(store *MemoryStore, kind string, id int64) {
+	if undo == nil {
+		return
+	}
+	value, existed := store.entities[kind][id]
+	undo.entries = append(undo.entries, memoryUndoEntry{kind, id, value, existed})
+}
+
+// rollback restores the recorded values, newest first
+func (undo *memoryUndoLog) rollback(store *MemoryStore) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := len(undo.entries) - 1; i >= 0; i-- {
+		entry := undo.entries[i]
+		if !entry.existed {
+			delete(store.entities[entry.kind], entry.id)
+			continue
+		}
+		if store.entities[entry.kind] == nil {
+			store.entities[entry.kind] = map[int64]reflect.Value{}
+		}
+		store.entities[entry.kind][entry.id] = entry.value
+	}
+	undo.entries = nil
+}
+
+// MemoryRepositoryFactory creates MemoryRepository instances sharing the same MemoryStore
+type MemoryRepositoryFactory struct {
+	Store *MemoryStore
+}
+
+// NewMemoryRepositoryFactory creates a MemoryRepositoryFactory with an empty store
+func NewMemoryRepositoryFactory() *MemoryRepositoryFactory {
+	return &MemoryRepositoryFactory{Store: NewMemoryStore()}
+}
+
+func (factory *MemoryRepositoryFactory) Create(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	repository := NewMemoryRepository(factory.Store, kind, listeners...)
+	repository.undo, _ = ctx.Value(MemoryUndoLogKey).(*memoryUndoLog)
+	return repository
+}
+
+// RunInTransaction runs transactions one at a time,
+// the entities written by f are restored if f fails. Writes made meanwhile outside of
+// the transaction are kept
+func (factory *MemoryRepositoryFactory) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	factory.Store.transaction.Lock()
+	defer factory.Store.transaction.Unlock()
+	undo := &memoryUndoLog{}
+	if err := f(context.WithValue(ctx, MemoryUndoLogKey, undo)); err != nil {
+		undo.rollback(factory.Store)
+		return err
+	}
+	return nil
+}
+
+// MemoryRepository is an in process implementation of Repository.
+// It understands the same queries and dispatches the same events as DefaultRepository
+type MemoryRepository struct {
+	Store  *MemoryStore
+	Kind   string
+	Signal signal.Signal
+	// undo records the writes of the transaction of the repository, if any
+	undo *memoryUndoLog
+}
+
+// NewMemoryRepository creates a new MemoryRepository
+func NewMemoryRepository(store *MemoryStore, kind string, listeners ...signal.Listener) *MemoryRepository {
+	return &MemoryRepository{Store: store, Kind: kind, Signal: NewRepositorySignal(listeners...)}
+}
+
+// Create an entity
+func (repository MemoryRepository) Create(entity Entity) error {
+	entity.SetID(repository.Store.allocateID(repository.Kind))
+	return repository.CreateWithID(entity)
+}
+
+// CreateWithID creates an entity with the ID it already has
+func (repository MemoryRepository) CreateWithID(entity Entity) error {
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityCreatedEvent{entity}); err != nil {
+			return err
+		}
+	}
+	repository.Store.put(repository.Kind, entity.GetID(), copyEntity(entity), repository.undo)
+	if repository.Signal != nil {
+		return repository.Signal.Dispatch(AfterEntityCreatedEvent{entity})
+	}
+	return nil
+}
+
+// Update an entity
+func (repository MemoryRepository) Update(entity Entity) error {
+	value, ok := repository.Store.get(repository.Kind, entity.GetID())
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	old := reflect.New(reflect.Indirect(reflect.ValueOf(entity)).Type()).Interface()
+	if err := loadEntity(value, old); err != nil {
+		return err
+	}
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityUpdatedEvent{old.(Entity), entity}); err != nil {
+			return err
+		}
+	}
+	repository.Store.put(repository.Kind, entity.GetID(), copyEntity(entity), repository.undo)
+	if repository.Signal != nil {
+		return repository.Signal.Dispatch(AfterEntityUpdatedEvent{entity})
+	}
+	return nil
+}
+
+// Delete an entity
+func (repository MemoryRepository) Delete(entity Entity) error {
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityDeletedEvent{entity}); err != nil {
+			return err
+		}
+	}
+	repository.Store.delete(repository.Kind, entity.GetID(), repository.undo)
+	if repository.Signal != nil {
+		return repository.Signal.Dispatch(AfterEntityDeletedEvent{entity})
+	}
+	return nil
+}
+
+// FindByID gets an entity by id
+func (repository MemoryRepository) FindByID(id int64, entity Entity) error {
+	value, ok := repository.Store.get(repository.Kind, id)
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	return loadEntity(value, entity)
+}
+
+// FindAll returns all entities
+func (repository MemoryRepository) FindAll(entities interface{}) error {
+	return repository.FindBy(Query{}, entities)
+}
+
+func (repository MemoryRepository) FindBy(query Query, result interface{}) error {
+	_, err := repository.FindPage(query, result)
+	return err
+}
+
+// FindPage appends at most query.Limit entities to result
+// and returns the cursor of the next page, or an empty string on the last page
+func (repository MemoryRepository) FindPage(query Query, result interface{}) (string, error) {
+	start, err := decodeOffsetCursor(query.Cursor)
+	if err != nil {
+		return "", err
+	}
+	query.Offset += start
+	limit, next := query.Limit, ""
+	if limit > 0 {
+		// fetch one more entity to know if there is a next page
+		query.Limit = limit + 1
+	}
+	values, err := repository.execute(query)
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 && len(values) > limit {
+		values, next = values[:limit], encodeOffsetCursor(query.Offset+limit)
+	}
+	slice := reflect.ValueOf(result)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("MemoryRepository: result should be a pointer to a slice, got %T", result)
+	}
+	slice = slice.Elem()
+	elementType := slice.Type().Elem()
+	for _, value := range values {
+		if len(query.Fields) > 0 {
+			if value, err = projectEntity(value, query.Fields); err != nil {
+				return "", err
+			}
+		}
+		var element reflect.Value
+		if elementType.Kind() == reflect.Ptr {
+			element = reflect.New(elementType.Elem())
+		} else {
+			element = reflect.New(elementType)
+		}
+		if err = loadEntity(value, element.Interface()); err != nil {
+			return "", err
+		}
+		if elementType.Kind() != reflect.Ptr {
+			element = element.Elem()
+		}
+		slice.Set(reflect.Append(slice, element))
+	}
+	return next, nil
+}
+
+func (repository MemoryRepository) Count(query Query) (int, error) {
+	start, err := decodeOffsetCursor(query.Cursor)
+	if err != nil {
+		return 0, err
+	}
+	query.Offset += start
+	values, err := repository.execute(query)
+	return len(values), err
+}
+
+// execute filters, orders and paginates the entities of the repository kind
+func (repository MemoryRepository) execute(query Query) ([]reflect.Value, error) {
+	values := []reflect.Value{}
+	filters := make([]queryFilter, 0, len(query.Query))
+	for key, value := range query.Query {
+		filter, err := parseQueryFilter(key, value)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	for _, value := range repository.Store.all(repository.Kind) {
+		match := true
+		for _, filter := range filters {
+			ok, err := filter.match(value)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			values = append(values, value)
+		}
+	}
+	var sortErr error
+	if len(query.Order) > 0 {
+		sort.SliceStable(values, func(i, j int) bool {
+			for _, order := range query.Order {
+				name, descending := strings.TrimPrefix(order, "-"), strings.HasPrefix(order, "-")
+				a, aok := propertyByName(values[i], name)
+				b, bok := propertyByName(values[j], name)
+				if !aok || !bok {
+					continue
+				}
+				result, err := compareValues(a.Interface(), b.Interface())
+				if err != nil {
+					sortErr = err
+					return false
+				}
+				if result != 0 {
+					return (result < 0) != descending
+				}
+			}
+			return false
+		})
+	}
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	if query.Offset > 0 {
+		if query.Offset >= len(values) {
+			return []reflect.Value{}, nil
+		}
+		values = values[query.Offset:]
+	}
+	if query.Limit > 0 && query.Limit < len(values) {
+		values = values[:query.Limit]
+	}
+	return values, nil
+}
+
+func (filter queryFilter) match(entity reflect.Value) (bool, error) {
+	property, ok := propertyByName(entity, filter.Property)
+	if !ok {
+		// like the datastore, entities without the property never match
+		return false, nil
+	}
+	result, err := compareValues(property.Interface(), filter.Value)
+	if err != nil {
+		return false, err
+	}
+	switch filter.Operator {
+	case "=":
+		return result == 0, nil
+	case "!=":
+		return result != 0, nil
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	case ">=":
+		return result >= 0, nil
+	}
+	return false, fmt.Errorf("MemoryRepository: invalid operator %q", filter.Operator)
+}
+
+// compareValues compares 2 property values, returning -1, 0 or 1
+func compareValues(a, b interface{}) (int, error) {
+	if a, ok := a.(time.Time); ok {
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, fmt.Errorf("MemoryRepository: cannot compare %T and %T", a, b)
+		}
+		switch {
+		case a.Before(b):
+			return -1, nil
+		case a.After(b):
+			return 1, nil
+		}
+		return 0, nil
+	}
+	aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isInteger(aValue) && isInteger(bValue):
+		a, b := toInt64(aValue), toInt64(bValue)
+		switch {
+		case a < b:
+			return -1, nil
+		case a > b:
+			return 1, nil
+		}
+		return 0, nil
+	case isNumber(aValue) && isNumber(bValue):
+		return compareFloats(toFloat64(aValue), toFloat64(bValue)), nil
+	case aValue.Kind() == reflect.String && bValue.Kind() == reflect.String:
+		return strings.Compare(aValue.String(), bValue.String()), nil
+	case aValue.Kind() == reflect.Bool && bValue.Kind() == reflect.Bool:
+		if aValue.Bool() == bValue.Bool() {
+			return 0, nil
+		}
+		if bValue.Bool() {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("MemoryRepository: cannot compare %T and %T", a, b)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isInteger(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isNumber(value reflect.Value) bool {
+	return isInteger(value) || value.Kind() == reflect.Float32 || value.Kind() == reflect.Float64
+}
+
+func toInt64(value reflect.Value) int64 {
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	}
+	return value.Int()
+}
+
+func toFloat64(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	}
+	return float64(value.Int())
+}
+
+// copyEntity returns a copy of the stored properties of an entity
+func copyEntity(entity interface{}) reflect.Value {
+	source := reflect.Indirect(reflect.ValueOf(entity))
+	stored := reflect.New(source.Type()).Elem()
+	for i := 0; i < source.NumField(); i++ {
+		if _, ok := propertyName(source.Type().Field(i)); ok {
+			stored.Field(i).Set(source.Field(i))
+		}
+	}
+	return stored
+}
+
+// loadEntity copies the stored properties of value into entity,
+// leaving fields that are not stored untouched
+func loadEntity(value reflect.Value, entity interface{}) error {
+	destination := reflect.ValueOf(entity)
+	if destination.Kind() != reflect.Ptr || destination.Elem().Type() != value.Type() {
+		return fmt.Errorf("MemoryRepository: cannot load %s into %T", value.Type(), entity)
+	}
+	destination = destination.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if _, ok := propertyName(value.Type().Field(i)); ok {
+			destination.Field(i).Set(value.Field(i))
+		}
+	}
+	return nil
+}
+
+// projectEntity returns a copy of value with only fields set, like a datastore projection query
+func projectEntity(value reflect.Value, fields []string) (reflect.Value, error) {
+	projection := reflect.New(value.Type()).Elem()
+	for _, field := range fields {
+		property, ok := propertyByName(value, field)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("MemoryRepository: unknown property %q in projection", field)
+		}
+		target, _ := propertyByName(projection, field)
+		target.Set(property)
+	}
+	return projection, nil
+}
@@ -0,0 +1,142 @@
+package smartsnippets_test
+
+import (
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"github.com/Mparaiso/tiger-go-framework/validator"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func SetUpMemoryContext() context.Context {
+	return app.WithRepositoryFactory(context.Background(), app.NewMemoryRepositoryFactory())
+}
+
+func TestMemoryRepository_Create(t *testing.T) {
+	repository := app.NewCategoryRepository(SetUpMemoryContext())
+	category := &app.Category{Title: "Go", Description: "The Go Language"}
+	err := repository.Create(category)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, category.ID > 0, true)
+	expect.Expect(t, category.Version, int64(1))
+	expect.Expect(t, category.Created.IsZero(), false)
+	result := &app.Category{}
+	err = repository.FindByID(category.ID, result)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, result.Title, "Go")
+	err = repository.FindByID(category.ID+1, result)
+	expect.Expect(t, err, datastore.ErrNoSuchEntity)
+}
+
+func TestMemoryRepository_Update(t *testing.T) {
+	repository := app.NewCategoryRepository(SetUpMemoryContext())
+	category := &app.Category{Title: "Go", Description: "The Go Language"}
+	expect.Expect(t, repository.Create(category), nil)
+	category.Title = "Golang"
+	expect.Expect(t, repository.Update(category), nil)
+	expect.Expect(t, category.Version, int64(2))
+	t.Log("Stale version")
+	stale := &app.Category{ID: category.ID, Title: "Go", Version: 1}
+	expect.Expect(t, repository.Update(stale) != nil, true)
+	result := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, result), nil)
+	expect.Expect(t, result.Title, "Golang")
+}
+
+func TestMemoryRepository_Delete(t *testing.T) {
+	repository := app.NewCategoryRepository(SetUpMemoryContext())
+	category := &app.Category{Title: "Go", Description: "The Go Language"}
+	expect.Expect(t, repository.Create(category), nil)
+	expect.Expect(t, repository.Delete(category), nil)
+	count, err := repository.Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+}
+
+func TestMemoryRepository_FindBy(t *testing.T) {
+	repository := app.NewSnippetRepository(SetUpMemoryContext())
+	for _, snippet := range []*app.Snippet{
+		{Title: "C", CategoryID: 1},
+		{Title: "A", CategoryID: 2},
+		{Title: "B", CategoryID: 1},
+		{Title: "D", CategoryID: 1},
+	} {
+		expect.Expect(t, repository.Create(snippet), nil)
+	}
+	snippets := []*app.Snippet{}
+	err := repository.FindBy(app.Query{Query: map[string]interface{}{"CategoryID=": 1}, Order: []string{"-Title"}}, &snippets)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 3)
+	expect.Expect(t, snippets[0].Title, "D")
+	expect.Expect(t, snippets[2].Title, "B")
+
+	t.Log("Limit, Offset and Fields")
+	snippets = []*app.Snippet{}
+	err = repository.FindBy(app.Query{Order: []string{"Title"}, Fields: []string{"Title"}, Limit: 2, Offset: 1}, &snippets)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 2)
+	expect.Expect(t, snippets[0].Title, "B")
+	expect.Expect(t, snippets[0].CategoryID, int64(0))
+
+	t.Log("Inequality filter")
+	count, err := repository.Count(app.Query{Query: map[string]interface{}{"ID >": int64(2)}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 2)
+}
+
+func TestMemoryRepository_ExecuteMigrations(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	err := app.ExecuteMigrations(ctx, app.GetMigrations())
+	expect.Expect(t, err, nil)
+	err = app.ExecuteMigrations(ctx, app.GetMigrations())
+	expect.Expect(t, err, nil)
+	roles := []*app.Role{}
+	err = app.NewRoleRepository(ctx).FindBy(app.Query{Query: map[string]interface{}{"Name=": "User"}}, &roles)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(roles), 1)
+	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", Password: "Password"}
+	err = app.NewUserRepository(ctx).Create(user)
+	expect.Expect(t, err, nil)
+	count, err := app.NewUserRoleRepository(ctx).Count(app.Query{Query: map[string]interface{}{"UserID=": user.ID}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 1)
+}
+
+func TestMemoryRepository_CategoryValidator(t *testing.T) {
+	categoryRepository := app.NewCategoryRepository(SetUpMemoryContext())
+	category := &app.Category{Title: "PHP", Description: "PHP language"}
+	categoryValidator := &app.CategoryValidator{&app.DefaultUniqueEntityValidatorProvider{categoryRepository}}
+	expect.Expect(t, categoryValidator.Validate(category), nil)
+	expect.Expect(t, categoryRepository.Create(category), nil)
+	err := categoryValidator.Validate(&app.Category{Title: "PHP", Description: "PHP language"})
+	expect.Expect(t, err != nil, true)
+	_, ok := err.(*validator.ConcreteError).GetErrors()["Title"]
+	expect.Expect(t, ok, true)
+}
+
+func TestMemoryRepository_FindPage(t *testing.T) {
+	repository := app.NewCategoryRepository(SetUpMemoryContext())
+	for _, title := range []string{"A", "B", "C", "D", "E"} {
+		expect.Expect(t, repository.Create(&app.Category{Title: title}), nil)
+	}
+	titles, cursor, pages := []string{}, "", 0
+	for {
+		categories := []*app.Category{}
+		next, err := repository.FindPage(app.Query{Order: []string{"Title"}, Limit: 2, Cursor: cursor}, &categories)
+		expect.Expect(t, err, nil)
+		for _, category := range categories {
+			titles = append(titles, category.Title)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	expect.Expect(t, pages, 3)
+	expect.Expect(t, titles, []string{"A", "B", "C", "D", "E"})
+	_, err := repository.FindPage(app.Query{Cursor: "not a cursor"}, &[]*app.Category{})
+	expect.Expect(t, err, app.ErrInvalidCursor)
+}
@@ -0,0 +1,154 @@
+package smartsnippets
+
+import (
+	"html"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// searchFieldWeights is the score of a match in each field
+var searchFieldWeights = map[string]float64{"Title": 4, "Category": 3, "Description": 2, "Content": 1}
+
+// highlightContext is the number of bytes kept around the first match of a highlight
+const highlightContext = 40
+
+// MemorySearchIndex is an in process SearchIndex,
+// documents are scanned on each search so it suits tests and small libraries
+type MemorySearchIndex struct {
+	mutex     sync.RWMutex
+	documents map[int64]SearchDocument
+}
+
+// NewMemorySearchIndex creates an empty MemorySearchIndex
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{documents: map[int64]SearchDocument{}}
+}
+
+func (index *MemorySearchIndex) Put(ctx context.Context, document SearchDocument) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.documents[document.ID] = document
+	return nil
+}
+
+func (index *MemorySearchIndex) Delete(ctx context.Context, id int64) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	delete(index.documents, id)
+	return nil
+}
+
+func (index *MemorySearchIndex) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	clauses, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	results := []SearchResult{}
+	for _, document := range index.documents {
+		if result, ok := matchSearchDocument(document, clauses); ok {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// matchSearchDocument scores document if every clause matches one of its fields
+func matchSearchDocument(document SearchDocument, clauses []searchClause) (SearchResult, bool) {
+	fields := map[string]string{
+		"Title": document.Title, "Description": document.Description,
+		"Content": document.Content, "Category": document.Category,
+	}
+	tokens := map[string][]searchToken{}
+	for name, text := range fields {
+		tokens[name] = tokenize(text)
+	}
+	result := SearchResult{ID: document.ID, Highlights: map[string]string{}}
+	spans := map[string][][2]int{}
+	for _, clause := range clauses {
+		matched := false
+		for name := range fields {
+			if clause.Field != "" && clause.Field != name {
+				continue
+			}
+			for _, span := range findPhrase(tokens[name], clause.Terms) {
+				matched = true
+				result.Score += searchFieldWeights[name]
+				spans[name] = append(spans[name], span)
+			}
+		}
+		if !matched {
+			return result, false
+		}
+	}
+	for name, fieldSpans := range spans {
+		result.Highlights[name] = highlight(fields[name], fieldSpans)
+	}
+	return result, true
+}
+
+// findPhrase returns the byte spans where terms appear consecutively in tokens
+func findPhrase(tokens []searchToken, terms []string) [][2]int {
+	spans := [][2]int{}
+	for i := 0; i+len(terms) <= len(tokens); i++ {
+		match := true
+		for j, term := range terms {
+			if tokens[i+j].Text != term {
+				match = false
+				break
+			}
+		}
+		if match {
+			spans = append(spans, [2]int{tokens[i].Start, tokens[i+len(terms)-1].End})
+		}
+	}
+	return spans
+}
+
+// highlight returns the fragment of text around its first span, with spans wrapped in <b> tags
+func highlight(text string, spans [][2]int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	start, end := spans[0][0]-highlightContext, spans[0][1]+highlightContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	fragment := ""
+	if start > 0 {
+		fragment += "..."
+	}
+	position := start
+	for _, span := range spans {
+		if span[0] < position || span[1] > end {
+			continue
+		}
+		fragment += html.EscapeString(text[position:span[0]]) + "<b>" + html.EscapeString(text[span[0]:span[1]]) + "</b>"
+		position = span[1]
+	}
+	fragment += html.EscapeString(text[position:end])
+	if end < len(text) {
+		fragment += "..."
+	}
+	return fragment
+}
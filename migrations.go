@@ -1,7 +1,12 @@
 package smartsnippets
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -23,90 +28,352 @@ func GetRootKey(ctx context.Context) *datastore.Key {
 	return datastore.NewKey(ctx, "Ancestors", "Root", 0, nil)
 }
 
-// GetMigrations get al list of migrations
+// defaultCategories are the categories seeded by 001-categories
+var defaultCategories = []Category{
+	{Title: "PHP", Description: "The PHP Language"},
+	{Title: "Javascript", Description: "The Javascript Language"},
+	{Title: "Go", Description: "The Go Language"},
+	{Title: "Java", Description: "The Java Language"},
+	{Title: "Ruby", Description: "The Ruby Language"},
+	{Title: "Python", Description: "The Python Language"},
+	{Title: "C", Description: "The C Language"},
+	{Title: "C++", Description: "The C++ Language"},
+	{Title: "SQL", Description: "The SQL Query Language"},
+	{Title: "Scala", Description: "The Scala Language"},
+	{Title: "Rust", Description: "The Rust Language"},
+	{Title: "LISP", Description: "The LISP Language"},
+	{Title: "HTML", Description: "The HTML Markup Language"},
+	{Title: "XML", Description: "The XML Language"},
+	{Title: "CSS", Description: "The CSS Language"},
+	{Title: "Typescript", Description: "The Typescript Language"},
+	{Title: "Swift", Description: "The Swift Language"},
+	{Title: "Objective-C", Description: "The Objective-C Language"},
+}
+
+// defaultRoles are the roles seeded by 002-Roles
+var defaultRoles = []Role{
+	{Name: "Root", Description: "The root administrators", Locked: true},
+	{Name: "SuperAdmin", Description: "The Super Administrators", Locked: true},
+	{Name: "User", Description: "Basic User", Locked: true},
+	{Name: "Anonymous", Description: "Unauthenticated user, has no rights", Locked: true},
+}
+
+// GetMigrations get al list of migrations,
+// they are executed in the order of their Created date which must follow their dependencies
 func GetMigrations() []*Migration {
+	categories, roles := []string{}, []string{}
+	for _, category := range defaultCategories {
+		categories = append(categories, category.Title+" : "+category.Description)
+	}
+	for _, role := range defaultRoles {
+		roles = append(roles, fmt.Sprintf("%s : %s : %t", role.Name, role.Description, role.Locked))
+	}
 
 	return []*Migration{
 		{
-			Created: MustParse(Rfc2822, "Wed, 26 Oct 2016 17:30:30 +0200"), Name: "000-root-ancestor", Task: func(ctx context.Context) error {
-
+			Created: MustParse(Rfc2822, "Fri, 21 Oct 2016 09:00:00 +0200"), Name: "000-root-ancestor", Source: "Ancestors : Root", Task: func(ctx context.Context) error {
+				if !UsesDatastore(ctx) {
+					// only the datastore needs a root ancestor
+					return nil
+				}
 				rootKey := datastore.NewKey(ctx, "Ancestors", "Root", 0, nil)
 				_, err := datastore.Put(ctx, rootKey, &Ancestor{ID: "Root"})
 				return err
+			}, Down: func(ctx context.Context) error {
+				if !UsesDatastore(ctx) {
+					return nil
+				}
+				return datastore.Delete(ctx, GetRootKey(ctx))
 			}},
 		{
-			Name: "001-categories", Created: MustParse(Rfc2822, "Fri, 21 Oct 2016 09:11:26 +0200"), Task: func(ctx context.Context) error {
-				categories := []*Category{
-					{Title: "PHP", Description: "The PHP Language"},
-					{Title: "Javascript", Description: "The Javascript Language"},
-					{Title: "Go", Description: "The Go Language"},
-					{Title: "Java", Description: "The Java Language"},
-					{Title: "Ruby", Description: "The Ruby Language"},
-					{Title: "Python", Description: "The Python Language"},
-					{Title: "C", Description: "The C Language"},
-					{Title: "C++", Description: "The C++ Language"},
-					{Title: "SQL", Description: "The SQL Query Language"},
-					{Title: "Scala", Description: "The Scala Language"},
-					{Title: "Rust", Description: "The Rust Language"},
-					{Title: "LISP", Description: "The LISP Language"},
-					{Title: "HTML", Description: "The HTML Markup Language"},
-					{Title: "XML", Description: "The XML Language"},
-					{Title: "CSS", Description: "The CSS Language"},
-					{Title: "Typescript", Description: "The Typescript Language"},
-					{Title: "Swift", Description: "The Swift Language"},
-					{Title: "Objective-C", Description: "The Objective-C Language"},
-				}
+			Name: "001-categories", Created: MustParse(Rfc2822, "Fri, 21 Oct 2016 09:11:26 +0200"), Source: strings.Join(categories, "\n"), Task: func(ctx context.Context) error {
 				repository := NewCategoryRepository(ctx)
-				for _, category := range categories {
-					err := repository.Create(category)
+				for _, category := range defaultCategories {
+					category := category
+					err := repository.Create(&category)
 					if err != nil {
 						return fmt.Errorf("Error creating category %+v : %v", category, err)
 					}
 				}
 				return nil
+			}, Down: func(ctx context.Context) error {
+				repository := NewCategoryRepository(ctx)
+				for _, category := range defaultCategories {
+					if err := deleteByField(repository, &[]*Category{}, "Title", category.Title); err != nil {
+						return fmt.Errorf("Error deleting category %s : %v", category.Title, err)
+					}
+				}
+				return nil
 			},
 		}, {
-			Name: "002-Roles", Created: MustParse(Rfc2822, "Mon, 24 Oct 2016 04:12:36 +0200"), Task: func(ctx context.Context) error {
-				roles := []*Role{
-					{Name: "Root", Description: "The root administrators", Locked: true},
-					{Name: "SuperAdmin", Description: "The Super Administrators", Locked: true},
-					{Name: "User", Description: "Basic User", Locked: true},
-					{Name: "Anonymous", Description: "Unauthenticated user, has no rights", Locked: true},
-				}
+			Name: "002-Roles", Created: MustParse(Rfc2822, "Mon, 24 Oct 2016 04:12:36 +0200"), Source: strings.Join(roles, "\n"), Task: func(ctx context.Context) error {
 				repository := NewRoleRepository(ctx)
-				for _, role := range roles {
-					err := repository.Create(role)
+				for _, role := range defaultRoles {
+					role := role
+					err := repository.Create(&role)
 					if err != nil {
 						return fmt.Errorf("Error creating role %+v : %v", role, err)
 					}
 				}
 
+				return nil
+			}, Down: func(ctx context.Context) error {
+				repository := NewRoleRepository(ctx)
+				for _, role := range defaultRoles {
+					if err := deleteByField(repository, &[]*Role{}, "Name", role.Name); err != nil {
+						return fmt.Errorf("Error deleting role %s : %v", role.Name, err)
+					}
+				}
 				return nil
 			},
-		}, {Name: "003-users", Created: MustParse("02 Jan 06 15:04 -0700", "18 Oct 16 08:49 +0200"), Task: func(ctx context.Context) error {
+		}, {Name: "003-users", Created: MustParse(Rfc2822, "Mon, 24 Oct 2016 04:20:00 +0200"), Source: "Users : Anonymous", Task: func(ctx context.Context) error {
 			user := &User{Nickname: "Anonymous"}
 			return NewUserRepository(ctx).Create(user)
 
+		}, Down: func(ctx context.Context) error {
+			return deleteByField(NewUserRepository(ctx), &[]*User{}, "Nickname", "Anonymous")
 		}},
+		{Name: "004-unique-reservations", Created: MustParse(Rfc2822, "Sat, 17 Oct 2026 10:00:00 +0200"),
+			Source: "Reservations of the unique values of the existing entities", Task: ReserveUniqueValues, Down: ReleaseUniqueValues},
 	}
 }
 
-// ExecuteMigrations execute all migrations
+// deleteByField deletes the entities of repository whose field equals value,
+// entities is a pointer to a slice of entity pointers
+func deleteByField(repository Repository, entities interface{}, field string, value interface{}) error {
+	if err := repository.FindBy(Query{Query: map[string]interface{}{field + "=": value}}, entities); err != nil {
+		return err
+	}
+	found := reflect.ValueOf(entities).Elem()
+	for i := 0; i < found.Len(); i++ {
+		if err := repository.Delete(found.Index(i).Interface().(Entity)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var ErrMigrationNotFound = fmt.Errorf("ErrMigrationNotFound")
+
+// ErrMigrationChecksum is returned when an applied migration was edited
+type ErrMigrationChecksum struct {
+	Name, Recorded, Current string
+}
+
+func (err ErrMigrationChecksum) Error() string {
+	return fmt.Sprintf("ErrMigrationChecksum : migration %s was edited after it was applied, recorded checksum %s, current checksum %s", err.Name, err.Recorded, err.Current)
+}
+
+// ErrMigrationIrreversible is returned when a migration without Down is rolled back
+type ErrMigrationIrreversible struct {
+	Name string
+}
+
+func (err ErrMigrationIrreversible) Error() string {
+	return fmt.Sprintf("ErrMigrationIrreversible : migration %s has no Down task", err.Name)
+}
+
+// ErrMigrationSource is returned when a migration has no Source, its edits could not be detected
+type ErrMigrationSource struct {
+	Name string
+}
+
+func (err ErrMigrationSource) Error() string {
+	return fmt.Sprintf("ErrMigrationSource : migration %s has no Source", err.Name)
+}
+
+// MigrationChecksum returns the checksum of the declaration of a migration
+func MigrationChecksum(migration *Migration) string {
+	sum := sha256.Sum256([]byte(migration.Name + "\n" + migration.Created.UTC().Format(time.RFC3339) + "\n" + migration.Source))
+	return hex.EncodeToString(sum[:])
+}
+
+// SortMigrations returns the migrations ordered by Created then Name
+func SortMigrations(migrations []*Migration) []*Migration {
+	sorted := append([]*Migration{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Created.Equal(sorted[j].Created) {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Created.Before(sorted[j].Created)
+	})
+	return sorted
+}
+
+// findMigrationRecord returns the record of the migration named name, or nil
+func findMigrationRecord(repositories Repositories, name string) (*Migration, error) {
+	records := []*Migration{}
+	err := repositories.Migrations().FindBy(Query{Query: map[string]interface{}{"Name=": name}, Limit: 1}, &records)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	// records written before statuses existed are applied migrations
+	if records[0].Status == "" {
+		records[0].Status = MigrationApplied
+	}
+	return records[0], nil
+}
+
+// saveMigrationRecord creates or updates the record of a migration
+func saveMigrationRecord(repositories Repositories, record *Migration) error {
+	if record.ID == 0 {
+		return repositories.Migrations().Create(record)
+	}
+	return repositories.Migrations().Update(record)
+}
+
+// MigrationStatuses returns the records of migrations, in the order they are executed.
+// Migrations that were never recorded are pending
+func MigrationStatuses(ctx context.Context, migrations []*Migration) ([]*Migration, error) {
+	statuses := []*Migration{}
+	for _, migration := range SortMigrations(migrations) {
+		record, err := findMigrationRecord(Repositories{ctx}, migration.Name)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			record = &Migration{Name: migration.Name, Status: MigrationPending, Checksum: MigrationChecksum(migration)}
+		}
+		statuses = append(statuses, record)
+	}
+	return statuses, nil
+}
+
+// ExecuteMigrations execute all migrations in the order of their Created date.
+// Each migration is recorded as pending, then executed and recorded as applied in a transaction.
+// Batch migrations are executed a batch per transaction, if the deadline of the context comes first
+// ErrMigrationsInProgress is returned and the next execution resumes them.
+// A migration that fails is recorded as failed with its error, the following migrations are not executed.
+// Applied migrations are skipped, unless their checksum changed, then ErrMigrationChecksum is returned.
+// Every migration must have a Source, otherwise ErrMigrationSource is returned and none is executed.
 func ExecuteMigrations(ctx context.Context, migrations []*Migration) error {
-	migrationRepository := NewMigrationRepository(ctx)
+	migrations = SortMigrations(migrations)
+	for _, migration := range migrations {
+		if migration.Source == "" {
+			return ErrMigrationSource{migration.Name}
+		}
+	}
 	for _, migration := range migrations {
-		count, err := datastore.NewQuery(Kind.Migrations).Filter("Name =", migration.Name).Limit(1).Count(ctx)
+		err := RunInTransaction(ctx, func(tx Repositories) error {
+			record, err := findMigrationRecord(tx, migration.Name)
+			if err != nil || record != nil {
+				return err
+			}
+			return tx.Migrations().Create(&Migration{Name: migration.Name, Status: MigrationPending, Checksum: MigrationChecksum(migration)})
+		})
 		if err != nil {
 			return err
 		}
-		if count != 0 {
+	}
+	for _, migration := range migrations {
+		if err := renewMigrationLock(ctx); err != nil {
+			return err
+		}
+		checksum := MigrationChecksum(migration)
+		if migration.Batch != nil {
+			if err := executeBatchMigration(ctx, migration, checksum); err != nil {
+				return err
+			}
 			continue
 		}
-		err = migration.Task(ctx)
+		var taskErr error
+		err := RunInTransaction(ctx, func(tx Repositories) error {
+			record, err := findMigrationRecord(tx, migration.Name)
+			if err != nil {
+				return err
+			}
+			if applied, err := checkAppliedMigration(tx, record, checksum); applied || err != nil {
+				return err
+			}
+			if taskErr = migration.Task(tx.GetContext()); taskErr != nil {
+				return taskErr
+			}
+			record.Status, record.Checksum, record.Error = MigrationApplied, checksum, ""
+			return saveMigrationRecord(tx, record)
+		})
+		if err != nil && err == taskErr {
+			// the transaction was rolled back, the failure is recorded apart
+			if recordErr := recordMigrationError(ctx, migration.Name, MigrationFailed, err); recordErr != nil {
+				return recordErr
+			}
+			return fmt.Errorf("Migration %s : %v", migration.Name, err)
+		}
 		if err != nil {
 			return err
 		}
-		migrationRepository.Create(migration)
+	}
+	return nil
+}
+
+// checkAppliedMigration tells whether a migration is applied,
+// it returns ErrMigrationChecksum if the migration was edited since
+func checkAppliedMigration(repositories Repositories, record *Migration, checksum string) (bool, error) {
+	if record.Status != MigrationApplied {
+		return false, nil
+	}
+	if record.Checksum == "" {
+		// records written before checksums existed get the current one
+		record.Checksum = checksum
+		return true, saveMigrationRecord(repositories, record)
+	}
+	if record.Checksum != checksum {
+		return true, ErrMigrationChecksum{record.Name, record.Checksum, checksum}
+	}
+	return true, nil
+}
+
+// recordMigrationError records the error of a migration and its status
+func recordMigrationError(ctx context.Context, name string, status string, migrationErr error) error {
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		record, err := findMigrationRecord(tx, name)
+		if err != nil || record == nil {
+			return err
+		}
+		record.Status, record.Error = status, migrationErr.Error()
+		return saveMigrationRecord(tx, record)
+	})
+}
+
+// RollbackMigrations runs, in reverse order, the Down task of the applied migrations executed after
+// the migration named name, which stays applied. Rolled back migrations are executed again by ExecuteMigrations,
+// unless they are removed from the migrations. A Down task that fails is recorded as the error of its migration.
+func RollbackMigrations(ctx context.Context, migrations []*Migration, name string) error {
+	migrations = SortMigrations(migrations)
+	target := -1
+	for i, migration := range migrations {
+		if migration.Name == name {
+			target = i
+		}
+	}
+	if target == -1 {
+		return ErrMigrationNotFound
+	}
+	for i := len(migrations) - 1; i > target; i-- {
+		migration := migrations[i]
+		if err := renewMigrationLock(ctx); err != nil {
+			return err
+		}
+		var downErr error
+		err := RunInTransaction(ctx, func(tx Repositories) error {
+			record, err := findMigrationRecord(tx, migration.Name)
+			if err != nil || record == nil || record.Status != MigrationApplied {
+				return err
+			}
+			if migration.Down == nil {
+				return ErrMigrationIrreversible{migration.Name}
+			}
+			if downErr = migration.Down(tx.GetContext()); downErr != nil {
+				return downErr
+			}
+			record.Status, record.Error = MigrationRolledBack, ""
+			return saveMigrationRecord(tx, record)
+		})
+		if err != nil && err == downErr {
+			if recordErr := recordMigrationError(ctx, migration.Name, MigrationApplied, err); recordErr != nil {
+				return recordErr
+			}
+			return fmt.Errorf("Migration %s : %v", migration.Name, err)
+		}
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,147 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
+)
+
+func TestExecuteMigrations_Status(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	calls := []string{}
+	fail := true
+	migrations := []*app.Migration{
+		{Name: "b", Source: "b", Created: time.Date(2016, 10, 2, 0, 0, 0, 0, time.UTC), Task: func(ctx context.Context) error {
+			calls = append(calls, "b")
+			if fail {
+				return fmt.Errorf("b failed")
+			}
+			return nil
+		}},
+		{Name: "a", Source: "a", Created: time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC), Task: func(ctx context.Context) error {
+			calls = append(calls, "a")
+			return nil
+		}},
+		{Name: "c", Source: "c", Created: time.Date(2016, 10, 3, 0, 0, 0, 0, time.UTC), Task: func(ctx context.Context) error {
+			calls = append(calls, "c")
+			return nil
+		}},
+	}
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations) != nil, true)
+	expect.Expect(t, calls, []string{"a", "b"}, "Migrations run in the order of Created")
+	statuses, err := app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, []string{statuses[0].Status, statuses[1].Status, statuses[2].Status},
+		[]string{app.MigrationApplied, app.MigrationFailed, app.MigrationPending})
+	expect.Expect(t, statuses[1].Error, "b failed")
+
+	t.Log("Failed migrations run again")
+	fail, calls = false, []string{}
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), nil)
+	expect.Expect(t, calls, []string{"b", "c"})
+	statuses, err = app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statuses[1].Status, app.MigrationApplied)
+	expect.Expect(t, statuses[1].Error, "")
+	expect.Expect(t, statuses[1].Checksum, app.MigrationChecksum(migrations[0]))
+
+	t.Log("Edited migrations are detected")
+	migrations[2].Source = "edited"
+	err = app.ExecuteMigrations(ctx, migrations)
+	expect.Expect(t, err, app.ErrMigrationChecksum{Name: "c", Recorded: statuses[2].Checksum, Current: app.MigrationChecksum(migrations[2])})
+}
+
+func TestExecuteMigrations_Source(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	migrations := append(app.GetMigrations(), &app.Migration{Name: "005-no-source", Created: time.Now(), Task: func(context.Context) error { return nil }})
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), app.ErrMigrationSource{Name: "005-no-source"})
+	count, err := app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0, "No migration is executed")
+}
+
+func TestRollbackMigrations(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	migrations := app.GetMigrations()
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), nil)
+	expect.Expect(t, app.RollbackMigrations(ctx, migrations, "000-root-ancestor"), nil)
+	statuses, err := app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	for _, status := range statuses[1:] {
+		expect.Expect(t, status.Status, app.MigrationRolledBack, status.Name)
+	}
+	count, err := app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+	count, err = app.NewRoleRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+
+	t.Log("Rolled back migrations run again")
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), nil)
+	count, err = app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 18)
+
+	expect.Expect(t, app.RollbackMigrations(ctx, migrations, "999-unknown"), app.ErrMigrationNotFound)
+	migrations = append(migrations, &app.Migration{Name: "005-irreversible", Source: "irreversible", Created: time.Now(), Task: func(context.Context) error { return nil }})
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), nil)
+	err = app.RollbackMigrations(ctx, migrations, "004-unique-reservations")
+	expect.Expect(t, err, app.ErrMigrationIrreversible{Name: "005-irreversible"})
+}
+
+func TestExecuteMigrations_Batch(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	repository := app.NewSnippetRepository(ctx)
+	for i := 0; i < 25; i++ {
+		expect.Expect(t, repository.Create(&app.Snippet{Title: fmt.Sprintf("snippet %02d", i)}), nil)
+	}
+	processed := map[int64]int{}
+	fail := true
+	migrations := []*app.Migration{{Name: "titles", Source: "upper case titles", Created: time.Now(), Batch: &app.BatchTask{
+		Kind: app.Kind.Snippets, Prototype: &app.Snippet{}, Size: 10,
+		Process: func(tx app.Repositories, entity app.Entity) error {
+			snippet := entity.(*app.Snippet)
+			if fail && snippet.Title == "snippet 14" {
+				return fmt.Errorf("snippet 14 failed")
+			}
+			processed[snippet.ID]++
+			snippet.Title = strings.ToUpper(snippet.Title)
+			return tx.Snippets().Update(snippet)
+		},
+	}}}
+
+	t.Log("Batches stop at the deadline")
+	expect.Expect(t, app.ExecuteMigrations(app.WithMigrationDeadline(ctx, time.Now().Add(-time.Second)), migrations), app.ErrMigrationsInProgress)
+	expect.Expect(t, len(processed), 0)
+
+	t.Log("Failed batches are rolled back and recorded")
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations) != nil, true)
+	statuses, err := app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statuses[0].Status, app.MigrationFailed)
+	expect.Expect(t, statuses[0].Processed, int64(10))
+	expect.Expect(t, statuses[0].Total, int64(25))
+	expect.Expect(t, statuses[0].Error, "snippet 14 failed")
+
+	t.Log("Failed migrations resume after the last batch")
+	fail = false
+	expect.Expect(t, app.ExecuteMigrations(ctx, migrations), nil)
+	statuses, err = app.MigrationStatuses(ctx, migrations)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statuses[0].Status, app.MigrationApplied)
+	expect.Expect(t, statuses[0].Processed, int64(25))
+	expect.Expect(t, len(processed), 25)
+	for id, count := range processed {
+		// the entities of the failed batch were processed again, their changes were rolled back
+		snippet := &app.Snippet{}
+		expect.Expect(t, repository.FindByID(id, snippet), nil)
+		expect.Expect(t, snippet.Title, strings.ToUpper(snippet.Title))
+		expect.Expect(t, count <= 2, true)
+	}
+}
@@ -5,13 +5,38 @@ import (
 	"golang.org/x/net/context"
 )
 
+// Migration statuses
+const (
+	MigrationPending    = "pending"
+	MigrationRunning    = "running"
+	MigrationApplied    = "applied"
+	MigrationFailed     = "failed"
+	MigrationRolledBack = "rolled back"
+)
+
+// Migration is both the declaration of a migration, run in the order of Created,
+// and the record of its execution, Created being then the date of the record.
+// Down reverts Task, a migration without Down cannot be rolled back.
+// Source, which is required, describes the changes of the migration, like the data it seeds, and is part
+// of its Checksum so an edited migration is detected : a change of Task must come with a change of Source. Error is the error of the last failed run.
+// A migration with a Batch task instead of a Task runs over several executions,
+// Processed out of Total entities were processed and Cursor is the position of the next batch
 type Migration struct {
-	ID      int64
-	Name    string
-	Created time.Time
-	Updated time.Time
-	Task    func(ctx context.Context) error `datastore:"-" json:"-"`
-	Version int64
+	ID        int64
+	Name      string                          `query:"filter,sort,field"`
+	Status    string                          `query:"filter,sort,field"`
+	Checksum  string                          `query:"field"`
+	Error     string                          `query:"field"`
+	Processed int64                           `query:"field"`
+	Total     int64                           `query:"field"`
+	Cursor    string                          `datastore:",noindex" query:"field"`
+	Created   time.Time                       `query:"filter,sort,field"`
+	Updated   time.Time                       `query:"filter,sort,field"`
+	Task      func(ctx context.Context) error `datastore:"-" json:"-"`
+	Batch     *BatchTask                      `datastore:"-" json:"-"`
+	Down      func(ctx context.Context) error `datastore:"-" json:"-"`
+	Source    string                          `datastore:"-" json:"-"`
+	Version   int64
 }
 
 func (m Migration) GetID() int64               { return m.ID }
@@ -22,12 +47,12 @@ func (m *Migration) SetUpdated(date time.Time) { m.Updated = date }
 // User is an app user
 type User struct {
 	ID                 int64
-	Nickname           string
-	Email              string
+	Nickname           string `query:"filter,sort,field" unique:"nocase"`
+	Email              string `unique:"nocase"`
 	Password           string
 	EncryptedPassworld string
-	Created            time.Time
-	Updated            time.Time
+	Created            time.Time `query:"filter,sort,field"`
+	Updated            time.Time `query:"filter,sort,field"`
 	Version            int64
 }
 
@@ -41,17 +66,23 @@ func (u User) GetPassword() string                   { return u.Password }
 func (u *User) SetPassword(password string)          { u.Password = password }
 func (u *User) SetEncryptedPassword(password string) { u.EncryptedPassworld = password }
 
-// Snippet is a code snippet
+// Snippet is a code snippet,
+// Prefix is the trigger of the snippet in editors, multiple prefixes are separated by commas,
+// Scope is the editor scope selector of the snippet, as imported,
+// AuthorID is the user who created the snippet and Author is loaded from it on read
 type Snippet struct {
 	ID          int64
-	Title       string
-	Description string
-	Content     string
-	CategoryID  int64
+	Title       string    `query:"filter,sort,field"`
+	Prefix      string    `query:"filter,field"`
+	Scope       string    `query:"field"`
+	Description string    `query:"field"`
+	Content     string    `query:"field"`
+	CategoryID  int64     `query:"filter,sort,field"`
 	Category    *Category `datastore:"-"`
+	AuthorID    int64     `query:"filter,sort,field"`
 	Author      *User     `datastore:"-"`
-	Created     time.Time
-	Updated     time.Time
+	Created     time.Time `query:"filter,sort,field"`
+	Updated     time.Time `query:"filter,sort,field"`
 	Version     int64
 }
 
@@ -62,13 +93,56 @@ func (s *Snippet) SetVersion(version int64)  { s.Version = version }
 func (s *Snippet) SetCreated(date time.Time) { s.Created = date }
 func (s *Snippet) SetUpdated(date time.Time) { s.Updated = date }
 
-// Category is a snippet category
-type Category struct {
+// SnippetRevision is the immutable copy of a snippet at one of its versions,
+// its AuthorID is kept when the author is deleted and then references no user
+type SnippetRevision struct {
 	ID          int64
+	SnippetID   int64
+	Version     int64
 	Title       string
+	Prefix      string
+	Scope       string
 	Description string
+	Content     string
+	CategoryID  int64
+	AuthorID    int64
 	Created     time.Time
-	Updated     time.Time
+}
+
+// NewSnippetRevision copies the current version of snippet
+func NewSnippetRevision(snippet *Snippet) *SnippetRevision {
+	return &SnippetRevision{
+		SnippetID:   snippet.ID,
+		Version:     snippet.Version,
+		Title:       snippet.Title,
+		Prefix:      snippet.Prefix,
+		Scope:       snippet.Scope,
+		Description: snippet.Description,
+		Content:     snippet.Content,
+		CategoryID:  snippet.CategoryID,
+		AuthorID:    snippet.AuthorID,
+		Created:     snippet.Updated,
+	}
+}
+
+func (r SnippetRevision) GetID() int64    { return r.ID }
+func (r *SnippetRevision) SetID(id int64) { r.ID = id }
+
+// SetCreated keeps the date of the snippet version if it is known
+func (r *SnippetRevision) SetCreated(date time.Time) {
+	if r.Created.IsZero() {
+		r.Created = date
+	}
+}
+func (r *SnippetRevision) SetUpdated(date time.Time) {}
+
+// Category is a snippet category
+type Category struct {
+	ID          int64
+	Title       string    `query:"filter,sort,field" unique:"nocase"`
+	Description string    `query:"field"`
+	Created     time.Time `query:"filter,sort,field"`
+	Updated     time.Time `query:"filter,sort,field"`
 	Version     int64
 }
 
@@ -88,9 +162,29 @@ type Token struct {
 	Created    time.Time
 }
 
+func (t Token) GetID() int64               { return t.ID }
+func (t *Token) SetID(id int64)            { t.ID = id }
+func (t *Token) SetCreated(date time.Time) { t.Created = date }
+func (t *Token) SetUpdated(date time.Time) {}
+
+// PasswordResetToken lets a user who forgot their password choose a new one, it can be used once
+type PasswordResetToken struct {
+	ID         int64
+	UserID     int64
+	Value      string
+	Expiration time.Time
+	Used       bool
+	Created    time.Time
+}
+
+func (t PasswordResetToken) GetID() int64               { return t.ID }
+func (t *PasswordResetToken) SetID(id int64)            { t.ID = id }
+func (t *PasswordResetToken) SetCreated(date time.Time) { t.Created = date }
+func (t *PasswordResetToken) SetUpdated(date time.Time) {}
+
 type Role struct {
 	ID          int64
-	Name        string
+	Name        string `unique:"true"`
 	Description string
 	Version     int64
 	Created     time.Time
@@ -134,6 +228,25 @@ func (userRole *UserRole) SetUpdated(date time.Time) { userRole.Updated = date }
 func (userRole UserRole) GetVersion() int64          { return userRole.Version }
 func (userRole *UserRole) SetVersion(version int64)  { userRole.Version = version }
 
+// AuditEntry records a change made to the rights of the users.
+// ActorID is the user who made the change, 0 for the command line or the admin token,
+// EntityKind and EntityID the changed entity and UserID the user whose rights changed, if any
+type AuditEntry struct {
+	ID         int64
+	ActorID    int64     `query:"filter,field"`
+	Action     string    `query:"filter,sort,field"`
+	EntityKind string    `query:"filter,field"`
+	EntityID   int64     `query:"filter,field"`
+	UserID     int64     `query:"filter,field"`
+	Details    string    `query:"field"`
+	Created    time.Time `query:"filter,sort,field"`
+}
+
+func (entry AuditEntry) GetID() int64               { return entry.ID }
+func (entry *AuditEntry) SetID(id int64)            { entry.ID = id }
+func (entry *AuditEntry) SetCreated(date time.Time) { entry.Created = date }
+func (entry *AuditEntry) SetUpdated(date time.Time) {}
+
 type Ancestor struct {
 	ID string
 }
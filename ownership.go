@@ -0,0 +1,100 @@
+package smartsnippets
+
+import (
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// CanEditSnippet returns true if user is the author of snippet or a SuperAdmin
+func CanEditSnippet(ctx context.Context, user *User, snippet *Snippet) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	if snippet.AuthorID != 0 && snippet.AuthorID == user.ID {
+		return true, nil
+	}
+	return HasRole(ctx, user, RoleSuperAdmin)
+}
+
+// authorizeSnippetChange returns ErrAuthenticationRequired or ErrForbidden
+// if the current user of container cannot edit snippet
+func authorizeSnippetChange(container ContextAwareContainer, snippet *Snippet) error {
+	user := container.GetCurrentUser()
+	if user == nil {
+		return ErrAuthenticationRequired
+	}
+	allowed, err := CanEditSnippet(container.GetContext(), user, snippet)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// NewSnippetOwnershipListener makes the current user of container the author of the snippets it creates,
+// only the author of a snippet or a SuperAdmin can update or delete it
+func NewSnippetOwnershipListener(container ContextAwareContainer) signal.Listener {
+	return signal.ListenerFunc(func(e signal.Event) error {
+		switch event := e.(type) {
+		case *BeforeResourceCreateEvent:
+			if snippet, ok := event.Entity.(*Snippet); ok {
+				user := container.GetCurrentUser()
+				if user == nil {
+					return ErrAuthenticationRequired
+				}
+				snippet.AuthorID = user.ID
+			}
+		case *BeforeResourceUpdateEvent:
+			if snippet, ok := event.Old.(*Snippet); ok {
+				if err := authorizeSnippetChange(container, snippet); err != nil {
+					return err
+				}
+				// the author of a snippet never changes
+				event.New.(*Snippet).AuthorID = snippet.AuthorID
+			}
+		case *BeforeResourceDeleteEvent:
+			if snippet, ok := event.Entity.(*Snippet); ok {
+				return authorizeSnippetChange(container, snippet)
+			}
+		}
+		return nil
+	})
+}
+
+// loadSnippetAuthors sets the Author of entities, a *Snippet or a pointer to a slice of snippets,
+// to the public profile of the user referenced by their AuthorID
+func loadSnippetAuthors(ctx context.Context, entities interface{}) error {
+	snippets := []*Snippet{}
+	switch value := entities.(type) {
+	case *Snippet:
+		snippets = append(snippets, value)
+	case *[]*Snippet:
+		snippets = *value
+	case *[]Snippet:
+		for i := range *value {
+			snippets = append(snippets, &(*value)[i])
+		}
+	}
+	authors := map[int64]*User{}
+	repository := NewUserRepository(ctx)
+	for _, snippet := range snippets {
+		if snippet.AuthorID == 0 {
+			continue
+		}
+		if _, ok := authors[snippet.AuthorID]; !ok {
+			user := &User{}
+			if err := repository.FindByID(snippet.AuthorID, user); err == datastore.ErrNoSuchEntity {
+				user = nil
+			} else if err != nil {
+				return err
+			} else {
+				user = &User{ID: user.ID, Nickname: user.Nickname, Created: user.Created}
+			}
+			authors[snippet.AuthorID] = user
+		}
+		snippet.Author = authors[snippet.AuthorID]
+	}
+	return nil
+}
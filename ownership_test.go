@@ -0,0 +1,87 @@
+package smartsnippets_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestSnippetAuthor(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	author := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com", EncryptedPassworld: "secret"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(author), nil)
+	repository := app.NewSnippetRepository(ctx)
+	snippet := &app.Snippet{Title: "Hello", AuthorID: author.ID}
+	expect.Expect(t, repository.Create(snippet), nil)
+
+	result := &app.Snippet{}
+	expect.Expect(t, repository.FindByID(snippet.ID, result), nil)
+	expect.Expect(t, result.Author.Nickname, "JohnDoe")
+	expect.Expect(t, result.Author.EncryptedPassworld, "", "Only the public profile of the author is loaded")
+	revisions := []*app.SnippetRevision{}
+	expect.Expect(t, repository.FindRevisions(snippet.ID, &revisions), nil)
+	expect.Expect(t, revisions[0].AuthorID, author.ID)
+
+	t.Log("The snippets of a deleted user are kept without author")
+	expect.Expect(t, app.NewUserRepository(ctx).Delete(author), nil)
+	result = &app.Snippet{}
+	expect.Expect(t, repository.FindByID(snippet.ID, result), nil)
+	expect.Expect(t, result.AuthorID, int64(0))
+	expect.Expect(t, result.Author == nil, true)
+	revisions = []*app.SnippetRevision{}
+	expect.Expect(t, repository.FindRevisions(snippet.ID, &revisions), nil)
+	expect.Expect(t, revisions[0].AuthorID, author.ID, "Revisions are not rewritten")
+}
+
+func TestMemoryApp_SnippetOwnership(t *testing.T) {
+	App := SetUpMemoryApp()
+	handler := App.Compile()
+	johnToken := SetUpUserToken(t, App, "JohnDoe")
+	janeToken := SetUpUserToken(t, App, "JaneDoe")
+	adminToken := SetUpUserToken(t, App, "Admin", app.RoleSuperAdmin)
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Log("POST /snippets")
+	expect.Expect(t, serve(httptest.NewRequest("POST", "/snippets", strings.NewReader(`{"Title":"Hello"}`))).Code, http.StatusUnauthorized, "Status")
+	response := serve(NewAuthorizedRequest("POST", "/snippets", strings.NewReader(`{"Title":"Hello","AuthorID":1000}`), johnToken))
+	expect.Expect(t, response.Code, http.StatusSeeOther, "Status")
+	location := response.Header().Get("Location")
+	snippet := &app.Snippet{}
+	expect.Expect(t, json.NewDecoder(serve(httptest.NewRequest("GET", location, nil)).Body).Decode(snippet), nil)
+	expect.Expect(t, snippet.Author.Nickname, "JohnDoe", "The current user is the author")
+	expect.Expect(t, snippet.AuthorID, snippet.Author.ID)
+
+	t.Logf("PUT %s", location)
+	expect.Expect(t, serve(httptest.NewRequest("PUT", location, strings.NewReader(`{"Title":"Hi","Version":1}`))).Code, http.StatusUnauthorized, "Status")
+	expect.Expect(t, serve(NewAuthorizedRequest("PUT", location, strings.NewReader(`{"Title":"Hi","Version":1}`), janeToken)).Code, http.StatusForbidden, "Status")
+	response = serve(NewAuthorizedRequest("PUT", location, strings.NewReader(`{"Title":"Hi","Version":1,"AuthorID":1000}`), johnToken))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	result := &app.Snippet{}
+	expect.Expect(t, json.NewDecoder(serve(httptest.NewRequest("GET", location, nil)).Body).Decode(result), nil)
+	expect.Expect(t, result.AuthorID, snippet.AuthorID, "The author never changes")
+	expect.Expect(t, serve(NewAuthorizedRequest("POST", location+"/revert/1", nil, janeToken)).Code, http.StatusForbidden, "Status")
+
+	t.Logf("GET /users/%d/snippets", snippet.AuthorID)
+	snippets := []*app.Snippet{}
+	response = serve(httptest.NewRequest("GET", fmt.Sprintf("/users/%d/snippets", snippet.AuthorID), nil))
+	expect.Expect(t, response.Code, http.StatusOK, "Status", response.Body.String())
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&snippets), nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Title, "Hi")
+	expect.Expect(t, serve(httptest.NewRequest("GET", "/users/1000/snippets", nil)).Code, http.StatusNotFound, "Status")
+
+	t.Logf("DELETE %s", location)
+	expect.Expect(t, serve(NewAuthorizedRequest("DELETE", location, nil, janeToken)).Code, http.StatusForbidden, "Status")
+	expect.Expect(t, serve(NewAuthorizedRequest("DELETE", location, nil, adminToken)).Code, http.StatusOK, "A SuperAdmin can delete any snippet")
+}
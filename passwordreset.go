@@ -0,0 +1,159 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// DefaultPasswordResetLifetime is the time a password reset token sent by RequestPasswordReset is valid
+	DefaultPasswordResetLifetime = time.Hour
+	// PasswordResetInterval is the time during which a user receives a single password reset token
+	PasswordResetInterval = 5 * time.Minute
+)
+
+var (
+	ErrInvalidResetToken = fmt.Errorf("ErrInvalidResetToken")
+)
+
+// ForgotPassword is posted to /users/password/forgot, Login is the nickname or the email of the user
+type ForgotPassword struct {
+	Login string
+}
+
+// NewPassword is posted to /users/password/reset with the token received by email
+type NewPassword struct {
+	Token    string
+	Password string
+}
+
+// RequestPasswordReset mails a password reset token to the user whose nickname or email is login,
+// the email is sent after the request with SendMailLater. Nothing is sent and no error is returned
+// if there is no such user, so callers cannot tell whether an account exists, or if an unused token
+// was issued to the user less than PasswordResetInterval ago, so the inbox of a user cannot be flooded
+func RequestPasswordReset(ctx context.Context, login string) error {
+	if _, err := GetMailer(ctx); err != nil {
+		return err
+	}
+	user, err := findUserByLogin(ctx, login)
+	if err == datastore.ErrNoSuchEntity || (err == nil && user.Email == "") {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resetTokens := []*PasswordResetToken{}
+	if err := NewPasswordResetTokenRepository(ctx).FindBy(Query{Query: map[string]interface{}{"UserID=": user.ID}}, &resetTokens); err != nil {
+		return err
+	}
+	for _, resetToken := range resetTokens {
+		if !resetToken.Used && time.Since(resetToken.Created) < PasswordResetInterval {
+			return nil
+		}
+	}
+	value, token, err := IssuePasswordResetToken(ctx, user, DefaultPasswordResetLifetime)
+	if err != nil {
+		return err
+	}
+	return SendMailLater(ctx, NewPasswordResetMessage(user, value, token))
+}
+
+// IssuePasswordResetToken creates a password reset token for user, valid for lifetime.
+// Like bearer tokens, only a hash of the returned value is stored
+func IssuePasswordResetToken(ctx context.Context, user *User, lifetime time.Duration) (string, *PasswordResetToken, error) {
+	value, err := GenerateRandomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := &PasswordResetToken{UserID: user.ID, Value: HashToken(value), Expiration: time.Now().Add(lifetime)}
+	if err = NewPasswordResetTokenRepository(ctx).Create(token); err != nil {
+		return "", nil, err
+	}
+	return value, token, nil
+}
+
+// NewPasswordResetMessage returns the email sending the password reset token value to user
+func NewPasswordResetMessage(user *User, value string, token *PasswordResetToken) Message {
+	return Message{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(`Hello %s,
+
+Someone asked to reset the password of your account. If it was you, post this token
+with your new password to /users/password/reset before %s:
+
+%s
+
+If you did not ask for it, you can ignore this email, your password has not changed.
+`, user.Nickname, token.Expiration.UTC().Format(time.RFC1123), value),
+	}
+}
+
+// ResetPassword sets the password of the user of the password reset token value.
+// It returns ErrInvalidResetToken if the token does not exist, expired or was used,
+// and the validation errors of password. The password reset tokens and the bearer tokens
+// of the user are revoked, so sessions opened with the old password are closed
+func ResetPassword(ctx context.Context, value string, password string) (*User, error) {
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+	encryptedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{}
+	err = RunInTransaction(ctx, func(tx Repositories) error {
+		token := &PasswordResetToken{}
+		if err := tx.PasswordResetTokens().FindByValue(HashToken(value), token); err == datastore.ErrNoSuchEntity {
+			return ErrInvalidResetToken
+		} else if err != nil {
+			return err
+		}
+		if token.Used || time.Now().After(token.Expiration) {
+			return ErrInvalidResetToken
+		}
+		if err := tx.Users().FindByID(token.UserID, user); err == datastore.ErrNoSuchEntity {
+			return ErrInvalidResetToken
+		} else if err != nil {
+			return err
+		}
+		user.SetPassword("")
+		user.SetEncryptedPassword(encryptedPassword)
+		if err := tx.Users().Update(user); err != nil {
+			return err
+		}
+		resetTokens := []*PasswordResetToken{}
+		if err := tx.PasswordResetTokens().FindBy(Query{Query: map[string]interface{}{"UserID=": user.ID}}, &resetTokens); err != nil {
+			return err
+		}
+		for _, resetToken := range resetTokens {
+			if resetToken.Used {
+				continue
+			}
+			resetToken.Used = true
+			if err := tx.PasswordResetTokens().Update(resetToken); err != nil {
+				return err
+			}
+		}
+		tokens := []*Token{}
+		if err := tx.Tokens().FindBy(Query{Query: map[string]interface{}{"UserID=": user.ID}}, &tokens); err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if token.Revoked {
+				continue
+			}
+			token.Revoked = true
+			if err := tx.Tokens().Update(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,171 @@
+package smartsnippets_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
+)
+
+// resetTokenOf returns the password reset token sent by message
+func resetTokenOf(message app.Message) string {
+	for _, line := range strings.Split(message.Body, "\n") {
+		if line = strings.TrimSpace(line); len(line) == 44 && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+	return ""
+}
+
+// waitForMessages waits for the n emails sent after the requests of a test
+func waitForMessages(t *testing.T, mailer *app.MemoryMailer, n int) []app.Message {
+	for deadline := time.Now().Add(5 * time.Second); len(mailer.Messages()) < n && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	messages := mailer.Messages()
+	expect.Expect(t, len(messages), n, "Emails")
+	return messages
+}
+
+func TestResetPassword(t *testing.T) {
+	mailer := app.NewMemoryMailer()
+	ctx := app.WithMailer(SetUpMemoryContext(), mailer)
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(user), nil)
+	bearer, _, err := app.IssueToken(ctx, user, time.Hour)
+	expect.Expect(t, err, nil)
+
+	t.Log("Unknown users receive nothing")
+	expect.Expect(t, app.RequestPasswordReset(ctx, "JaneDoe"), nil)
+	expect.Expect(t, len(mailer.Messages()), 0)
+
+	t.Log("The token is mailed to the user")
+	expect.Expect(t, app.RequestPasswordReset(ctx, "john.doe@acme.com"), nil)
+	messages := waitForMessages(t, mailer, 1)
+	expect.Expect(t, messages[0].To[0], "john.doe@acme.com")
+	value := resetTokenOf(messages[0])
+	token := &app.PasswordResetToken{}
+	expect.Expect(t, app.NewPasswordResetTokenRepository(ctx).FindByValue(app.HashToken(value), token), nil)
+	expect.Expect(t, token.Value != value, true, "Only the hash of the token is stored")
+
+	t.Log("A single token is issued during PasswordResetInterval")
+	expect.Expect(t, app.RequestPasswordReset(ctx, "JohnDoe"), nil)
+	count, err := app.NewPasswordResetTokenRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 1)
+
+	t.Log("The token sets a new password once")
+	_, err = app.ResetPassword(ctx, value, "short")
+	expect.Expect(t, err != nil, true, "The password is validated")
+	result, err := app.ResetPassword(ctx, value, "new password")
+	expect.Expect(t, err, nil)
+	expect.Expect(t, result.ID, user.ID)
+	_, _, _, err = app.Login(ctx, app.Credentials{Login: "JohnDoe", Password: "new password"})
+	expect.Expect(t, err, nil)
+	_, _, err = app.FindTokenUser(ctx, bearer)
+	expect.Expect(t, err, app.ErrInvalidToken, "Sessions opened before the reset are closed")
+	_, err = app.ResetPassword(ctx, value, "other password")
+	expect.Expect(t, err, app.ErrInvalidResetToken)
+
+	t.Log("Expired tokens are rejected")
+	value, _, err = app.IssuePasswordResetToken(ctx, user, -time.Minute)
+	expect.Expect(t, err, nil)
+	_, err = app.ResetPassword(ctx, value, "other password")
+	expect.Expect(t, err, app.ErrInvalidResetToken)
+	_, err = app.ResetPassword(ctx, "unknown", "other password")
+	expect.Expect(t, err, app.ErrInvalidResetToken)
+}
+
+func TestMemoryApp_PasswordReset(t *testing.T) {
+	mailer := app.NewMemoryMailer()
+	App := app.NewApp()
+	App.ContextFactory = app.NewRepositoryContextFactory(app.NewMemoryRepositoryFactory(), func(ctx context.Context) context.Context {
+		return app.WithMailer(ctx, mailer)
+	})
+	handler := App.Compile()
+	SetUpUserToken(t, App, "JohnDoe")
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return response
+	}
+
+	t.Log("POST /users/password/forgot")
+	expect.Expect(t, serve("/users/password/forgot", `{"Login":"JaneDoe"}`).Code, http.StatusAccepted, "The response does not reveal if the user exists")
+	expect.Expect(t, serve("/users/password/forgot", `{"Login":"JohnDoe"}`).Code, http.StatusAccepted, "Status")
+	value := resetTokenOf(waitForMessages(t, mailer, 1)[0])
+
+	t.Log("POST /users/password/reset")
+	expect.Expect(t, serve("/users/password/reset", `{"Token":"`+value+`","Password":"short"}`).Code, http.StatusBadRequest, "Status")
+	response := serve("/users/password/reset", `{"Token":"`+value+`","Password":"new password"}`)
+	expect.Expect(t, response.Code, http.StatusNoContent, "Status", response.Body.String())
+	expect.Expect(t, serve("/users/password/reset", `{"Token":"`+value+`","Password":"new password"}`).Code, http.StatusBadRequest, "The token is used")
+	expect.Expect(t, serve("/users/login", `{"Login":"JohnDoe","Password":"new password"}`).Code, http.StatusOK, "Status")
+
+	t.Log("Password resets are unavailable without mailer")
+	handler = SetUpMemoryApp().Compile()
+	expect.Expect(t, serve("/users/password/forgot", `{"Login":"JohnDoe"}`).Code, http.StatusServiceUnavailable, "Status")
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.Expect(t, err, nil)
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		lines, data := []string{}, false
+		reader := bufio.NewReader(connection)
+		connection.Write([]byte("220 localhost\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				connection.Write([]byte("250 OK\r\n"))
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				connection.Write([]byte("250 localhost\r\n"))
+			case line == "DATA":
+				data = true
+				connection.Write([]byte("354 Go ahead\r\n"))
+			case line == "QUIT":
+				connection.Write([]byte("221 Bye\r\n"))
+				received <- lines
+				return
+			default:
+				connection.Write([]byte("250 OK\r\n"))
+			}
+		}
+		received <- lines
+	}()
+
+	mailer := app.NewSMTPMailer(listener.Addr().String(), "Snipped <noreply@acme.com>", "", "")
+	err = mailer.Send(context.Background(), app.Message{To: []string{"john.doe@acme.com"}, Subject: "Hello", Body: "Hello\nJohn"})
+	expect.Expect(t, err, nil)
+	session := strings.Join(<-received, "\n")
+	expect.Expect(t, strings.Contains(session, "MAIL FROM:<noreply@acme.com>"), true, session)
+	expect.Expect(t, strings.Contains(session, "RCPT TO:<john.doe@acme.com>"), true, session)
+	expect.Expect(t, strings.Contains(session, "Subject: Hello"), true, session)
+	expect.Expect(t, strings.Contains(session, "Hello\nJohn"), true, session)
+
+	t.Log("Invalid addresses are rejected")
+	expect.Expect(t, mailer.Send(context.Background(), app.Message{To: []string{"john\r\nBcc: jane@acme.com"}}) != nil, true)
+}
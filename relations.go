@@ -0,0 +1,162 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+)
+
+// RelationPolicy is what happens to the entities referencing an entity being deleted
+type RelationPolicy int
+
+const (
+	// Restrict prevents the deletion of referenced entities
+	Restrict RelationPolicy = iota
+	// Cascade deletes the referencing entities
+	Cascade
+	// SetNull sets the reference of the referencing entities to 0
+	SetNull
+	// ReassignToDefault sets the reference of the referencing entities to the ID returned by Default
+	ReassignToDefault
+)
+
+// Relation is a reference from the Field of a Kind to the ID of a Target kind
+type Relation struct {
+	Kind      string
+	Prototype interface{}
+	Field     string
+	Target    string
+	Policy    RelationPolicy
+	// Default returns the ID of the entity referenced after a deletion with ReassignToDefault
+	Default func(ctx context.Context) (int64, error)
+}
+
+// Reference lists the entities of a kind referencing another entity through a field
+type Reference struct {
+	Kind  string
+	Field string
+	IDs   []int64
+}
+
+// ErrReferenceViolation is returned when an entity cannot be deleted
+// because entities reference it with the Restrict policy
+type ErrReferenceViolation struct {
+	Kind       string
+	ID         int64
+	References []Reference
+}
+
+func (e ErrReferenceViolation) Error() string {
+	references := []string{}
+	for _, reference := range e.References {
+		references = append(references, fmt.Sprintf("%s.%s %v", reference.Kind, reference.Field, reference.IDs))
+	}
+	return fmt.Sprintf("ErrReferenceViolation : %s %d is referenced by %s", e.Kind, e.ID, strings.Join(references, ", "))
+}
+
+// referencingRelations are the registered relations by target kind
+var referencingRelations = map[string][]Relation{}
+
+func init() {
+	RegisterRelation(Relation{Kind: Kind.Snippets, Prototype: Snippet{}, Field: "CategoryID", Target: Kind.Categories, Policy: Restrict})
+	RegisterRelation(Relation{Kind: Kind.SnippetRevisions, Prototype: SnippetRevision{}, Field: "SnippetID", Target: Kind.Snippets, Policy: Cascade})
+	// the snippets of a deleted user are kept without author, revisions are immutable
+	// and keep the AuthorID of their version
+	RegisterRelation(Relation{Kind: Kind.Snippets, Prototype: Snippet{}, Field: "AuthorID", Target: Kind.Users, Policy: SetNull})
+	RegisterRelation(Relation{Kind: Kind.UserRoles, Prototype: UserRole{}, Field: "UserID", Target: Kind.Users, Policy: Cascade})
+	RegisterRelation(Relation{Kind: Kind.UserRoles, Prototype: UserRole{}, Field: "RoleID", Target: Kind.Roles, Policy: Restrict})
+	RegisterRelation(Relation{Kind: Kind.Tokens, Prototype: Token{}, Field: "UserID", Target: Kind.Users, Policy: Cascade})
+	RegisterRelation(Relation{Kind: Kind.PasswordResetTokens, Prototype: PasswordResetToken{}, Field: "UserID", Target: Kind.Users, Policy: Cascade})
+}
+
+// RegisterRelation applies the policy of relation when an entity of its target kind is deleted
+func RegisterRelation(relation Relation) {
+	referencingRelations[relation.Target] = append(referencingRelations[relation.Target], relation)
+}
+
+// RelationalRepository is a Repository applying the policies of the relations
+// referencing its kind when an entity is deleted, in a transaction
+type RelationalRepository struct {
+	Repository
+	Context   context.Context
+	Kind      string
+	listeners []signal.Listener
+}
+
+// NewRelationalRepository creates a RelationalRepository
+func NewRelationalRepository(ctx context.Context, kind string, listeners ...signal.Listener) *RelationalRepository {
+	return &RelationalRepository{
+		Repository: newConstrainedRepository(ctx, kind, listeners...),
+		Context:    ctx,
+		Kind:       kind,
+		listeners:  listeners,
+	}
+}
+
+func (repository RelationalRepository) Delete(entity Entity) error {
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		if err := ApplyRelations(tx.GetContext(), repository.Kind, entity.GetID()); err != nil {
+			return err
+		}
+		return newConstrainedRepository(tx.GetContext(), repository.Kind, repository.listeners...).Delete(entity)
+	})
+}
+
+// ApplyRelations applies the policies of the relations referencing the entity id of kind.
+// Nothing is changed if a Restrict relation references the entity.
+func ApplyRelations(ctx context.Context, kind string, id int64) error {
+	violation := ErrReferenceViolation{Kind: kind, ID: id}
+	referencing := make([]reflect.Value, len(referencingRelations[kind]))
+	for i, relation := range referencingRelations[kind] {
+		entities := reflect.New(reflect.SliceOf(reflect.PtrTo(reflect.Indirect(reflect.ValueOf(relation.Prototype)).Type())))
+		err := NewRepository(ctx, relation.Kind).FindBy(Query{Query: map[string]interface{}{relation.Field + "=": id}}, entities.Interface())
+		if err != nil {
+			return err
+		}
+		referencing[i] = entities.Elem()
+		if relation.Policy == Restrict && referencing[i].Len() > 0 {
+			reference := Reference{Kind: relation.Kind, Field: relation.Field}
+			for j := 0; j < referencing[i].Len(); j++ {
+				reference.IDs = append(reference.IDs, referencing[i].Index(j).Interface().(Entity).GetID())
+			}
+			violation.References = append(violation.References, reference)
+		}
+	}
+	if len(violation.References) > 0 {
+		return violation
+	}
+	for i, relation := range referencingRelations[kind] {
+		if referencing[i].Len() == 0 {
+			continue
+		}
+		repository := NewRepository(ctx, relation.Kind)
+		var target int64
+		if relation.Policy == ReassignToDefault {
+			var err error
+			if target, err = relation.Default(ctx); err != nil {
+				return err
+			}
+			if target == id {
+				return fmt.Errorf("The default %s %d cannot be deleted", kind, id)
+			}
+		}
+		for j := 0; j < referencing[i].Len(); j++ {
+			entity := referencing[i].Index(j).Interface().(Entity)
+			var err error
+			switch relation.Policy {
+			case Cascade:
+				err = repository.Delete(entity)
+			case SetNull, ReassignToDefault:
+				reflect.Indirect(reflect.ValueOf(entity)).FieldByName(relation.Field).SetInt(target)
+				err = repository.Update(entity)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package smartsnippets_test
+
+import (
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRelationalRepository_Restrict(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	category := &app.Category{Title: "Go"}
+	expect.Expect(t, app.NewCategoryRepository(ctx).Create(category), nil)
+	snippet := &app.Snippet{Title: "Hello", CategoryID: category.ID}
+	expect.Expect(t, app.NewSnippetRepository(ctx).Create(snippet), nil)
+	err := app.NewCategoryRepository(ctx).Delete(category)
+	expect.Expect(t, err, app.ErrReferenceViolation{Kind: app.Kind.Categories, ID: category.ID, References: []app.Reference{
+		{Kind: app.Kind.Snippets, Field: "CategoryID", IDs: []int64{snippet.ID}},
+	}})
+	expect.Expect(t, app.NewCategoryRepository(ctx).FindByID(category.ID, &app.Category{}), nil)
+}
+
+func TestRelationalRepository_Cascade(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	user := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(user), nil)
+	expect.Expect(t, app.NewUserRepository(ctx).Delete(user), nil)
+	count, err := app.NewUserRoleRepository(ctx).Count(app.Query{Query: map[string]interface{}{"UserID=": user.ID}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+}
+
+func TestRelationalRepository_SetNullAndReassign(t *testing.T) {
+	app.RegisterRelation(app.Relation{Kind: "TestSnippets", Prototype: app.Snippet{}, Field: "CategoryID", Target: "TestCategories", Policy: app.SetNull})
+	app.RegisterRelation(app.Relation{Kind: "TestRevisions", Prototype: app.SnippetRevision{}, Field: "CategoryID", Target: "TestCategories", Policy: app.ReassignToDefault,
+		Default: func(ctx context.Context) (int64, error) { return 1, nil },
+	})
+	ctx := SetUpMemoryContext()
+	categories := app.NewRepository(ctx, "TestCategories")
+	defaultCategory, category := &app.Category{Title: "Default"}, &app.Category{Title: "Go"}
+	expect.Expect(t, categories.Create(defaultCategory), nil)
+	expect.Expect(t, categories.Create(category), nil)
+	snippet := &app.Snippet{Title: "Hello", CategoryID: category.ID}
+	expect.Expect(t, app.NewRepository(ctx, "TestSnippets").Create(snippet), nil)
+	revision := &app.SnippetRevision{Title: "Hello", CategoryID: category.ID}
+	expect.Expect(t, app.NewRepository(ctx, "TestRevisions").Create(revision), nil)
+
+	expect.Expect(t, categories.Delete(category), nil)
+	expect.Expect(t, categories.FindByID(category.ID, &app.Category{}), datastore.ErrNoSuchEntity)
+	expect.Expect(t, app.NewRepository(ctx, "TestSnippets").FindByID(snippet.ID, snippet), nil)
+	expect.Expect(t, snippet.CategoryID, int64(0))
+	expect.Expect(t, app.NewRepository(ctx, "TestRevisions").FindByID(revision.ID, revision), nil)
+	expect.Expect(t, revision.CategoryID, defaultCategory.ID)
+
+	t.Log("The default entity cannot be deleted")
+	expect.Expect(t, categories.Delete(defaultCategory) != nil, true)
+}
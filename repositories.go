@@ -1,8 +1,11 @@
 package smartsnippets
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/Mparaiso/tiger-go-framework/signal"
 
@@ -12,8 +15,10 @@ import (
 )
 
 // Kind list app kinds
-var Kind = struct{ Users, Migrations, Snippets, Categories, Roles, UserRoles string }{
-	"Users", "Migrations", "Snippets", "Categories", "Roles", "UserRoles",
+var Kind = struct {
+	Users, Migrations, Snippets, SnippetRevisions, Categories, Roles, UserRoles, Tokens, UniqueReservations, Locks, AuditEntries, PasswordResetTokens string
+}{
+	"Users", "Migrations", "Snippets", "SnippetRevisions", "Categories", "Roles", "UserRoles", "Tokens", "UniqueReservations", "Locks", "AuditEntries", "PasswordResetTokens",
 }
 
 // DefaultRepository is the default implementation of Repository
@@ -25,22 +30,186 @@ type DefaultRepository struct {
 
 // NewDefaultRepository creates a new DefaultRepository
 func NewDefaultRepository(ctx context.Context, kind string, listeners ...signal.Listener) *DefaultRepository {
-	defaultRepository := &DefaultRepository{Context: ctx, Kind: kind}
-	defaultRepository.Signal = signal.NewDefaultSignal()
-	defaultRepository.Signal.Add(signal.ListenerFunc(BeforeEntityCreatedListener))
-	defaultRepository.Signal.Add(signal.ListenerFunc(BeforeEntityUpdatedListener))
+	return &DefaultRepository{Context: ctx, Kind: kind, Signal: NewRepositorySignal(listeners...)}
+}
+
+// NewRepositorySignal creates the signal dispatched by repositories
+// on create, update and delete, with the default listeners registered first
+func NewRepositorySignal(listeners ...signal.Listener) signal.Signal {
+	repositorySignal := signal.NewDefaultSignal()
+	repositorySignal.Add(signal.ListenerFunc(BeforeEntityCreatedListener))
+	repositorySignal.Add(signal.ListenerFunc(BeforeEntityUpdatedListener))
 	for _, listener := range listeners {
-		defaultRepository.Signal.Add(listener)
+		repositorySignal.Add(listener)
 	}
-	return defaultRepository
+	return repositorySignal
 }
 
 type ContextValue int
 
 const (
 	ParentKey ContextValue = iota
+	RepositoryFactoryKey
+	TransactionKey
+	SearchIndexKey
+	CacheKey
+	CacheInvalidationsKey
+	MigrationDeadlineKey
+	MailerKey
+	MemoryUndoLogKey
+	MigrationLockKey
 )
 
+// DatastoreRepositoryFactory creates DefaultRepository instances
+type DatastoreRepositoryFactory struct{}
+
+func (DatastoreRepositoryFactory) Create(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	return NewDefaultRepository(ctx, kind, listeners...)
+}
+
+// RunInTransaction runs f in a datastore transaction,
+// all entities share the root ancestor so they belong to the same entity group
+func (DatastoreRepositoryFactory) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return datastore.RunInTransaction(ctx, f, nil)
+}
+
+// WithRepositoryFactory returns a context in which repositories are created by factory
+func WithRepositoryFactory(ctx context.Context, factory RepositoryFactory) context.Context {
+	return context.WithValue(ctx, RepositoryFactoryKey, factory)
+}
+
+// GetRepositoryFactory returns the RepositoryFactory of the context,
+// or a DatastoreRepositoryFactory if none was set
+func GetRepositoryFactory(ctx context.Context) RepositoryFactory {
+	if factory, ok := ctx.Value(RepositoryFactoryKey).(RepositoryFactory); ok {
+		return factory
+	}
+	return DatastoreRepositoryFactory{}
+}
+
+// UsesDatastore returns true if the repositories of the context are backed by the datastore
+func UsesDatastore(ctx context.Context) bool {
+	_, ok := GetRepositoryFactory(ctx).(DatastoreRepositoryFactory)
+	return ok
+}
+
+// ListenerFactory creates a repository listener bound to a context,
+// it returns nil if the listener is not needed in that context
+type ListenerFactory func(ctx context.Context) signal.Listener
+
+var kindListeners = map[string][]ListenerFactory{}
+
+// RegisterKindListener adds the listener created by factory to every repository of kind
+// created with NewRepository, after the listeners given to NewRepository
+func RegisterKindListener(kind string, factory ListenerFactory) {
+	kindListeners[kind] = append(kindListeners[kind], factory)
+}
+
+// NewRepository creates a Repository of a kind with the RepositoryFactory of the context
+func NewRepository(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	for _, factory := range kindListeners[kind] {
+		if listener := factory(ctx); listener != nil {
+			listeners = append(listeners, listener)
+		}
+	}
+	if _, ok := referencingRelations[kind]; ok {
+		return NewRelationalRepository(ctx, kind, listeners...)
+	}
+	return newConstrainedRepository(ctx, kind, listeners...)
+}
+
+// newConstrainedRepository creates a Repository of a kind enforcing its unique constraints
+func newConstrainedRepository(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	if _, ok := uniqueConstraints[kind]; ok {
+		return NewUniqueRepository(ctx, kind, listeners...)
+	}
+	return newRepository(ctx, kind, listeners...)
+}
+
+// newRepository creates a Repository of a kind, cached if the context has a Cache
+func newRepository(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	cache, err := GetCache(ctx)
+	if err != nil {
+		return GetRepositoryFactory(ctx).Create(ctx, kind, listeners...)
+	}
+	listeners = append(listeners, NewCacheListener(ctx, cache, kind))
+	return NewCachedRepository(ctx, kind, GetRepositoryFactory(ctx).Create(ctx, kind, listeners...), cache)
+}
+
+// InTransaction returns true if ctx is the context of a transaction
+func InTransaction(ctx context.Context) bool {
+	return ctx.Value(TransactionKey) != nil
+}
+
+// RunInTransaction runs f in a transaction of the RepositoryFactory of the context.
+// If ctx already belongs to a transaction, f joins it.
+func RunInTransaction(ctx context.Context, f func(tx Repositories) error) error {
+	if InTransaction(ctx) {
+		return f(NewRepositories(ctx))
+	}
+	// cache invalidations are applied once the transaction is committed. The datastore runs f again
+	// when a commit fails, each attempt gets its own invalidations so only those of the committed one apply
+	var invalidations *cacheInvalidations
+	err := GetRepositoryFactory(ctx).RunInTransaction(ctx, func(ctx context.Context) error {
+		invalidations = &cacheInvalidations{}
+		ctx = context.WithValue(ctx, CacheInvalidationsKey, invalidations)
+		if !InTransaction(ctx) {
+			ctx = context.WithValue(ctx, TransactionKey, true)
+		}
+		return f(NewRepositories(ctx))
+	})
+	if err == nil && invalidations != nil {
+		invalidations.Apply()
+	}
+	return err
+}
+
+// Repositories creates the repositories of a context
+type Repositories struct {
+	Context context.Context
+}
+
+// NewRepositories creates a new Repositories
+func NewRepositories(ctx context.Context) Repositories {
+	return Repositories{Context: ctx}
+}
+
+func (repositories Repositories) GetContext() context.Context { return repositories.Context }
+
+// Kind returns the repository of a kind
+func (repositories Repositories) Kind(kind string, listeners ...signal.Listener) Repository {
+	return NewRepository(repositories.Context, kind, listeners...)
+}
+func (repositories Repositories) Users() *UserRepository {
+	return NewUserRepository(repositories.Context)
+}
+func (repositories Repositories) Roles() *RoleRepository {
+	return NewRoleRepository(repositories.Context)
+}
+func (repositories Repositories) UserRoles() *UserRoleRepository {
+	return NewUserRoleRepository(repositories.Context)
+}
+func (repositories Repositories) Snippets() *SnippetRepository {
+	return NewSnippetRepository(repositories.Context)
+}
+func (repositories Repositories) Categories() *CategoryRepository {
+	return NewCategoryRepository(repositories.Context)
+}
+func (repositories Repositories) Tokens() *TokenRepository {
+	return NewTokenRepository(repositories.Context)
+}
+func (repositories Repositories) PasswordResetTokens() *PasswordResetTokenRepository {
+	return NewPasswordResetTokenRepository(repositories.Context)
+}
+func (repositories Repositories) Migrations() *MigrationRepository {
+	return NewMigrationRepository(repositories.Context)
+}
+
+// RunInTransaction runs f in a transaction, or in the current one if any
+func (repositories Repositories) RunInTransaction(f func(tx Repositories) error) error {
+	return RunInTransaction(repositories.Context, f)
+}
+
 var (
 	ErrParentKeyNotFound = fmt.Errorf("ErrParentKeyNotFound")
 )
@@ -60,19 +229,30 @@ func (repository DefaultRepository) Create(entity Entity) error {
 		return err
 	}
 	low, _, err := datastore.AllocateIDs(repository.Context, repository.Kind, parentKey, 1)
+	if err != nil {
+		return err
+	}
 	entity.SetID(low)
+	return repository.CreateWithID(entity)
+}
 
-	if err == nil {
-		if repository.Signal != nil {
-			err = repository.Signal.Dispatch(BeforeEntityCreatedEvent{entity})
-			if err != nil {
-				return err
-			}
+// CreateWithID creates an entity with the ID it already has
+func (repository DefaultRepository) CreateWithID(entity Entity) error {
+	parentKey, err := repository.GetParentKey()
+	if err != nil {
+		return err
+	}
+	if repository.Signal != nil {
+		err = repository.Signal.Dispatch(BeforeEntityCreatedEvent{entity})
+		if err != nil {
+			return err
 		}
-		key := datastore.NewKey(repository.Context, repository.Kind, "", entity.GetID(), parentKey)
-		_, err = datastore.Put(repository.Context, key, entity)
 	}
-	return err
+	key := datastore.NewKey(repository.Context, repository.Kind, "", entity.GetID(), parentKey)
+	if _, err = datastore.Put(repository.Context, key, entity); err != nil || repository.Signal == nil {
+		return err
+	}
+	return repository.Signal.Dispatch(AfterEntityCreatedEvent{entity})
 }
 
 // Update an entity
@@ -93,8 +273,10 @@ func (repository DefaultRepository) Update(entity Entity) error {
 			return err
 		}
 	}
-	_, err = datastore.Put(repository.Context, key, entity)
-	return err
+	if _, err = datastore.Put(repository.Context, key, entity); err != nil || repository.Signal == nil {
+		return err
+	}
+	return repository.Signal.Dispatch(AfterEntityUpdatedEvent{entity})
 }
 
 // Delete an entity
@@ -110,7 +292,10 @@ func (repository DefaultRepository) Delete(entity Entity) error {
 			return err
 		}
 	}
-	return datastore.Delete(repository.Context, key)
+	if err = datastore.Delete(repository.Context, key); err != nil || repository.Signal == nil {
+		return err
+	}
+	return repository.Signal.Dispatch(AfterEntityDeletedEvent{entity})
 }
 
 // FindByID gets an entity by id
@@ -139,6 +324,80 @@ type Query struct {
 	Fields []string
 	Limit  int
 	Offset int
+	// Cursor is an opaque position returned by FindPage,
+	// the query starts after it
+	Cursor string
+}
+
+var (
+	ErrInvalidCursor = fmt.Errorf("ErrInvalidCursor")
+)
+
+// offsetCursor is the cursor of backends without native cursors
+type offsetCursor struct {
+	Offset int
+}
+
+func encodeOffsetCursor(offset int) string {
+	data, _ := json.Marshal(offsetCursor{offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOffsetCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	decoded := offsetCursor{}
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded.Offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return decoded.Offset, nil
+}
+
+// queryFilter is a parsed Query filter such as "Name=" or "Created >"
+type queryFilter struct {
+	Property string
+	Operator string
+	Value    interface{}
+}
+
+func parseQueryFilter(key string, value interface{}) (queryFilter, error) {
+	key = strings.TrimSpace(key)
+	for _, operator := range []string{"<=", ">=", "!=", "=", "<", ">"} {
+		if strings.HasSuffix(key, operator) {
+			return queryFilter{strings.TrimSpace(strings.TrimSuffix(key, operator)), operator, value}, nil
+		}
+	}
+	return queryFilter{}, fmt.Errorf("invalid filter %q", key)
+}
+
+// propertyName returns the datastore property name of a struct field,
+// or false if the field is not stored
+func propertyName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("datastore"), ",")[0]
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+func propertyByName(entity reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < entity.NumField(); i++ {
+		if property, ok := propertyName(entity.Type().Field(i)); ok && property == name {
+			return entity.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
 }
 
 func (repository DefaultRepository) FindBy(
@@ -148,22 +407,92 @@ func (repository DefaultRepository) FindBy(
 	if err != nil {
 		return err
 	}
-	q := repository.createQuery(query)
+	q, err := repository.createQuery(query)
+	if err != nil {
+		return err
+	}
 	_, err = q.Ancestor(parentKey).GetAll(repository.Context, result)
 	return err
 }
 
+// FindPage appends at most query.Limit entities to result
+// and returns the cursor of the next page, or an empty string on the last page
+func (repository DefaultRepository) FindPage(query Query, result interface{}) (string, error) {
+	parentKey, err := repository.GetParentKey()
+	if err != nil {
+		return "", err
+	}
+	slice := reflect.ValueOf(result)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("DefaultRepository: result should be a pointer to a slice, got %T", result)
+	}
+	slice = slice.Elem()
+	elementType := slice.Type().Elem()
+	limit := query.Limit
+	if limit > 0 {
+		// fetch one more entity to know if there is a next page
+		query.Limit = limit + 1
+	}
+	q, err := repository.createQuery(query)
+	if err != nil {
+		return "", err
+	}
+	iterator := q.Ancestor(parentKey).Run(repository.Context)
+	var fieldMismatch error
+	for count := 0; ; count++ {
+		var next datastore.Cursor
+		if limit > 0 && count == limit {
+			if next, err = iterator.Cursor(); err != nil {
+				return "", err
+			}
+		}
+		var element reflect.Value
+		if elementType.Kind() == reflect.Ptr {
+			element = reflect.New(elementType.Elem())
+		} else {
+			element = reflect.New(elementType)
+		}
+		_, err = iterator.Next(element.Interface())
+		if err == datastore.Done {
+			return "", fieldMismatch
+		}
+		if _, ok := err.(*datastore.ErrFieldMismatch); ok {
+			fieldMismatch = err
+		} else if err != nil {
+			return "", err
+		}
+		if limit > 0 && count == limit {
+			return next.String(), fieldMismatch
+		}
+		if elementType.Kind() != reflect.Ptr {
+			element = element.Elem()
+		}
+		slice.Set(reflect.Append(slice, element))
+	}
+}
+
 func (repository DefaultRepository) Count(
 	query Query) (int, error) {
 	parentKey, err := repository.GetParentKey()
 	if err != nil {
 		return 0, err
 	}
-	return repository.createQuery(query).Ancestor(parentKey).Count(repository.Context)
+	q, err := repository.createQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	return q.Ancestor(parentKey).Count(repository.Context)
 }
 
-func (repository DefaultRepository) createQuery(query Query) *datastore.Query {
+func (repository DefaultRepository) createQuery(query Query) (*datastore.Query, error) {
 	q := datastore.NewQuery(repository.Kind)
+	if query.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		q = q.Start(cursor)
+	}
 	for key, value := range query.Query {
 		q = q.Filter(key, value)
 	}
@@ -176,7 +505,7 @@ func (repository DefaultRepository) createQuery(query Query) *datastore.Query {
 	if query.Limit > 0 {
 		q = q.Limit(query.Limit)
 	}
-	return q.Offset(query.Offset)
+	return q.Offset(query.Offset), nil
 
 }
 
@@ -184,52 +513,186 @@ type UserRepository struct {
 	Repository
 	*RoleRepository
 	*UserRoleRepository
+	Context context.Context
 }
 
 func NewUserRepository(ctx context.Context) *UserRepository {
 
-	repository := &UserRepository{Repository: NewDefaultRepository(ctx, Kind.Users)}
+	repository := &UserRepository{Repository: NewRepository(ctx, Kind.Users), Context: ctx}
 	repository.RoleRepository = NewRoleRepository(ctx)
 	repository.UserRoleRepository = NewUserRoleRepository(ctx)
 	return repository
 }
 
+// Create creates a user with the User role,
+// the user is not created if the role cannot be granted
 func (u *UserRepository) Create(entity Entity) error {
 	if _, ok := entity.(*User); !ok {
 		return fmt.Errorf("Entity is ot of type *User")
 	}
+	return RunInTransaction(u.Context, func(tx Repositories) error {
+		err := tx.Kind(Kind.Users).Create(entity)
+		if err != nil {
+			return err
+		}
+		roles := []*Role{}
+		err = tx.Roles().FindBy(Query{Query: map[string]interface{}{"Name=": "User"}, Limit: 1}, &roles)
+		if err != nil {
+			return err
+		}
+		if len(roles) == 0 {
+			return fmt.Errorf("Role with Name User not found")
+		}
+		userRole := &UserRole{UserID: entity.GetID(), RoleID: roles[0].GetID()}
+		return tx.UserRoles().Create(userRole)
+	})
+}
 
-	err := u.Repository.Create(entity)
-	if err != nil {
-		return err
-	}
+type RoleRepository struct {
+	Repository
+}
+
+func NewRoleRepository(ctx context.Context) *RoleRepository {
+	return &RoleRepository{NewRepository(ctx, Kind.Roles)}
+}
+
+// FindByName finds the role named name, or returns ErrRoleNotFound
+func (repository RoleRepository) FindByName(name string, role *Role) error {
 	roles := []*Role{}
-	err = u.RoleRepository.FindBy(Query{Query: map[string]interface{}{"Name=": "User"}, Limit: 1}, &roles)
-	if err != nil {
+	if err := repository.FindBy(Query{Query: map[string]interface{}{"Name=": name}, Limit: 1}, &roles); err != nil {
 		return err
 	}
 	if len(roles) == 0 {
-		return fmt.Errorf("Role with Name User not found")
+		return ErrRoleNotFound
 	}
-	userRole := &UserRole{UserID: entity.GetID(), RoleID: roles[0].GetID()}
-	err = u.UserRoleRepository.Create(userRole)
-	return err
+	*role = *roles[0]
+	return nil
 }
 
-type RoleRepository struct {
+type CategoryRepository struct {
 	Repository
 }
 
-func NewRoleRepository(ctx context.Context) *RoleRepository {
-	return &RoleRepository{NewDefaultRepository(ctx, Kind.Roles)}
+func NewCategoryRepository(ctx context.Context) *CategoryRepository {
+	return &CategoryRepository{NewRepository(ctx, Kind.Categories)}
 }
 
-type CategoryRepository struct {
+// SnippetRepository stores snippets along with a revision of each of their versions
+type SnippetRepository struct {
 	Repository
+	Context context.Context
 }
 
-func NewCategoryRepository(ctx context.Context) *CategoryRepository {
-	return &CategoryRepository{NewDefaultRepository(ctx, Kind.Categories)}
+func NewSnippetRepository(ctx context.Context) *SnippetRepository {
+	return &SnippetRepository{Repository: NewRepository(ctx, Kind.Snippets), Context: ctx}
+}
+
+// FindByID finds a snippet and loads its author
+func (repository *SnippetRepository) FindByID(id int64, entity Entity) error {
+	if err := repository.Repository.FindByID(id, entity); err != nil {
+		return err
+	}
+	return loadSnippetAuthors(repository.Context, entity)
+}
+
+// FindBy finds snippets and loads their authors
+func (repository *SnippetRepository) FindBy(query Query, result interface{}) error {
+	if err := repository.Repository.FindBy(query, result); err != nil {
+		return err
+	}
+	return loadSnippetAuthors(repository.Context, result)
+}
+
+// FindPage finds a page of snippets and loads their authors
+func (repository *SnippetRepository) FindPage(query Query, result interface{}) (string, error) {
+	next, err := repository.Repository.FindPage(query, result)
+	if err != nil {
+		return next, err
+	}
+	return next, loadSnippetAuthors(repository.Context, result)
+}
+
+// Create creates a snippet and its first revision
+func (repository *SnippetRepository) Create(entity Entity) error {
+	snippet, ok := entity.(*Snippet)
+	if !ok {
+		return fmt.Errorf("Entity is not of type *Snippet")
+	}
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		if err := tx.Kind(Kind.Snippets).Create(snippet); err != nil {
+			return err
+		}
+		return tx.Kind(Kind.SnippetRevisions).Create(NewSnippetRevision(snippet))
+	})
+}
+
+// Update updates a snippet and stores a revision of its new version.
+// Snippets created before revisions existed get a revision of their previous version first.
+func (repository *SnippetRepository) Update(entity Entity) error {
+	snippet, ok := entity.(*Snippet)
+	if !ok {
+		return fmt.Errorf("Entity is not of type *Snippet")
+	}
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		revisions := tx.Kind(Kind.SnippetRevisions)
+		count, err := revisions.Count(Query{Query: map[string]interface{}{"SnippetID=": snippet.ID}})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			old := &Snippet{}
+			if err = tx.Kind(Kind.Snippets).FindByID(snippet.ID, old); err != nil {
+				return err
+			}
+			if err = revisions.Create(NewSnippetRevision(old)); err != nil {
+				return err
+			}
+		}
+		if err = tx.Kind(Kind.Snippets).Update(snippet); err != nil {
+			return err
+		}
+		return revisions.Create(NewSnippetRevision(snippet))
+	})
+}
+
+// FindRevisions finds the revisions of a snippet, oldest first
+func (repository *SnippetRepository) FindRevisions(snippetID int64, revisions *[]*SnippetRevision) error {
+	return NewRepository(repository.Context, Kind.SnippetRevisions).FindBy(Query{
+		Query: map[string]interface{}{"SnippetID=": snippetID},
+		Order: []string{"Version"},
+	}, revisions)
+}
+
+// FindRevision finds the revision of a snippet at version,
+// it returns datastore.ErrNoSuchEntity if there is none
+func (repository *SnippetRepository) FindRevision(snippetID int64, version int64, revision *SnippetRevision) error {
+	revisions := []*SnippetRevision{}
+	err := NewRepository(repository.Context, Kind.SnippetRevisions).FindBy(Query{
+		Query: map[string]interface{}{"SnippetID=": snippetID, "Version=": version},
+		Limit: 1,
+	}, &revisions)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		return datastore.ErrNoSuchEntity
+	}
+	*revision = *revisions[0]
+	return nil
+}
+
+// Revert updates snippet with the content of its revision at version,
+// which creates a new version
+func (repository *SnippetRepository) Revert(snippet *Snippet, version int64) error {
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		revision := &SnippetRevision{}
+		if err := tx.Snippets().FindRevision(snippet.ID, version, revision); err != nil {
+			return err
+		}
+		snippet.Title, snippet.Prefix, snippet.Scope, snippet.Description, snippet.Content, snippet.CategoryID =
+			revision.Title, revision.Prefix, revision.Scope, revision.Description, revision.Content, revision.CategoryID
+		return tx.Snippets().Update(snippet)
+	})
 }
 
 type MigrationRepository struct {
@@ -237,7 +700,49 @@ type MigrationRepository struct {
 }
 
 func NewMigrationRepository(ctx context.Context) *MigrationRepository {
-	return &MigrationRepository{NewDefaultRepository(ctx, Kind.Migrations)}
+	return &MigrationRepository{NewRepository(ctx, Kind.Migrations)}
+}
+
+type TokenRepository struct {
+	Repository
+}
+
+func NewTokenRepository(ctx context.Context) *TokenRepository {
+	return &TokenRepository{NewRepository(ctx, Kind.Tokens)}
+}
+
+// FindByValue finds the token whose Value is value, or returns datastore.ErrNoSuchEntity
+func (repository TokenRepository) FindByValue(value string, token *Token) error {
+	tokens := []*Token{}
+	if err := repository.FindBy(Query{Query: map[string]interface{}{"Value=": value}, Limit: 1}, &tokens); err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return datastore.ErrNoSuchEntity
+	}
+	*token = *tokens[0]
+	return nil
+}
+
+type PasswordResetTokenRepository struct {
+	Repository
+}
+
+func NewPasswordResetTokenRepository(ctx context.Context) *PasswordResetTokenRepository {
+	return &PasswordResetTokenRepository{NewRepository(ctx, Kind.PasswordResetTokens)}
+}
+
+// FindByValue finds the password reset token whose Value is value, or returns datastore.ErrNoSuchEntity
+func (repository PasswordResetTokenRepository) FindByValue(value string, token *PasswordResetToken) error {
+	tokens := []*PasswordResetToken{}
+	if err := repository.FindBy(Query{Query: map[string]interface{}{"Value=": value}, Limit: 1}, &tokens); err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return datastore.ErrNoSuchEntity
+	}
+	*token = *tokens[0]
+	return nil
 }
 
 type UserRoleRepository struct {
@@ -245,5 +750,5 @@ type UserRoleRepository struct {
 }
 
 func NewUserRoleRepository(ctx context.Context) *UserRoleRepository {
-	return &UserRoleRepository{NewDefaultRepository(ctx, Kind.UserRoles)}
+	return &UserRoleRepository{NewRepository(ctx, Kind.UserRoles)}
 }
@@ -1,6 +1,7 @@
 package smartsnippets_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/Mparaiso/expect-go"
@@ -31,3 +32,49 @@ func SubTestUserRepositoryCreate(t *testing.T, ctx context.Context) {
 	expect.Expect(t, err, nil)
 	expect.Expect(t, user.GetID() > 0, true)
 }
+
+func TestRunInTransaction(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	err := app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		if err := tx.Categories().Create(&app.Category{Title: "Go"}); err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	expect.Expect(t, err != nil, true)
+	count, err := app.NewCategoryRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0)
+
+	t.Log("A rollback keeps the writes made outside of the transaction")
+	python := &app.Category{Title: "Python"}
+	expect.Expect(t, app.NewCategoryRepository(ctx).Create(python), nil)
+	err = app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		python.Description = "Edited in the transaction"
+		if err := tx.Categories().Update(python); err != nil {
+			return err
+		}
+		// tokens have no unique field, they are written without transaction
+		if err := app.NewTokenRepository(ctx).Create(&app.Token{Value: "outside"}); err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	expect.Expect(t, err != nil, true)
+	result := &app.Category{}
+	expect.Expect(t, app.NewCategoryRepository(ctx).FindByID(python.ID, result), nil)
+	expect.Expect(t, result.Description, "", "The update is rolled back")
+	count, err = app.NewTokenRepository(ctx).Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 1)
+}
+
+func TestUserRepository_Create_WithoutRoles(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	repository := app.NewUserRepository(ctx)
+	err := repository.Create(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"})
+	expect.Expect(t, err != nil, true, "The User role does not exist")
+	count, err := repository.Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 0, "The user should not be created")
+}
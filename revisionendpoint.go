@@ -0,0 +1,168 @@
+package smartsnippets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"google.golang.org/appengine/datastore"
+)
+
+// SnippetRevisionEndpoint serves the revision history of snippets
+type SnippetRevisionEndpoint struct{}
+
+func NewSnippetRevisionEndpoint() *SnippetRevisionEndpoint {
+	return &SnippetRevisionEndpoint{}
+}
+
+func (endpoint SnippetRevisionEndpoint) Connect(routeCollection *tiger.RouteCollection) {
+	routeCollection.
+		Get("/:id/revisions", endpoint.Index).
+		Get("/:id/revisions/:version", endpoint.Get).
+		Get("/:id/diff", endpoint.Diff).
+		Post("/:id/revert/:version", endpoint.Revert)
+}
+
+// Index lists the revisions of a snippet, oldest first
+func (endpoint SnippetRevisionEndpoint) Index(c tiger.Container) {
+	container, repository, snippet, ok := endpoint.findSnippet(c)
+	if !ok {
+		return
+	}
+	revisions := []*SnippetRevision{}
+	if err := repository.FindRevisions(snippet.ID, &revisions); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(revisions); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Get fetches the revision of a snippet at a version
+func (endpoint SnippetRevisionEndpoint) Get(c tiger.Container) {
+	container, repository, snippet, ok := endpoint.findSnippet(c)
+	if !ok {
+		return
+	}
+	revision, ok := endpoint.findRevision(container, repository, snippet, container.GetRequest().URL.Query().Get(":version"))
+	if !ok {
+		return
+	}
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(revision); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Diff writes the unified diff between the from and to versions of a snippet,
+// to defaults to the current version
+func (endpoint SnippetRevisionEndpoint) Diff(c tiger.Container) {
+	container, repository, snippet, ok := endpoint.findSnippet(c)
+	if !ok {
+		return
+	}
+	parameters := container.GetRequest().URL.Query()
+	if parameters.Get("to") == "" {
+		parameters.Set("to", strconv.FormatInt(snippet.Version, 10))
+	}
+	from, ok := endpoint.findRevision(container, repository, snippet, parameters.Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := endpoint.findRevision(container, repository, snippet, parameters.Get("to"))
+	if !ok {
+		return
+	}
+	container.GetResponseWriter().Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	fmt.Fprint(container.GetResponseWriter(), DiffSnippetRevisions(from, to))
+}
+
+// Revert restores the content of a snippet at a version as a new version,
+// like an update only the author of the snippet or a SuperAdmin can revert it
+func (endpoint SnippetRevisionEndpoint) Revert(c tiger.Container) {
+	container, repository, snippet, ok := endpoint.findSnippet(c)
+	if !ok {
+		return
+	}
+	switch err := authorizeSnippetChange(container, snippet); err {
+	case nil:
+	case ErrAuthenticationRequired:
+		container.GetResponseWriter().Header().Set("WWW-Authenticate", "Bearer")
+		container.Error(err, http.StatusUnauthorized)
+		return
+	case ErrForbidden:
+		container.Error(err, http.StatusForbidden)
+		return
+	default:
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	version, err := strconv.ParseInt(container.GetRequest().URL.Query().Get(":version"), 10, 64)
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	err = repository.Revert(snippet, version)
+	if err == datastore.ErrNoSuchEntity {
+		container.Error(err, http.StatusNotFound)
+		return
+	}
+	if _, ok := err.(VersionMismatchError); ok {
+		container.Error(err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetRequest().Method = "GET"
+	http.Redirect(container.GetResponseWriter(), container.GetRequest(), fmt.Sprintf("/snippets/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// findSnippet finds the snippet of the request, or writes an error
+func (SnippetRevisionEndpoint) findSnippet(c tiger.Container) (ContextAwareContainer, *SnippetRepository, *Snippet, bool) {
+	container, ok := c.(ContextAwareContainer)
+	if !ok {
+		c.Error(fmt.Errorf("Container does not implement ContextAwareContainer"), http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+	id, err := strconv.ParseInt(container.GetRequest().URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	repository := NewSnippetRepository(container.GetContext())
+	snippet := &Snippet{}
+	err = repository.FindByID(id, snippet)
+	if err == datastore.ErrNoSuchEntity {
+		container.Error(err, http.StatusNotFound)
+		return nil, nil, nil, false
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+	return container, repository, snippet, true
+}
+
+// findRevision finds the revision of snippet at version, or writes an error
+func (SnippetRevisionEndpoint) findRevision(container ContextAwareContainer, repository *SnippetRepository, snippet *Snippet, version string) (*SnippetRevision, bool) {
+	number, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		container.Error(fmt.Errorf("Invalid version %q", version), http.StatusBadRequest)
+		return nil, false
+	}
+	revision := &SnippetRevision{}
+	err = repository.FindRevision(snippet.ID, number, revision)
+	if err == datastore.ErrNoSuchEntity {
+		container.Error(err, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return revision, true
+}
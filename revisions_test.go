@@ -0,0 +1,71 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	expect.Expect(t, app.UnifiedDiff("from", "to", from, to), `--- from
++++ to
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`)
+	expect.Expect(t, app.UnifiedDiff("from", "to", from, from), "")
+	expect.Expect(t, app.UnifiedDiff("from", "to", "", "a"), "--- from\n+++ to\n@@ -0,0 +1,1 @@\n+a\n")
+
+	t.Log("Large texts are replaced instead of compared line by line")
+	fromLines, toLines := []string{}, []string{}
+	for i := 0; i < 5000; i++ {
+		fromLines, toLines = append(fromLines, fmt.Sprintf("from %d", i)), append(toLines, fmt.Sprintf("to %d", i))
+	}
+	diff := app.UnifiedDiff("from", "to", "first\n"+strings.Join(fromLines, "\n"), "first\n"+strings.Join(toLines, "\n"))
+	expect.Expect(t, strings.HasPrefix(diff, "--- from\n+++ to\n@@ -1,5001 +1,5001 @@\n first\n-from 0\n"), true)
+	expect.Expect(t, strings.Count(diff, "\n-from "), 5000)
+	expect.Expect(t, strings.Count(diff, "\n+to "), 5000)
+}
+
+func TestSnippetRepository_Revisions(t *testing.T) {
+	repository := app.NewSnippetRepository(SetUpMemoryContext())
+	snippet := &app.Snippet{Title: "Hello", Content: "fmt.Println(\"Hello\")"}
+	expect.Expect(t, repository.Create(snippet), nil)
+	snippet.Content = "fmt.Println(\"Hello World\")"
+	expect.Expect(t, repository.Update(snippet), nil)
+	revisions := []*app.SnippetRevision{}
+	expect.Expect(t, repository.FindRevisions(snippet.ID, &revisions), nil)
+	expect.Expect(t, len(revisions), 2)
+	expect.Expect(t, revisions[0].Version, int64(1))
+	expect.Expect(t, revisions[1].Content, "fmt.Println(\"Hello World\")")
+
+	t.Log("Revert")
+	expect.Expect(t, repository.Revert(snippet, 1), nil)
+	expect.Expect(t, snippet.Version, int64(3))
+	expect.Expect(t, snippet.Content, "fmt.Println(\"Hello\")")
+	revision := &app.SnippetRevision{}
+	expect.Expect(t, repository.FindRevision(snippet.ID, 3, revision), nil)
+	expect.Expect(t, revision.Content, "fmt.Println(\"Hello\")")
+	expect.Expect(t, repository.Revert(snippet, 10), datastore.ErrNoSuchEntity)
+
+	t.Log("Delete")
+	expect.Expect(t, repository.Delete(snippet), nil)
+	revisions = []*app.SnippetRevision{}
+	expect.Expect(t, repository.FindRevisions(snippet.ID, &revisions), nil)
+	expect.Expect(t, len(revisions), 0)
+}
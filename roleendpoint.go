@@ -0,0 +1,217 @@
+package smartsnippets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"google.golang.org/appengine/datastore"
+)
+
+// RoleEndpoint lets SuperAdmin users manage the roles and grant them to users.
+// Built-in roles are locked, users can only grant or revoke the built-in roles they have
+type RoleEndpoint struct{}
+
+func NewRoleEndpoint() *RoleEndpoint {
+	return &RoleEndpoint{}
+}
+
+func (endpoint RoleEndpoint) Connect(routeCollection *tiger.RouteCollection) {
+	routeCollection.
+		Use(RequireRole(RoleSuperAdmin)).
+		Get("/", endpoint.Index).
+		Post("/", endpoint.Post).
+		Put("/:id", endpoint.Put).
+		Delete("/:id", endpoint.Delete).
+		Get("/:id/users", endpoint.Users).
+		Put("/:id/users/:user", endpoint.Grant).
+		Delete("/:id/users/:user", endpoint.Revoke)
+}
+
+// Index lists the roles
+func (endpoint RoleEndpoint) Index(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	roles := []*Role{}
+	if err := NewRoleRepository(container.GetContext()).FindAll(&roles); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(roles); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Post creates a custom role
+func (endpoint RoleEndpoint) Post(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	role := &Role{}
+	if err := json.NewDecoder(container.GetRequest().Body).Decode(role); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	role.ID = 0
+	if err := CreateRole(container.GetContext(), container.GetCurrentUser(), role); err != nil {
+		endpoint.error(container, err)
+		return
+	}
+	container.GetResponseWriter().Header().Set("Location", fmt.Sprintf("%s/%d", container.GetRequest().URL.Path, role.ID))
+	container.GetResponseWriter().WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(role); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Put renames or describes a custom role
+func (endpoint RoleEndpoint) Put(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	role := &Role{}
+	if err := json.NewDecoder(container.GetRequest().Body).Decode(role); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	id, ok := endpoint.id(container, ":id")
+	if !ok {
+		return
+	}
+	role.ID = id
+	if err := UpdateRole(container.GetContext(), container.GetCurrentUser(), role); err != nil {
+		endpoint.error(container, err)
+		return
+	}
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(role); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Delete deletes a custom role which is not granted to any user
+func (endpoint RoleEndpoint) Delete(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	id, ok := endpoint.id(container, ":id")
+	if !ok {
+		return
+	}
+	if err := DeleteRole(container.GetContext(), container.GetCurrentUser(), &Role{ID: id}); err != nil {
+		endpoint.error(container, err)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+// Users lists the users having a role
+func (endpoint RoleEndpoint) Users(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	role, ok := endpoint.findRole(container)
+	if !ok {
+		return
+	}
+	users, err := RoleUsers(container.GetContext(), role.ID)
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(users); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Grant gives a role to a user
+func (endpoint RoleEndpoint) Grant(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	role, user, ok := endpoint.findMembership(container)
+	if !ok {
+		return
+	}
+	if err := GrantRole(container.GetContext(), container.GetCurrentUser(), user, role.Name); err != nil {
+		endpoint.error(container, err)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+// Revoke takes a role from a user, the last Root user keeps the Root role
+func (endpoint RoleEndpoint) Revoke(c tiger.Container) {
+	container := c.(ContextAwareContainer)
+	role, user, ok := endpoint.findMembership(container)
+	if !ok {
+		return
+	}
+	if err := RevokeRole(container.GetContext(), container.GetCurrentUser(), user, role.Name); err != nil {
+		endpoint.error(container, err)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+func (RoleEndpoint) id(container ContextAwareContainer, parameter string) (int64, bool) {
+	id, err := strconv.ParseInt(container.GetRequest().URL.Query().Get(parameter), 10, 64)
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func (endpoint RoleEndpoint) findRole(container ContextAwareContainer) (*Role, bool) {
+	id, ok := endpoint.id(container, ":id")
+	if !ok {
+		return nil, false
+	}
+	role := &Role{}
+	if err := NewRoleRepository(container.GetContext()).FindByID(id, role); err != nil {
+		endpoint.error(container, err)
+		return nil, false
+	}
+	return role, true
+}
+
+// findMembership finds the role and the user of a grant or a revocation,
+// the current user must have the role if it is a locked built-in role
+func (endpoint RoleEndpoint) findMembership(container ContextAwareContainer) (*Role, *User, bool) {
+	role, ok := endpoint.findRole(container)
+	if !ok {
+		return nil, nil, false
+	}
+	id, ok := endpoint.id(container, ":user")
+	if !ok {
+		return nil, nil, false
+	}
+	user := &User{}
+	if err := NewUserRepository(container.GetContext()).FindByID(id, user); err != nil {
+		endpoint.error(container, err)
+		return nil, nil, false
+	}
+	if role.IsLocked() {
+		allowed, err := HasRole(container.GetContext(), container.GetCurrentUser(), role.Name)
+		if err != nil {
+			container.Error(err, http.StatusInternalServerError)
+			return nil, nil, false
+		} else if !allowed {
+			container.Error(ErrForbidden, http.StatusForbidden)
+			return nil, nil, false
+		}
+	}
+	return role, user, true
+}
+
+// error answers with the status matching err
+func (RoleEndpoint) error(container ContextAwareContainer, err error) {
+	switch err.(type) {
+	case ErrUniqueViolation, ErrReferenceViolation, VersionMismatchError:
+		container.Error(err, http.StatusConflict)
+		return
+	}
+	switch err {
+	case ErrInvalidRole:
+		container.Error(err, http.StatusBadRequest)
+	case ErrLockedEntity:
+		container.Error(err, http.StatusForbidden)
+	case ErrLastRoot:
+		container.Error(err, http.StatusConflict)
+	case datastore.ErrNoSuchEntity, ErrRoleNotFound:
+		container.Error(err, http.StatusNotFound)
+	default:
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,187 @@
+package smartsnippets
+
+import (
+	"fmt"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	ErrRoleNotFound = fmt.Errorf("ErrRoleNotFound")
+	ErrInvalidRole  = fmt.Errorf("ErrInvalidRole : Name is required")
+	// ErrLastRoot is returned when the Root role of the last Root user would be revoked
+	ErrLastRoot = fmt.Errorf("ErrLastRoot")
+)
+
+// Audit actions
+const (
+	AuditRoleCreated = "role.created"
+	AuditRoleUpdated = "role.updated"
+	AuditRoleDeleted = "role.deleted"
+	AuditRoleGranted = "role.granted"
+	AuditRoleRevoked = "role.revoked"
+)
+
+func init() {
+	RegisterKindListener(Kind.UserRoles, NewLastRootListener)
+}
+
+// NewLastRootListener keeps the last Root user from losing the Root role,
+// whether the role is revoked or the user deleted
+func NewLastRootListener(ctx context.Context) signal.Listener {
+	return signal.ListenerFunc(func(e signal.Event) error {
+		event, ok := e.(BeforeEntityDeletedEvent)
+		if !ok {
+			return nil
+		}
+		if _, ok := event.Entity.(*UserRole); !ok {
+			return nil
+		}
+		userRole := &UserRole{}
+		if err := NewUserRoleRepository(ctx).FindByID(event.Entity.GetID(), userRole); err != nil {
+			return err
+		}
+		root := &Role{}
+		if err := NewRoleRepository(ctx).FindByName(RoleRoot, root); err == ErrRoleNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if userRole.RoleID != root.ID {
+			return nil
+		}
+		count, err := NewUserRoleRepository(ctx).Count(Query{Query: map[string]interface{}{"RoleID=": root.ID}})
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrLastRoot
+		}
+		return nil
+	})
+}
+
+// audit records a change made by actor, actor is nil for the command line or the admin token
+func audit(ctx context.Context, actor *User, action string, kind string, entityID int64, userID int64, details string) error {
+	entry := &AuditEntry{Action: action, EntityKind: kind, EntityID: entityID, UserID: userID, Details: details}
+	if actor != nil {
+		entry.ActorID = actor.ID
+	}
+	return NewRepository(ctx, Kind.AuditEntries).Create(entry)
+}
+
+// CreateRole creates a custom role, custom roles are never locked
+func CreateRole(ctx context.Context, actor *User, role *Role) error {
+	if role.Name == "" {
+		return ErrInvalidRole
+	}
+	role.Locked = false
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		if err := tx.Roles().Create(role); err != nil {
+			return err
+		}
+		return audit(tx.GetContext(), actor, AuditRoleCreated, Kind.Roles, role.ID, 0, role.Name)
+	})
+}
+
+// UpdateRole renames or describes a custom role, locked roles return ErrLockedEntity.
+// A role without version is updated from its current version
+func UpdateRole(ctx context.Context, actor *User, role *Role) error {
+	if role.Name == "" {
+		return ErrInvalidRole
+	}
+	role.Locked = false
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		old := &Role{}
+		if err := tx.Roles().FindByID(role.ID, old); err != nil {
+			return err
+		}
+		if role.Version == 0 {
+			role.Version = old.Version
+		}
+		if err := tx.Roles().Update(role); err != nil {
+			return err
+		}
+		return audit(tx.GetContext(), actor, AuditRoleUpdated, Kind.Roles, role.ID, 0, fmt.Sprintf("%s -> %s", old.Name, role.Name))
+	})
+}
+
+// DeleteRole deletes a custom role, locked roles return ErrLockedEntity
+// and roles granted to users an ErrReferenceViolation
+func DeleteRole(ctx context.Context, actor *User, role *Role) error {
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		if err := tx.Roles().FindByID(role.ID, role); err != nil {
+			return err
+		}
+		if role.IsLocked() {
+			return ErrLockedEntity
+		}
+		if err := tx.Roles().Delete(role); err != nil {
+			return err
+		}
+		return audit(tx.GetContext(), actor, AuditRoleDeleted, Kind.Roles, role.ID, 0, role.Name)
+	})
+}
+
+// GrantRole gives the role named role to user, granting a role twice has no effect
+func GrantRole(ctx context.Context, actor *User, user *User, role string) error {
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		granted := &Role{}
+		if err := tx.Roles().FindByName(role, granted); err != nil {
+			return err
+		}
+		userRoles := []*UserRole{}
+		query := Query{Query: map[string]interface{}{"UserID=": user.ID, "RoleID=": granted.ID}, Limit: 1}
+		if err := tx.UserRoles().FindBy(query, &userRoles); err != nil || len(userRoles) > 0 {
+			return err
+		}
+		userRole := &UserRole{UserID: user.ID, RoleID: granted.ID}
+		if err := tx.UserRoles().Create(userRole); err != nil {
+			return err
+		}
+		return audit(tx.GetContext(), actor, AuditRoleGranted, Kind.UserRoles, userRole.ID, user.ID, role)
+	})
+}
+
+// RevokeRole takes the role named role from user,
+// it returns datastore.ErrNoSuchEntity if user does not have the role
+// and ErrLastRoot if user is the last Root user
+func RevokeRole(ctx context.Context, actor *User, user *User, role string) error {
+	return RunInTransaction(ctx, func(tx Repositories) error {
+		revoked := &Role{}
+		if err := tx.Roles().FindByName(role, revoked); err != nil {
+			return err
+		}
+		userRoles := []*UserRole{}
+		query := Query{Query: map[string]interface{}{"UserID=": user.ID, "RoleID=": revoked.ID}, Limit: 1}
+		if err := tx.UserRoles().FindBy(query, &userRoles); err != nil {
+			return err
+		} else if len(userRoles) == 0 {
+			return datastore.ErrNoSuchEntity
+		}
+		if err := tx.UserRoles().Delete(userRoles[0]); err != nil {
+			return err
+		}
+		return audit(tx.GetContext(), actor, AuditRoleRevoked, Kind.UserRoles, userRoles[0].ID, user.ID, role)
+	})
+}
+
+// RoleUsers returns the users having the role whose ID is roleID, without passwords
+func RoleUsers(ctx context.Context, roleID int64) ([]*User, error) {
+	userRoles := []*UserRole{}
+	if err := NewUserRoleRepository(ctx).FindBy(Query{Query: map[string]interface{}{"RoleID=": roleID}}, &userRoles); err != nil {
+		return nil, err
+	}
+	users := []*User{}
+	for _, userRole := range userRoles {
+		user := &User{}
+		if err := NewUserRepository(ctx).FindByID(userRole.UserID, user); err != nil {
+			return nil, err
+		}
+		user.Password, user.EncryptedPassworld = "", ""
+		users = append(users, user)
+	}
+	return users, nil
+}
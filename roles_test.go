@@ -0,0 +1,114 @@
+package smartsnippets_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestRoles(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	john, jane := &app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}, &app.User{Nickname: "JaneDoe", Email: "jane.doe@acme.com"}
+	expect.Expect(t, app.NewUserRepository(ctx).Create(john), nil)
+	expect.Expect(t, app.NewUserRepository(ctx).Create(jane), nil)
+
+	t.Log("Custom roles")
+	editor := &app.Role{Name: "Editor", Locked: true}
+	expect.Expect(t, app.CreateRole(ctx, john, editor), nil)
+	expect.Expect(t, editor.IsLocked(), false, "Custom roles are never locked")
+	expect.Expect(t, app.CreateRole(ctx, john, &app.Role{}), app.ErrInvalidRole)
+	editor.Description = "Edits the categories"
+	expect.Expect(t, app.UpdateRole(ctx, john, editor), nil)
+
+	t.Log("Built-in roles are locked")
+	user := &app.Role{}
+	expect.Expect(t, app.NewRoleRepository(ctx).FindByName(app.RoleUser, user), nil)
+	user.Description = "Edited"
+	expect.Expect(t, app.UpdateRole(ctx, john, user), app.ErrLockedEntity)
+	expect.Expect(t, app.DeleteRole(ctx, john, &app.Role{ID: user.ID}), app.ErrLockedEntity)
+
+	t.Log("The last Root user keeps the Root role")
+	expect.Expect(t, app.GrantRole(ctx, nil, john, app.RoleRoot), nil)
+	expect.Expect(t, app.RevokeRole(ctx, john, john, app.RoleRoot), app.ErrLastRoot)
+	expect.Expect(t, app.NewUserRepository(ctx).Delete(john), app.ErrLastRoot)
+	expect.Expect(t, app.GrantRole(ctx, john, jane, app.RoleRoot), nil)
+	expect.Expect(t, app.RevokeRole(ctx, jane, john, app.RoleRoot), nil)
+	expect.Expect(t, app.RevokeRole(ctx, jane, john, app.RoleRoot) != nil, true, "John is not Root anymore")
+
+	t.Log("Changes are audited")
+	entries := []*app.AuditEntry{}
+	expect.Expect(t, app.NewRepository(ctx, app.Kind.AuditEntries).FindBy(app.Query{Order: []string{"Created"}}, &entries), nil)
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	expect.Expect(t, strings.Join(actions, ","), "role.created,role.updated,role.granted,role.granted,role.revoked")
+	expect.Expect(t, entries[4].ActorID, jane.ID)
+	expect.Expect(t, entries[4].UserID, john.ID)
+	expect.Expect(t, entries[4].Details, app.RoleRoot)
+}
+
+func TestMemoryApp_Roles(t *testing.T) {
+	App := SetUpMemoryApp()
+	handler := App.Compile()
+	userToken := SetUpUserToken(t, App, "JohnDoe")
+	adminToken := SetUpUserToken(t, App, "JaneDoe", app.RoleSuperAdmin)
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Log("GET /roles")
+	expect.Expect(t, serve("GET", "/roles", userToken, "").Code, http.StatusForbidden, "Status")
+	response := serve("GET", "/roles", adminToken, "")
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	roles := app.Roles{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&roles), nil)
+	expect.Expect(t, len(roles), 4)
+	users := []*app.User{}
+	expect.Expect(t, json.NewDecoder(serve("GET", fmt.Sprintf("/roles/%d/users", roles.GetByName(app.RoleUser).ID), adminToken, "").Body).Decode(&users), nil)
+	expect.Expect(t, len(users), 3, "Anonymous, JohnDoe and JaneDoe")
+	john := users[0]
+	for _, user := range users {
+		if user.Nickname == "JohnDoe" {
+			john = user
+		}
+	}
+
+	t.Log("POST /roles")
+	response = serve("POST", "/roles", adminToken, `{"Name":"Editor"}`)
+	expect.Expect(t, response.Code, http.StatusCreated, "Status", response.Body.String())
+	editor := &app.Role{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(editor), nil)
+	expect.Expect(t, serve("POST", "/roles", adminToken, `{"Name":"Editor"}`).Code, http.StatusConflict, "Status")
+	expect.Expect(t, serve("PUT", fmt.Sprintf("/roles/%d", roles.GetByName(app.RoleUser).ID), adminToken, `{"Name":"Member"}`).Code, http.StatusForbidden, "Status")
+
+	t.Log("PUT /roles/:id/users/:user")
+	expect.Expect(t, serve("PUT", fmt.Sprintf("/roles/%d/users/%d", editor.ID, john.ID), adminToken, "").Code, http.StatusNoContent, "Status")
+	expect.Expect(t, serve("PUT", fmt.Sprintf("/roles/%d/users/%d", roles.GetByName(app.RoleRoot).ID, john.ID), adminToken, "").Code, http.StatusForbidden,
+		"A SuperAdmin cannot grant Root")
+	expect.Expect(t, serve("DELETE", fmt.Sprintf("/roles/%d", editor.ID), adminToken, "").Code, http.StatusConflict, "A granted role cannot be deleted")
+
+	t.Log("DELETE /roles/:id/users/:user")
+	expect.Expect(t, serve("DELETE", fmt.Sprintf("/roles/%d/users/%d", editor.ID, john.ID), adminToken, "").Code, http.StatusNoContent, "Status")
+	expect.Expect(t, serve("DELETE", fmt.Sprintf("/roles/%d/users/%d", editor.ID, john.ID), adminToken, "").Code, http.StatusNotFound, "Status")
+	expect.Expect(t, serve("DELETE", fmt.Sprintf("/roles/%d", editor.ID), adminToken, "").Code, http.StatusNoContent, "Status")
+
+	t.Log("GET /audit")
+	expect.Expect(t, serve("GET", "/audit", userToken, "").Code, http.StatusForbidden, "Status")
+	response = serve("GET", "/audit?filter[Action]=role.granted", adminToken, "")
+	expect.Expect(t, response.Code, http.StatusOK, "Status")
+	entries := []*app.AuditEntry{}
+	expect.Expect(t, json.NewDecoder(response.Body).Decode(&entries), nil)
+	expect.Expect(t, len(entries), 2, "JaneDoe became SuperAdmin then granted Editor")
+}
@@ -0,0 +1,315 @@
+package smartsnippets
+
+import (
+	"fmt"
+	stdlog "log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/search"
+)
+
+// SearchIndex is a full text index of snippets
+type SearchIndex interface {
+	Put(ctx context.Context, document SearchDocument) error
+	Delete(ctx context.Context, id int64) error
+	// Search returns at most limit results ranked by relevance.
+	// The query is a list of words and "quoted phrases" that must all match,
+	// optionally restricted to a field with the title:, description:, content: or category: prefixes
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// SearchDocument is the indexed representation of a snippet
+type SearchDocument struct {
+	ID          int64
+	Title       string
+	Description string
+	Content     string
+	Category    string
+}
+
+// SearchResult is a snippet matching a search,
+// Highlights holds for each matching field a fragment with the matches wrapped in <b> tags
+type SearchResult struct {
+	ID         int64
+	Score      float64
+	Highlights map[string]string
+	Snippet    *Snippet `json:",omitempty"`
+}
+
+var (
+	ErrSearchIndexNotFound = fmt.Errorf("ErrSearchIndexNotFound")
+)
+
+// searchFields maps the field prefixes of a search query to SearchDocument fields
+var searchFields = map[string]string{"title": "Title", "description": "Description", "content": "Content", "category": "Category"}
+
+func init() {
+	RegisterKindListener(Kind.Snippets, NewSearchIndexListener)
+}
+
+// WithSearchIndex returns a context in which snippets are indexed in index
+func WithSearchIndex(ctx context.Context, index SearchIndex) context.Context {
+	return context.WithValue(ctx, SearchIndexKey, index)
+}
+
+// GetSearchIndex returns the SearchIndex of the context,
+// datastore backed contexts default to the App Engine search index
+func GetSearchIndex(ctx context.Context) (SearchIndex, error) {
+	if index, ok := ctx.Value(SearchIndexKey).(SearchIndex); ok {
+		return index, nil
+	}
+	if UsesDatastore(ctx) {
+		return AppengineSearchIndex{Name: Kind.Snippets}, nil
+	}
+	return nil, ErrSearchIndexNotFound
+}
+
+// NewSearchIndexListener keeps the SearchIndex of the context in sync
+// with the snippets created, updated and deleted by repositories.
+// The index is changed once the snippets are written, after the commit of their transaction if any,
+// so rolled back changes are not indexed
+func NewSearchIndexListener(ctx context.Context) signal.Listener {
+	index, err := GetSearchIndex(ctx)
+	if err != nil {
+		return nil
+	}
+	return signal.ListenerFunc(func(e signal.Event) error {
+		var snippet *Snippet
+		deleted := false
+		switch event := e.(type) {
+		case AfterEntityCreatedEvent:
+			snippet, _ = event.Entity.(*Snippet)
+		case AfterEntityUpdatedEvent:
+			snippet, _ = event.Entity.(*Snippet)
+		case AfterEntityDeletedEvent:
+			snippet, deleted = event.Entity.(*Snippet)
+		}
+		if snippet == nil {
+			return nil
+		}
+		id := snippet.GetID()
+		change := func() error { return index.Delete(ctx, id) }
+		if !deleted {
+			// the document is built in the transaction, which reads the category of the snippet
+			document, err := NewSnippetDocument(ctx, snippet)
+			if err != nil {
+				return err
+			}
+			change = func() error { return index.Put(ctx, document) }
+		}
+		if !InTransaction(ctx) {
+			return change()
+		}
+		afterCommit(ctx, func() {
+			if err := change(); err != nil {
+				stdlog.Printf("ERROR Updating the search index : %s", err)
+			}
+		})
+		return nil
+	})
+}
+
+// NewSnippetDocument returns the search document of snippet, with the title of its category
+func NewSnippetDocument(ctx context.Context, snippet *Snippet) (SearchDocument, error) {
+	document := SearchDocument{ID: snippet.ID, Title: snippet.Title, Description: snippet.Description, Content: snippet.Content}
+	if snippet.CategoryID != 0 {
+		category := &Category{}
+		err := NewCategoryRepository(ctx).FindByID(snippet.CategoryID, category)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return document, err
+		}
+		document.Category = category.Title
+	}
+	return document, nil
+}
+
+// IndexSnippet puts snippet and the title of its category in index
+func IndexSnippet(ctx context.Context, index SearchIndex, snippet *Snippet) error {
+	document, err := NewSnippetDocument(ctx, snippet)
+	if err != nil {
+		return err
+	}
+	return index.Put(ctx, document)
+}
+
+// ReindexSnippets puts every snippet in index,
+// it is used to fill indexes that are not persisted
+func ReindexSnippets(ctx context.Context, index SearchIndex) error {
+	snippets := []*Snippet{}
+	if err := NewSnippetRepository(ctx).FindAll(&snippets); err != nil {
+		return err
+	}
+	for _, snippet := range snippets {
+		if err := IndexSnippet(ctx, index, snippet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchClause is a word or a phrase of a search query, optionally restricted to a field
+type searchClause struct {
+	Field string
+	Terms []string
+}
+
+// parseSearchQuery splits a search query into clauses
+func parseSearchQuery(query string) ([]searchClause, error) {
+	clauses := []searchClause{}
+	for query = strings.TrimSpace(query); query != ""; query = strings.TrimSpace(query) {
+		clause := searchClause{}
+		// other prefixes, like std::move or http://, are searched as text
+		if i := strings.Index(query, ":"); i > 0 && strings.IndexFunc(query[:i], unicode.IsSpace) == -1 {
+			if field, ok := searchFields[strings.ToLower(query[:i])]; ok {
+				clause.Field, query = field, query[i+1:]
+			}
+		}
+		var text string
+		if strings.HasPrefix(query, `"`) {
+			end := strings.Index(query[1:], `"`)
+			if end == -1 {
+				return nil, fmt.Errorf("Unterminated phrase in search query")
+			}
+			text, query = query[1:end+1], query[end+2:]
+		} else if end := strings.IndexFunc(query, unicode.IsSpace); end != -1 {
+			text, query = query[:end], query[end:]
+		} else {
+			text, query = query, ""
+		}
+		for _, token := range tokenize(text) {
+			clause.Terms = append(clause.Terms, token.Text)
+		}
+		if len(clause.Terms) > 0 {
+			clauses = append(clauses, clause)
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("Empty search query")
+	}
+	return clauses, nil
+}
+
+// searchToken is a lower cased word and its byte offsets in a text
+type searchToken struct {
+	Text       string
+	Start, End int
+}
+
+func tokenize(text string) []searchToken {
+	tokens := []searchToken{}
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, searchToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// AppengineSearchIndex is a SearchIndex backed by the App Engine search API
+type AppengineSearchIndex struct {
+	Name string
+}
+
+// appengineSearchDocument is the document stored in the App Engine search index
+type appengineSearchDocument struct {
+	Title       string
+	Description string
+	Content     string
+	Category    string
+}
+
+func (index AppengineSearchIndex) Put(ctx context.Context, document SearchDocument) error {
+	searchIndex, err := search.Open(index.Name)
+	if err != nil {
+		return err
+	}
+	_, err = searchIndex.Put(ctx, strconv.FormatInt(document.ID, 10), &appengineSearchDocument{
+		Title: document.Title, Description: document.Description, Content: document.Content, Category: document.Category,
+	})
+	return err
+}
+
+func (index AppengineSearchIndex) Delete(ctx context.Context, id int64) error {
+	searchIndex, err := search.Open(index.Name)
+	if err != nil {
+		return err
+	}
+	return searchIndex.Delete(ctx, strconv.FormatInt(id, 10))
+}
+
+func (index AppengineSearchIndex) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	clauses, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	searchIndex, err := search.Open(index.Name)
+	if err != nil {
+		return nil, err
+	}
+	// the query is rebuilt from the parsed clauses, in the App Engine query syntax
+	parts, terms := []string{}, []string{}
+	for _, clause := range clauses {
+		part := `"` + strings.Join(clause.Terms, " ") + `"`
+		if clause.Field != "" {
+			part = clause.Field + ":" + part
+		}
+		parts, terms = append(parts, part), append(terms, clause.Terms...)
+	}
+	expressions := []search.FieldExpression{{Name: "Score", Expr: "_score"}}
+	for _, field := range searchFields {
+		expressions = append(expressions, search.FieldExpression{
+			Name: field + "Highlight",
+			Expr: fmt.Sprintf(`snippet("%s", %s)`, strings.Join(terms, " "), field),
+		})
+	}
+	iterator := searchIndex.Search(ctx, strings.Join(parts, " AND "), &search.SearchOptions{
+		Limit:       limit,
+		Expressions: expressions,
+		Sort: &search.SortOptions{
+			Scorer:      search.MatchScorer,
+			Expressions: []search.SortExpression{{Expr: "_score", Default: 0.0}},
+		},
+	})
+	results := []SearchResult{}
+	for {
+		fields := search.FieldList{}
+		id, err := iterator.Next(&fields)
+		if err == search.Done {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result := SearchResult{Highlights: map[string]string{}}
+		if result.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			switch value := field.Value.(type) {
+			case float64:
+				if field.Name == "Score" {
+					result.Score = value
+				}
+			case search.HTML:
+				if strings.Contains(string(value), "<b>") {
+					result.Highlights[strings.TrimSuffix(field.Name, "Highlight")] = string(value)
+				}
+			}
+		}
+		results = append(results, result)
+	}
+}
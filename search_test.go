@@ -0,0 +1,137 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"golang.org/x/net/context"
+)
+
+func TestMemorySearchIndex_Search(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	index := app.NewMemorySearchIndex()
+	for _, document := range []app.SearchDocument{
+		{ID: 1, Title: "Quick sort", Content: "sort the slice in place", Category: "Go"},
+		{ID: 2, Title: "Merge two maps", Description: "a quick merge of maps", Content: "for k, v := range b", Category: "Go"},
+		{ID: 3, Title: "List comprehension", Content: "[x for x in items]", Category: "Python"},
+	} {
+		expect.Expect(t, index.Put(ctx, document), nil)
+	}
+	results, err := index.Search(ctx, "quick", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 2)
+	expect.Expect(t, results[0].ID, int64(1), "Title matches score higher")
+	expect.Expect(t, results[1].Highlights["Description"], "a <b>quick</b> merge of maps")
+
+	t.Log("Phrase")
+	results, err = index.Search(ctx, `"in place"`, 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+	expect.Expect(t, results[0].ID, int64(1))
+	results, err = index.Search(ctx, `"place in"`, 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0)
+
+	t.Log("Field prefix")
+	results, err = index.Search(ctx, "category:python", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+	expect.Expect(t, results[0].ID, int64(3))
+
+	t.Log("Unknown prefixes are searched as text")
+	expect.Expect(t, index.Put(ctx, app.SearchDocument{ID: 4, Title: "Move", Content: "v := std::move(u)"}), nil)
+	results, err = index.Search(ctx, "std::move", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+	expect.Expect(t, results[0].ID, int64(4))
+	results, err = index.Search(ctx, "author:john", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0)
+
+	t.Log("Invalid queries")
+	_, err = index.Search(ctx, `"unterminated`, 10)
+	expect.Expect(t, err != nil, true)
+	_, err = index.Search(ctx, "  ", 10)
+	expect.Expect(t, err != nil, true)
+}
+
+func TestSearchIndexListener(t *testing.T) {
+	index := app.NewMemorySearchIndex()
+	ctx := app.WithSearchIndex(SetUpMemoryContext(), index)
+	category := &app.Category{Title: "Go"}
+	expect.Expect(t, app.NewCategoryRepository(ctx).Create(category), nil)
+	repository := app.NewSnippetRepository(ctx)
+	snippet := &app.Snippet{Title: "Hello world", CategoryID: category.ID}
+	expect.Expect(t, repository.Create(snippet), nil)
+	results, err := index.Search(ctx, "category:go hello", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+
+	t.Log("Update")
+	snippet.Title = "Goodbye world"
+	expect.Expect(t, repository.Update(snippet), nil)
+	results, err = index.Search(ctx, "hello", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0)
+
+	t.Log("Delete")
+	expect.Expect(t, repository.Delete(snippet), nil)
+	results, err = index.Search(ctx, "goodbye", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0)
+
+	t.Log("Rolled back transactions are not indexed")
+	err = app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		if err := tx.Snippets().Create(&app.Snippet{Title: "Phantom"}); err != nil {
+			return err
+		}
+		results, err := index.Search(ctx, "phantom", 10)
+		expect.Expect(t, err, nil)
+		expect.Expect(t, len(results), 0, "The index changes on commit")
+		return fmt.Errorf("rollback")
+	})
+	expect.Expect(t, err != nil, true)
+	results, err = index.Search(ctx, "phantom", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0)
+	expect.Expect(t, app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		return tx.Snippets().Create(&app.Snippet{Title: "Committed"})
+	}), nil)
+	results, err = index.Search(ctx, "committed", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+}
+
+// retryingRepositoryFactory aborts the first attempt of each transaction, like the datastore after a commit conflict
+type retryingRepositoryFactory struct {
+	*app.MemoryRepositoryFactory
+}
+
+func (factory retryingRepositoryFactory) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	factory.MemoryRepositoryFactory.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := f(ctx); err != nil {
+			return err
+		}
+		return fmt.Errorf("concurrent transaction")
+	})
+	return factory.MemoryRepositoryFactory.RunInTransaction(ctx, f)
+}
+
+func TestSearchIndexListener_RetriedTransaction(t *testing.T) {
+	index := app.NewMemorySearchIndex()
+	ctx := app.WithSearchIndex(app.WithRepositoryFactory(context.Background(), retryingRepositoryFactory{app.NewMemoryRepositoryFactory()}), index)
+	attempts := 0
+	expect.Expect(t, app.RunInTransaction(ctx, func(tx app.Repositories) error {
+		attempts++
+		return tx.Snippets().Create(&app.Snippet{Title: fmt.Sprintf("Attempt%d", attempts)})
+	}), nil)
+	expect.Expect(t, attempts, 2)
+	results, err := index.Search(ctx, "attempt1", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 0, "Aborted attempts are not indexed")
+	results, err = index.Search(ctx, "attempt2", 10)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(results), 1)
+}
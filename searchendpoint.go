@@ -0,0 +1,77 @@
+package smartsnippets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchEndpoint serves full text searches over snippets
+type SearchEndpoint struct{}
+
+func NewSearchEndpoint() *SearchEndpoint {
+	return &SearchEndpoint{}
+}
+
+func (endpoint SearchEndpoint) Connect(routeCollection *tiger.RouteCollection) {
+	routeCollection.Get("/search", endpoint.Search)
+}
+
+// Search lists the snippets matching the q parameter, best matches first
+func (endpoint SearchEndpoint) Search(c tiger.Container) {
+	container, ok := c.(ContextAwareContainer)
+	if !ok {
+		c.Error(fmt.Errorf("Container does not implement ContextAwareContainer"), http.StatusInternalServerError)
+		return
+	}
+	query := container.GetRequest().URL.Query().Get("q")
+	if _, err := parseSearchQuery(query); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	limit := DefaultSearchLimit
+	if value := container.GetRequest().URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > MaxSearchLimit {
+			container.Error(fmt.Errorf("limit should be a number between 1 and %d", MaxSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	index, err := GetSearchIndex(container.GetContext())
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	results, err := index.Search(container.GetContext(), query, limit)
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	repository := NewSnippetRepository(container.GetContext())
+	found := []SearchResult{}
+	for _, result := range results {
+		result.Snippet = &Snippet{}
+		err = repository.FindByID(result.ID, result.Snippet)
+		if err == datastore.ErrNoSuchEntity {
+			// the index may lag behind the repository
+			continue
+		}
+		if err != nil {
+			container.Error(err, http.StatusInternalServerError)
+			return
+		}
+		found = append(found, result)
+	}
+	if err = json.NewEncoder(container.GetResponseWriter()).Encode(found); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
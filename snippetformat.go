@@ -0,0 +1,313 @@
+package smartsnippets
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// SnippetFormat reads and writes snippets in the file format of an editor or a tool
+type SnippetFormat interface {
+	// Decode reads the snippets of r. The languages of a snippet, if known, are given
+	// as the comma separated Title of its Category, the first one found by FindCategoryByLanguage is kept
+	Decode(r io.Reader) ([]*Snippet, error)
+	// Encode writes snippets to w, the Category of each snippet is set
+	Encode(w io.Writer, snippets []*Snippet) error
+	ContentType() string
+}
+
+var (
+	ErrSnippetFormatNotFound = fmt.Errorf("ErrSnippetFormatNotFound")
+	ErrCategoryNotFound      = fmt.Errorf("ErrCategoryNotFound")
+)
+
+// ErrInvalidSnippetFile is returned when a snippet file cannot be decoded
+type ErrInvalidSnippetFile struct {
+	Err error
+}
+
+func (err ErrInvalidSnippetFile) Error() string {
+	return fmt.Sprintf("ErrInvalidSnippetFile : %v", err.Err)
+}
+
+var snippetFormats = map[string]SnippetFormat{}
+
+// RegisterSnippetFormat makes a format available to the import and export endpoints
+func RegisterSnippetFormat(name string, format SnippetFormat) {
+	snippetFormats[name] = format
+}
+
+// GetSnippetFormat returns the format registered as name
+func GetSnippetFormat(name string) (SnippetFormat, error) {
+	if format, ok := snippetFormats[name]; ok {
+		return format, nil
+	}
+	return nil, ErrSnippetFormatNotFound
+}
+
+// SnippetFormats returns the names of the registered formats
+func SnippetFormats() []string {
+	names := []string{}
+	for name := range snippetFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// categoryLanguages maps the titles of the categories seeded by 001-categories
+// to the language identifiers used by editors, when they differ from the lower cased title
+var categoryLanguages = map[string]string{"C++": "cpp", "Objective-C": "objective-c", "LISP": "lisp"}
+
+// languageAliases maps other identifiers of a language, found in editor scopes, to the language identifier
+var languageAliases = map[string]string{
+	"golang": "go", "js": "javascript", "ts": "typescript", "c++": "cpp", "objc": "objective-c",
+	"py": "python", "rb": "ruby", "rs": "rust",
+}
+
+// CategoryLanguage returns the language identifier of a category
+func CategoryLanguage(category *Category) string {
+	if language, ok := categoryLanguages[category.Title]; ok {
+		return language
+	}
+	return strings.ToLower(category.Title)
+}
+
+// FindCategoryByLanguage returns the category of a language identifier or a category title,
+// compared case insensitively, or ErrCategoryNotFound
+func FindCategoryByLanguage(ctx context.Context, language string) (*Category, error) {
+	categories := []*Category{}
+	if err := NewCategoryRepository(ctx).FindAll(&categories); err != nil {
+		return nil, err
+	}
+	if alias, ok := languageAliases[strings.ToLower(language)]; ok {
+		language = alias
+	}
+	for _, category := range categories {
+		if strings.EqualFold(CategoryLanguage(category), language) || strings.EqualFold(category.Title, language) {
+			return category, nil
+		}
+	}
+	return nil, ErrCategoryNotFound
+}
+
+// ImportSnippets creates the snippets read by format from r in a transaction,
+// author is their author, or nil
+func ImportSnippets(ctx context.Context, format SnippetFormat, r io.Reader, author *User) ([]*Snippet, error) {
+	snippets, err := format.Decode(r)
+	if err != nil {
+		return nil, ErrInvalidSnippetFile{err}
+	}
+	categories := map[string]int64{}
+	err = RunInTransaction(ctx, func(tx Repositories) error {
+		for _, snippet := range snippets {
+			if snippet.Category != nil {
+				languages := snippet.Category.Title
+				if _, ok := categories[languages]; !ok {
+					for _, language := range strings.Split(languages, ",") {
+						category, err := FindCategoryByLanguage(tx.GetContext(), strings.TrimSpace(language))
+						if err == ErrCategoryNotFound {
+							continue
+						}
+						if err != nil {
+							return err
+						}
+						categories[languages] = category.ID
+						break
+					}
+				}
+				snippet.CategoryID, snippet.Category = categories[languages], nil
+			}
+			if author != nil {
+				snippet.AuthorID = author.ID
+			}
+			if err := tx.Snippets().Create(snippet); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return snippets, err
+}
+
+// ExportSnippets writes the snippets of a category, or all snippets if category is nil, with format.
+// If ids are given only these snippets are written, in the order of ids
+func ExportSnippets(ctx context.Context, format SnippetFormat, w io.Writer, category *Category, ids ...int64) error {
+	snippets := []*Snippet{}
+	repository := NewSnippetRepository(ctx)
+	if len(ids) > 0 {
+		for _, id := range ids {
+			snippet := &Snippet{}
+			if err := repository.FindByID(id, snippet); err != nil {
+				return err
+			}
+			if category == nil || snippet.CategoryID == category.ID {
+				snippets = append(snippets, snippet)
+			}
+		}
+	} else {
+		query := Query{Order: []string{"Title"}}
+		if category != nil {
+			query.Query = map[string]interface{}{"CategoryID=": category.ID}
+		}
+		if err := repository.FindBy(query, &snippets); err != nil {
+			return err
+		}
+	}
+	categories := []*Category{}
+	if err := NewCategoryRepository(ctx).FindAll(&categories); err != nil {
+		return err
+	}
+	byID := map[int64]*Category{}
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+	for _, snippet := range snippets {
+		snippet.Category = byID[snippet.CategoryID]
+	}
+	return format.Encode(w, snippets)
+}
+
+// TextMateScope returns the scope selector of a snippet used by TextMate and Sublime Text,
+// source.<language> if the snippet has no such scope
+func TextMateScope(snippet *Snippet) string {
+	if strings.Contains(snippet.Scope, ".") {
+		return snippet.Scope
+	}
+	if snippet.Category == nil {
+		return ""
+	}
+	switch language := CategoryLanguage(snippet.Category); language {
+	case "html", "xml":
+		return "text." + language
+	default:
+		return "source." + language
+	}
+}
+
+// textMateScopeLanguages returns the comma separated languages of a scope selector :
+// the second part of each scope, source.go, text.html.basic -> go,html
+func textMateScopeLanguages(scope string) string {
+	languages := []string{}
+	for _, selector := range strings.FieldsFunc(scope, func(r rune) bool { return r == ',' || r == ' ' || r == '|' }) {
+		parts := strings.Split(strings.TrimPrefix(selector, "-"), ".")
+		if len(parts) > 1 && (parts[0] == "source" || parts[0] == "text") {
+			languages = append(languages, parts[1])
+		}
+	}
+	return strings.Join(languages, ",")
+}
+
+// isZip tells whether source starts with the signature of a zip file
+func isZip(source []byte) bool {
+	return bytes.HasPrefix(source, []byte("PK\x03\x04"))
+}
+
+const (
+	// MaxSnippetZipSize is the maximum size of the decompressed files of an imported zip archive
+	MaxSnippetZipSize = 50 << 20
+	// MaxSnippetZipFiles is the maximum number of files of an imported zip archive
+	MaxSnippetZipFiles = 1000
+)
+
+var (
+	ErrSnippetZipTooLarge = fmt.Errorf("ErrSnippetZipTooLarge : the archive decompresses to more than %d bytes or has more than %d files", MaxSnippetZipSize, MaxSnippetZipFiles)
+)
+
+// zipBudgetReader reads a file of a zip archive, it fails once the files read with the same budget
+// decompress to more bytes than the budget
+type zipBudgetReader struct {
+	io.Reader
+	budget *int64
+}
+
+func (reader zipBudgetReader) Read(p []byte) (int, error) {
+	// one more byte than the budget is read to detect files exceeding it
+	if int64(len(p)) > *reader.budget+1 {
+		p = p[:*reader.budget+1]
+	}
+	n, err := reader.Reader.Read(p)
+	if *reader.budget -= int64(n); *reader.budget < 0 {
+		return n, ErrSnippetZipTooLarge
+	}
+	return n, err
+}
+
+// decodeSnippetZip decodes each file of a zip archive whose name ends with one of extensions,
+// decode is given the name of the file without its extension.
+// Archives of more than MaxSnippetZipFiles files or MaxSnippetZipSize decompressed bytes are rejected
+func decodeSnippetZip(source []byte, extensions []string, decode func(name string, r io.Reader) ([]*Snippet, error)) ([]*Snippet, error) {
+	archive, err := zip.NewReader(bytes.NewReader(source), int64(len(source)))
+	if err != nil {
+		return nil, err
+	}
+	if len(archive.File) > MaxSnippetZipFiles {
+		return nil, ErrSnippetZipTooLarge
+	}
+	budget := int64(MaxSnippetZipSize)
+	snippets := []*Snippet{}
+	for _, file := range archive.File {
+		extension := path.Ext(file.Name)
+		if file.FileInfo().IsDir() || !containsString(extensions, extension) {
+			continue
+		}
+		if file.UncompressedSize64 > uint64(budget) {
+			return nil, ErrSnippetZipTooLarge
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		found, err := decode(strings.TrimSuffix(path.Base(file.Name), extension), zipBudgetReader{r, &budget})
+		r.Close()
+		if budget < 0 {
+			return nil, ErrSnippetZipTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s : %v", file.Name, err)
+		}
+		snippets = append(snippets, found...)
+	}
+	return snippets, nil
+}
+
+// encodeSnippetZip writes a zip archive with a file per snippet named after its title
+func encodeSnippetZip(w io.Writer, snippets []*Snippet, extension string, encode func(w io.Writer, snippet *Snippet) error) error {
+	archive := zip.NewWriter(w)
+	names := map[string]bool{}
+	for _, snippet := range snippets {
+		name := snippetFileName(snippet.Title)
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s (%d)", snippetFileName(snippet.Title), i)
+		}
+		names[name] = true
+		file, err := archive.Create(name + extension)
+		if err != nil {
+			return err
+		}
+		if err = encode(file, snippet); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// snippetFileName replaces the characters of title that are not allowed in file names
+func snippetFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" || name == "." || name == ".." {
+		return "snippet"
+	}
+	return name
+}
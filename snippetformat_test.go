@@ -0,0 +1,340 @@
+package smartsnippets_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+const vscodeSnippets = `{
+	// a comment
+	"For loop": {
+		"scope": "golang,go",
+		"prefix": ["for", "fori"],
+		"body": [
+			"for ${1:i} := 0; $1 < ${2:n}; $1++ {",
+			"\t$0",
+			"}",
+		],
+		"description": "A for loop",
+	},
+	/* another comment */
+	"Print": {
+		"prefix": "pr",
+		"body": "fmt.Println(\"${1:hello // world}\")"
+	}
+}`
+
+func TestVSCodeFormat_Decode(t *testing.T) {
+	snippets, err := app.VSCodeFormat{}.Decode(strings.NewReader(vscodeSnippets))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 2)
+	expect.Expect(t, snippets[0].Title, "For loop")
+	expect.Expect(t, snippets[0].Prefix, "for,fori")
+	expect.Expect(t, snippets[0].Description, "A for loop")
+	expect.Expect(t, snippets[0].Content, "for ${1:i} := 0; $1 < ${2:n}; $1++ {\n\t$0\n}")
+	expect.Expect(t, snippets[0].Category.Title, "golang,go")
+	expect.Expect(t, snippets[1].Content, "fmt.Println(\"${1:hello // world}\")")
+	expect.Expect(t, snippets[1].Category == nil, true)
+
+	_, err = app.VSCodeFormat{}.Decode(strings.NewReader(`{"Broken": {"body": 1}}`))
+	expect.Expect(t, err != nil, true)
+}
+
+func TestVSCodeFormat_DecodeLargeFile(t *testing.T) {
+	source := new(bytes.Buffer)
+	source.WriteString("{\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(source, "\t\"Snippet %d\": {\"prefix\": [\"s%d\", \"t%d\"], \"body\": [\"a\", \"b\", ], /* comment */ },\n", i, i, i)
+	}
+	source.WriteString("}")
+	done := make(chan struct{})
+	var snippets []*app.Snippet
+	var err error
+	go func() {
+		snippets, err = app.VSCodeFormat{}.Decode(source)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Decoding takes too long")
+	}
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 500)
+	expect.Expect(t, snippets[0].Content, "a\nb")
+}
+
+func TestImportExportSnippets(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	format, err := app.GetSnippetFormat("vscode")
+	expect.Expect(t, err, nil)
+	snippets, err := app.ImportSnippets(ctx, format, strings.NewReader(vscodeSnippets), nil)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 2)
+	golang, err := app.FindCategoryByLanguage(ctx, "go")
+	expect.Expect(t, err, nil)
+	expect.Expect(t, snippets[0].CategoryID, golang.ID)
+	expect.Expect(t, snippets[1].CategoryID, int64(0))
+
+	t.Log("Export round-trips the placeholders")
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.ExportSnippets(ctx, format, buffer, golang), nil)
+	exported, err := format.Decode(buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(exported), 1)
+	expect.Expect(t, exported[0].Title, snippets[0].Title)
+	expect.Expect(t, exported[0].Prefix, snippets[0].Prefix)
+	expect.Expect(t, exported[0].Content, snippets[0].Content)
+	expect.Expect(t, exported[0].Scope, "golang,go", "the imported scope is exported")
+
+	_, err = app.FindCategoryByLanguage(ctx, "cobol")
+	expect.Expect(t, err, app.ErrCategoryNotFound)
+}
+
+func TestSublimeFormat(t *testing.T) {
+	snippets, err := app.SublimeFormat{}.Decode(strings.NewReader(`<snippet>
+	<content><![CDATA[if ${1:err} != nil {
+	return $1
+}]]></content>
+	<tabTrigger>iferr</tabTrigger>
+	<scope>source.go</scope>
+	<description>Check an error</description>
+</snippet>`))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Title, "Check an error")
+	expect.Expect(t, snippets[0].Prefix, "iferr")
+	expect.Expect(t, snippets[0].Scope, "source.go")
+	expect.Expect(t, snippets[0].Category.Title, "go")
+	expect.Expect(t, snippets[0].Content, "if ${1:err} != nil {\n\treturn $1\n}")
+
+	t.Log("Export as a zip archive")
+	snippets[0].Content += " // ]]>"
+	snippets = append(snippets, &app.Snippet{Title: snippets[0].Title, Content: "<b>$0</b>", Category: &app.Category{Title: "HTML"}})
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.SublimeFormat{}.Encode(buffer, snippets), nil)
+	exported, err := app.SublimeFormat{}.Decode(buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(exported), 2)
+	expect.Expect(t, exported[0].Title, "Check an error")
+	expect.Expect(t, exported[0].Content, snippets[0].Content)
+	expect.Expect(t, exported[0].Scope, "source.go")
+	expect.Expect(t, exported[1].Title, "Check an error (2)")
+	expect.Expect(t, exported[1].Scope, "text.html")
+	expect.Expect(t, exported[1].Content, "<b>$0</b>")
+}
+
+func TestTextMateFormat(t *testing.T) {
+	snippets, err := app.TextMateFormat{}.Decode(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>content</key>
+	<string>def ${1:method}
+	$0
+end</string>
+	<key>keyEquivalent</key>
+	<dict><key>name</key><string>ignored</string></dict>
+	<key>name</key>
+	<string>Method</string>
+	<key>scope</key>
+	<string>source.ruby</string>
+	<key>tabTrigger</key>
+	<string>def</string>
+</dict>
+</plist>`))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 1)
+	expect.Expect(t, snippets[0].Title, "Method")
+	expect.Expect(t, snippets[0].Prefix, "def")
+	expect.Expect(t, snippets[0].Scope, "source.ruby")
+	expect.Expect(t, snippets[0].Category.Title, "ruby")
+	expect.Expect(t, snippets[0].Content, "def ${1:method}\n\t$0\nend")
+
+	t.Log("Export as a zip archive")
+	snippets[0].Content += " # a < b && b > c"
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.TextMateFormat{}.Encode(buffer, snippets), nil)
+	exported, err := app.TextMateFormat{}.Decode(buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(exported), 1)
+	expect.Expect(t, exported[0].Title, "Method")
+	expect.Expect(t, exported[0].Prefix, "def")
+	expect.Expect(t, exported[0].Scope, "source.ruby")
+	expect.Expect(t, exported[0].Content, snippets[0].Content)
+}
+
+func TestImportSnippets_Scope(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	expect.Expect(t, app.ExecuteMigrations(ctx, app.GetMigrations()), nil)
+	snippets, err := app.ImportSnippets(ctx, app.SublimeFormat{}, strings.NewReader(
+		`<snippet><content>console.log($1)</content><tabTrigger>log</tabTrigger><scope>source.js</scope></snippet>`,
+	), nil)
+	expect.Expect(t, err, nil)
+	stored := &app.Snippet{}
+	expect.Expect(t, app.NewSnippetRepository(ctx).FindByID(snippets[0].ID, stored), nil)
+	expect.Expect(t, stored.Prefix, "log")
+	expect.Expect(t, stored.Scope, "source.js")
+	category := &app.Category{}
+	expect.Expect(t, app.NewCategoryRepository(ctx).FindByID(stored.CategoryID, category), nil)
+	expect.Expect(t, category.Title, "Javascript")
+}
+
+const markdownDocument = "# Go tips\n\n" +
+	"## Reverse a string\n\n" +
+	"Reverses the runes\nof a string.\n\n" +
+	"```go\nfunc reverse(s string) string {\n\t// ...\n}\n```\n\n" +
+	"Some text after the block.\n\n" +
+	"## Query\n\n" +
+	"  ~~~~sql\n  SELECT * FROM snippets\n  ~~~\n  WHERE ID = ${1:id}\n  ~~~~\n\n" +
+	"```\nno language\n"
+
+func TestMarkdownFormat_Decode(t *testing.T) {
+	snippets, err := app.MarkdownFormat{}.Decode(strings.NewReader(markdownDocument))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 3)
+	expect.Expect(t, snippets[0].Title, "Reverse a string")
+	expect.Expect(t, snippets[0].Description, "Reverses the runes\nof a string.")
+	expect.Expect(t, snippets[0].Category.Title, "go")
+	expect.Expect(t, snippets[0].Content, "func reverse(s string) string {\n\t// ...\n}")
+	expect.Expect(t, snippets[1].Title, "Query")
+	expect.Expect(t, snippets[1].Description, "")
+	expect.Expect(t, snippets[1].Category.Title, "sql")
+	expect.Expect(t, snippets[1].Content, "SELECT * FROM snippets\n~~~\nWHERE ID = ${1:id}")
+	expect.Expect(t, snippets[2].Title, "Query")
+	expect.Expect(t, snippets[2].Description, "")
+	expect.Expect(t, snippets[2].Category == nil, true)
+	expect.Expect(t, snippets[2].Content, "no language")
+}
+
+func TestMarkdownFormat_Encode(t *testing.T) {
+	category := &app.Category{ID: 1, Title: "Go"}
+	snippets := []*app.Snippet{
+		{Title: "Reverse a string", Description: "Reverses the runes of a string.", Content: "func reverse(s string) string", Category: category},
+		{Title: "Fences", Content: "```go\n```", Category: category},
+	}
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.MarkdownFormat{}.Encode(buffer, snippets), nil)
+	expect.Expect(t, buffer.String(), "# Go cheat sheet\n\n"+
+		"## Reverse a string\n\nReverses the runes of a string.\n\n```go\nfunc reverse(s string) string\n```\n\n"+
+		"## Fences\n\n````go\n```go\n```\n````\n")
+	decoded, err := app.MarkdownFormat{}.Decode(buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(decoded), 2)
+	expect.Expect(t, decoded[0].Description, snippets[0].Description)
+	expect.Expect(t, decoded[1].Content, snippets[1].Content)
+}
+
+func TestMarkdownFormat_DecodeZip(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	archive := zip.NewWriter(buffer)
+	for name, document := range map[string]string{
+		"docs/README.md":   "Intro\n\n```sh\nmake\n```\n",
+		"docs/notes.txt":   "```sh\nignored\n```\n",
+		"docs/go.markdown": markdownDocument,
+	} {
+		file, err := archive.Create(name)
+		expect.Expect(t, err, nil)
+		_, err = file.Write([]byte(document))
+		expect.Expect(t, err, nil)
+	}
+	expect.Expect(t, archive.Close(), nil)
+	snippets, err := app.MarkdownFormat{}.Decode(buffer)
+	expect.Expect(t, err, nil)
+	titles := []string{}
+	for _, snippet := range snippets {
+		titles = append(titles, snippet.Title)
+	}
+	sort.Strings(titles)
+	expect.Expect(t, titles, []string{"Query", "Query", "README", "Reverse a string"})
+}
+
+func TestMarkdownFormat_DecodeZipBomb(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	archive := zip.NewWriter(buffer)
+	line := []byte(strings.Repeat("x", 1023) + "\n")
+	for _, name := range []string{"a.md", "b.md"} {
+		file, err := archive.Create(name)
+		expect.Expect(t, err, nil)
+		for i := 0; i < (app.MaxSnippetZipSize/2+1<<20)/len(line); i++ {
+			file.Write(line)
+		}
+	}
+	expect.Expect(t, archive.Close(), nil)
+	_, err := app.MarkdownFormat{}.Decode(buffer)
+	expect.Expect(t, err, app.ErrSnippetZipTooLarge, "The files decompress to more than MaxSnippetZipSize")
+
+	buffer = new(bytes.Buffer)
+	archive = zip.NewWriter(buffer)
+	for i := 0; i <= app.MaxSnippetZipFiles; i++ {
+		_, err := archive.Create(fmt.Sprintf("%d.md", i))
+		expect.Expect(t, err, nil)
+	}
+	expect.Expect(t, archive.Close(), nil)
+	_, err = app.MarkdownFormat{}.Decode(buffer)
+	expect.Expect(t, err, app.ErrSnippetZipTooLarge)
+}
+
+const jupyterNotebook = `{
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# Load a CSV file\n", "\n", "With **pandas**."]},
+  {"cell_type": "code", "execution_count": 3, "metadata": {"collapsed": false, "tags": [], "colab": {"base_uri": "https://localhost:8080/"}}, "outputs": [{"output_type": "stream", "name": "stdout", "text": ["ok"]}],
+   "source": ["import pandas as pd\n", "df = pd.read_csv(\"data.csv\")"]},
+  {"cell_type": "code", "execution_count": null, "metadata": {"scrolled": true}, "outputs": [], "source": "df.head()"},
+  {"cell_type": "raw", "metadata": {}, "source": "ignored"}
+ ],
+ "metadata": {"kernelspec": {"display_name": "Python 3", "language": "python", "name": "python3"},
+  "language_info": {"codemirror_mode": {"name": "ipython", "version": 3}, "name": "python"}},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}`
+
+func TestJupyterFormat(t *testing.T) {
+	snippets, err := app.JupyterFormat{}.Decode(strings.NewReader(jupyterNotebook))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 2)
+	expect.Expect(t, snippets[0].Title, "Load a CSV file")
+	expect.Expect(t, snippets[0].Description, "With **pandas**.")
+	expect.Expect(t, snippets[0].Content, "import pandas as pd\ndf = pd.read_csv(\"data.csv\")")
+	expect.Expect(t, snippets[0].Category.Title, "python")
+	expect.Expect(t, snippets[1].Title, "Cell 2")
+	expect.Expect(t, snippets[1].Content, "df.head()")
+
+	t.Log("Export as a nbformat 4 notebook")
+	for _, snippet := range snippets {
+		snippet.Category = &app.Category{Title: "Python"}
+	}
+	buffer := new(bytes.Buffer)
+	expect.Expect(t, app.JupyterFormat{}.Encode(buffer, snippets), nil)
+	notebook := map[string]interface{}{}
+	expect.Expect(t, json.Unmarshal(buffer.Bytes(), &notebook), nil)
+	expect.Expect(t, notebook["nbformat"], float64(4))
+	expect.Expect(t, notebook["metadata"].(map[string]interface{})["kernelspec"],
+		map[string]interface{}{"display_name": "Python", "language": "python", "name": "python3"})
+	cells := notebook["cells"].([]interface{})
+	expect.Expect(t, len(cells), 4)
+	expect.Expect(t, cells[0], map[string]interface{}{
+		"cell_type": "markdown", "metadata": map[string]interface{}{},
+		"source": []interface{}{"## Load a CSV file\n", "\n", "With **pandas**."},
+	})
+	expect.Expect(t, cells[1], map[string]interface{}{
+		"cell_type": "code", "execution_count": nil, "metadata": map[string]interface{}{}, "outputs": []interface{}{},
+		"source": []interface{}{"import pandas as pd\n", "df = pd.read_csv(\"data.csv\")"},
+	})
+	exported, err := app.JupyterFormat{}.Decode(buffer)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(exported), 2)
+	expect.Expect(t, exported[1].Title, "Cell 2")
+	expect.Expect(t, exported[0].Description, snippets[0].Description)
+	expect.Expect(t, exported[0].Content, snippets[0].Content)
+}
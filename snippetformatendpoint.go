@@ -0,0 +1,118 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	tiger "github.com/Mparaiso/tiger-go-framework"
+	"google.golang.org/appengine/datastore"
+)
+
+// MaxSnippetImportSize is the maximum size of an imported snippet file
+const MaxSnippetImportSize = 10 << 20
+
+// SnippetFormatEndpoint imports and exports snippets in the formats registered with RegisterSnippetFormat
+type SnippetFormatEndpoint struct{}
+
+func NewSnippetFormatEndpoint() *SnippetFormatEndpoint {
+	return &SnippetFormatEndpoint{}
+}
+
+func (endpoint SnippetFormatEndpoint) Connect(routeCollection *tiger.RouteCollection) {
+	routeCollection.
+		Post("/import", endpoint.Import).
+		Get("/export", endpoint.Export)
+}
+
+// Import creates the snippets of the request body, written in the format parameter,
+// the current user is their author
+func (endpoint SnippetFormatEndpoint) Import(c tiger.Container) {
+	if !Authorize(c, RoleUser) {
+		return
+	}
+	container, format, ok := endpoint.getFormat(c)
+	if !ok {
+		return
+	}
+	body := http.MaxBytesReader(container.GetResponseWriter(), container.GetRequest().Body, MaxSnippetImportSize)
+	defer body.Close()
+	snippets, err := ImportSnippets(container.GetContext(), format, body, container.GetCurrentUser())
+	if err != nil {
+		if _, ok := err.(ErrInvalidSnippetFile); ok {
+			container.Error(err, http.StatusBadRequest)
+		} else {
+			container.Error(err, http.StatusInternalServerError)
+		}
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(container.GetResponseWriter()).Encode(snippets); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Export writes the snippets of the category parameter, or all snippets, in the format parameter.
+// The ids parameter, a comma separated list of snippet ids, selects the exported snippets
+func (endpoint SnippetFormatEndpoint) Export(c tiger.Container) {
+	container, format, ok := endpoint.getFormat(c)
+	if !ok {
+		return
+	}
+	ids := []int64{}
+	if value := container.GetRequest().URL.Query().Get("ids"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				container.Error(fmt.Errorf("ids should be a comma separated list of snippet ids"), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+	var category *Category
+	if title := container.GetRequest().URL.Query().Get("category"); title != "" {
+		var err error
+		if category, err = FindCategoryByLanguage(container.GetContext(), title); err == ErrCategoryNotFound {
+			container.Error(fmt.Errorf("Category %q not found", title), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			container.Error(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	// the snippets are encoded before anything is written so a missing snippet can be reported
+	buffer := new(bytes.Buffer)
+	if err := ExportSnippets(container.GetContext(), format, buffer, category, ids...); err == datastore.ErrNoSuchEntity {
+		container.Error(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetResponseWriter().Header().Set("Content-Type", format.ContentType())
+	if format.ContentType() == "application/zip" {
+		container.GetResponseWriter().Header().Set("Content-Disposition", `attachment; filename="snippets.zip"`)
+	}
+	if _, err := buffer.WriteTo(container.GetResponseWriter()); err != nil {
+		container.MustGetLogger().Log(Error, err)
+	}
+}
+
+func (SnippetFormatEndpoint) getFormat(c tiger.Container) (ContextAwareContainer, SnippetFormat, bool) {
+	container, ok := c.(ContextAwareContainer)
+	if !ok {
+		c.Error(fmt.Errorf("Container does not implement ContextAwareContainer"), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	name := container.GetRequest().URL.Query().Get("format")
+	format, err := GetSnippetFormat(name)
+	if err != nil {
+		container.Error(fmt.Errorf("Unknown format %q, expected one of %v", name, SnippetFormats()), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return container, format, true
+}
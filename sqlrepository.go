@@ -0,0 +1,604 @@
+package smartsnippets
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// SequenceTable is the table holding the last id allocated for each kind
+const SequenceTable = "Sequences"
+
+// SQLDialect adapts the statements of SQLRepository to a database
+type SQLDialect interface {
+	// Placeholder returns the bind parameter at position, starting at 1
+	Placeholder(position int) string
+	// ColumnType returns the column type of a struct field type
+	ColumnType(fieldType reflect.Type) (string, error)
+	// LimitOffset returns the LIMIT/OFFSET clause of a query
+	LimitOffset(limit, offset int) string
+	// IsDuplicateKey returns true if err is the error of an insert of an existing primary key
+	IsDuplicateKey(err error) bool
+}
+
+// ErrDuplicateID is returned by SQLRepository.CreateWithID when an entity with the same ID exists,
+// for instance if a concurrent transaction created it
+var ErrDuplicateID = fmt.Errorf("ErrDuplicateID")
+
+// SQLiteDialect is the SQLDialect of SQLite 3.35+
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Placeholder(position int) string { return "?" }
+
+func (SQLiteDialect) ColumnType(fieldType reflect.Type) (string, error) {
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		return "DATETIME", nil
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "INTEGER", nil
+	case reflect.Float32, reflect.Float64:
+		return "REAL", nil
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.String:
+		return "TEXT", nil
+	}
+	return "", fmt.Errorf("SQLiteDialect: unsupported column type %s", fieldType)
+}
+
+func (SQLiteDialect) LimitOffset(limit, offset int) string {
+	if limit <= 0 {
+		limit = -1
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (SQLiteDialect) IsDuplicateKey(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed") || strings.Contains(err.Error(), "PRIMARY KEY must be unique")
+}
+
+// PostgresDialect is the SQLDialect of PostgreSQL 9.5+
+type PostgresDialect struct{}
+
+func (PostgresDialect) Placeholder(position int) string { return fmt.Sprintf("$%d", position) }
+
+func (PostgresDialect) ColumnType(fieldType reflect.Type) (string, error) {
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		return "TIMESTAMP WITH TIME ZONE", nil
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "BIGINT", nil
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE PRECISION", nil
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.String:
+		return "TEXT", nil
+	}
+	return "", fmt.Errorf("PostgresDialect: unsupported column type %s", fieldType)
+}
+
+func (PostgresDialect) LimitOffset(limit, offset int) string {
+	if limit <= 0 {
+		return fmt.Sprintf("OFFSET %d", offset)
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+// IsDuplicateKey matches the unique_violation error, SQLSTATE 23505
+func (PostgresDialect) IsDuplicateKey(err error) bool {
+	return strings.Contains(err.Error(), "duplicate key value violates unique constraint") || strings.Contains(err.Error(), "23505")
+}
+
+// SQLExecutor executes statements, it is implemented by *sql.DB and *sql.Tx
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var sqlTables = map[string]reflect.Type{}
+
+func init() {
+	RegisterSQLTable(Kind.Users, User{})
+	RegisterSQLTable(Kind.Migrations, Migration{})
+	RegisterSQLTable(Kind.Snippets, Snippet{})
+	RegisterSQLTable(Kind.SnippetRevisions, SnippetRevision{})
+	RegisterSQLTable(Kind.Categories, Category{})
+	RegisterSQLTable(Kind.Roles, Role{})
+	RegisterSQLTable(Kind.UserRoles, UserRole{})
+	RegisterSQLTable(Kind.Tokens, Token{})
+	RegisterSQLTable(Kind.UniqueReservations, UniqueReservation{})
+	RegisterSQLTable(Kind.Locks, Lock{})
+	RegisterSQLTable(Kind.AuditEntries, AuditEntry{})
+	RegisterSQLTable(Kind.PasswordResetTokens, PasswordResetToken{})
+}
+
+// RegisterSQLTable registers the struct stored in the table of a kind
+func RegisterSQLTable(kind string, prototype interface{}) {
+	sqlTables[kind] = reflect.Indirect(reflect.ValueOf(prototype)).Type()
+}
+
+// SQLTables returns the struct stored in the table of each registered kind
+func SQLTables() map[string]reflect.Type {
+	tables := make(map[string]reflect.Type, len(sqlTables))
+	for kind, prototype := range sqlTables {
+		tables[kind] = prototype
+	}
+	return tables
+}
+
+// CreateTableStatement returns the CREATE TABLE statement of a kind stored as prototype
+func CreateTableStatement(dialect SQLDialect, kind string, prototype reflect.Type) (string, error) {
+	definitions := []string{}
+	for _, column := range sqlColumns(prototype) {
+		columnType, err := dialect.ColumnType(prototype.Field(column.Index).Type)
+		if err != nil {
+			return "", err
+		}
+		definition := quoteIdentifier(column.Name) + " " + columnType + " NOT NULL"
+		if column.Name == "ID" {
+			definition += " PRIMARY KEY"
+		}
+		definitions = append(definitions, definition)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdentifier(kind), strings.Join(definitions, ", ")), nil
+}
+
+// CreateSQLTables creates the tables of every kind and the sequence table if they do not exist
+func CreateSQLTables(ctx context.Context, executor SQLExecutor, dialect SQLDialect) error {
+	statements := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL PRIMARY KEY, %s BIGINT NOT NULL)",
+		quoteIdentifier(SequenceTable), quoteIdentifier("Kind"), quoteIdentifier("Value"))}
+	tables := SQLTables()
+	kinds := make([]string, 0, len(tables))
+	for kind := range tables {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		statement, err := CreateTableStatement(dialect, kind, tables[kind])
+		if err != nil {
+			return err
+		}
+		statements = append(statements, statement)
+	}
+	for _, statement := range statements {
+		if _, err := executor.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("Error executing %q : %v", statement, err)
+		}
+	}
+	for _, kind := range kinds {
+		statements, err := AddColumnStatements(ctx, executor, dialect, kind, tables[kind])
+		if err != nil {
+			return err
+		}
+		for _, statement := range statements {
+			if _, err := executor.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("Error executing %q : %v", statement, err)
+			}
+		}
+	}
+	return nil
+}
+
+// AddColumnStatements returns the ALTER TABLE statements adding the fields of prototype
+// missing from the existing table of kind, existing rows get the zero value of the field
+func AddColumnStatements(ctx context.Context, executor SQLExecutor, dialect SQLDialect, kind string, prototype reflect.Type) ([]string, error) {
+	rows, err := executor.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0", quoteIdentifier(kind)))
+	if err != nil {
+		return nil, err
+	}
+	existing, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	statements := []string{}
+	for _, column := range sqlColumns(prototype) {
+		if containsString(existing, column.Name) {
+			continue
+		}
+		fieldType := prototype.Field(column.Index).Type
+		columnType, err := dialect.ColumnType(fieldType)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NOT NULL DEFAULT %s",
+			quoteIdentifier(kind), quoteIdentifier(column.Name), columnType, sqlZeroLiteral(fieldType)))
+	}
+	return statements, nil
+}
+
+// sqlZeroLiteral returns the SQL literal of the zero value of fieldType
+func sqlZeroLiteral(fieldType reflect.Type) string {
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		return "'0001-01-01 00:00:00+00:00'"
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return "FALSE"
+	case reflect.String:
+		return "''"
+	}
+	return "0"
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SQLRepositoryFactory creates SQLRepository instances sharing the same database
+type SQLRepositoryFactory struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+}
+
+// NewSQLRepositoryFactory creates a new SQLRepositoryFactory
+func NewSQLRepositoryFactory(db *sql.DB, dialect SQLDialect) *SQLRepositoryFactory {
+	return &SQLRepositoryFactory{DB: db, Dialect: dialect}
+}
+
+// Create creates a SQLRepository, bound to the transaction of ctx if any
+func (factory *SQLRepositoryFactory) Create(ctx context.Context, kind string, listeners ...signal.Listener) Repository {
+	if tx, ok := ctx.Value(TransactionKey).(*sql.Tx); ok {
+		return NewSQLRepository(ctx, tx, factory.Dialect, kind, listeners...)
+	}
+	return NewSQLRepository(ctx, factory.DB, factory.Dialect, kind, listeners...)
+}
+
+// RunInTransaction runs f in a database transaction, committed if f succeeds
+func (factory *SQLRepositoryFactory) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	tx, err := factory.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(context.WithValue(ctx, TransactionKey, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQLRepository is an implementation of Repository on top of database/sql,
+// each kind is stored in a table named after the kind
+type SQLRepository struct {
+	Context  context.Context
+	Executor SQLExecutor
+	Dialect  SQLDialect
+	Kind     string
+	Signal   signal.Signal
+}
+
+// NewSQLRepository creates a new SQLRepository
+func NewSQLRepository(ctx context.Context, executor SQLExecutor, dialect SQLDialect, kind string, listeners ...signal.Listener) *SQLRepository {
+	return &SQLRepository{Context: ctx, Executor: executor, Dialect: dialect, Kind: kind, Signal: NewRepositorySignal(listeners...)}
+}
+
+// Create an entity
+func (repository SQLRepository) Create(entity Entity) error {
+	id, err := repository.allocateID()
+	if err != nil {
+		return err
+	}
+	entity.SetID(id)
+	return repository.CreateWithID(entity)
+}
+
+// CreateWithID creates an entity with the ID it already has
+func (repository SQLRepository) CreateWithID(entity Entity) error {
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityCreatedEvent{entity}); err != nil {
+			return err
+		}
+	}
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	columns := sqlColumns(value.Type())
+	names, placeholders, args := make([]string, len(columns)), make([]string, len(columns)), make([]interface{}, len(columns))
+	for i, column := range columns {
+		names[i] = quoteIdentifier(column.Name)
+		placeholders[i] = repository.Dialect.Placeholder(i + 1)
+		args[i] = value.Field(column.Index).Interface()
+	}
+	_, err := repository.Executor.ExecContext(repository.Context, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdentifier(repository.Kind), strings.Join(names, ", "), strings.Join(placeholders, ", ")), args...)
+	if err != nil && repository.Dialect.IsDuplicateKey(err) {
+		return ErrDuplicateID
+	}
+	if err != nil || repository.Signal == nil {
+		return err
+	}
+	return repository.Signal.Dispatch(AfterEntityCreatedEvent{entity})
+}
+
+// Update an entity
+func (repository SQLRepository) Update(entity Entity) error {
+	old := reflect.New(reflect.Indirect(reflect.ValueOf(entity)).Type()).Interface()
+	if err := repository.FindByID(entity.GetID(), old.(Entity)); err != nil {
+		return err
+	}
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityUpdatedEvent{old.(Entity), entity}); err != nil {
+			return err
+		}
+	}
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	assignments, args := []string{}, []interface{}{}
+	for _, column := range sqlColumns(value.Type()) {
+		if column.Name == "ID" {
+			continue
+		}
+		args = append(args, value.Field(column.Index).Interface())
+		assignments = append(assignments, quoteIdentifier(column.Name)+" = "+repository.Dialect.Placeholder(len(args)))
+	}
+	args = append(args, entity.GetID())
+	where := quoteIdentifier("ID") + " = " + repository.Dialect.Placeholder(len(args))
+	versioned, isVersioned := old.(VersionedEntity)
+	if isVersioned {
+		// the version check is repeated in the statement so concurrent updates cannot both succeed
+		args = append(args, versioned.GetVersion())
+		where += " AND " + quoteIdentifier("Version") + " = " + repository.Dialect.Placeholder(len(args))
+	}
+	result, err := repository.Executor.ExecContext(repository.Context, fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		quoteIdentifier(repository.Kind), strings.Join(assignments, ", "), where), args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 && isVersioned {
+		// the entity was modified concurrently, its current version is unknown
+		return VersionMismatchError{Received: versioned.GetVersion()}
+	} else if affected == 0 {
+		// the entity was deleted concurrently
+		return datastore.ErrNoSuchEntity
+	}
+	if repository.Signal != nil {
+		return repository.Signal.Dispatch(AfterEntityUpdatedEvent{entity})
+	}
+	return nil
+}
+
+// Delete an entity
+func (repository SQLRepository) Delete(entity Entity) error {
+	if repository.Signal != nil {
+		if err := repository.Signal.Dispatch(BeforeEntityDeletedEvent{entity}); err != nil {
+			return err
+		}
+	}
+	_, err := repository.Executor.ExecContext(repository.Context, fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+		quoteIdentifier(repository.Kind), quoteIdentifier("ID"), repository.Dialect.Placeholder(1)), entity.GetID())
+	if err != nil || repository.Signal == nil {
+		return err
+	}
+	return repository.Signal.Dispatch(AfterEntityDeletedEvent{entity})
+}
+
+// FindByID gets an entity by id
+func (repository SQLRepository) FindByID(id int64, entity Entity) error {
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	columns := sqlColumns(value.Type())
+	row := repository.Executor.QueryRowContext(repository.Context, fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+		sqlColumnList(columns), quoteIdentifier(repository.Kind), quoteIdentifier("ID"), repository.Dialect.Placeholder(1)), id)
+	err := row.Scan(sqlScanTargets(value, columns)...)
+	if err == sql.ErrNoRows {
+		return datastore.ErrNoSuchEntity
+	}
+	return err
+}
+
+// FindAll returns all entities
+func (repository SQLRepository) FindAll(entities interface{}) error {
+	return repository.FindBy(Query{}, entities)
+}
+
+func (repository SQLRepository) FindBy(query Query, result interface{}) error {
+	_, err := repository.FindPage(query, result)
+	return err
+}
+
+// FindPage appends at most query.Limit entities to result
+// and returns the cursor of the next page, or an empty string on the last page
+func (repository SQLRepository) FindPage(query Query, result interface{}) (string, error) {
+	start, err := decodeOffsetCursor(query.Cursor)
+	if err != nil {
+		return "", err
+	}
+	query.Offset += start
+	slice := reflect.ValueOf(result)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("SQLRepository: result should be a pointer to a slice, got %T", result)
+	}
+	slice = slice.Elem()
+	elementType := slice.Type().Elem()
+	structType := elementType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	columns := sqlColumns(structType)
+	if len(query.Fields) > 0 {
+		projection := []sqlColumn{}
+		for _, field := range query.Fields {
+			column, ok := findSQLColumn(columns, field)
+			if !ok {
+				return "", fmt.Errorf("SQLRepository: unknown property %q in projection", field)
+			}
+			projection = append(projection, column)
+		}
+		columns = projection
+	}
+	where, args, err := repository.where(structType, query)
+	if err != nil {
+		return "", err
+	}
+	orderBy, err := repository.orderBy(structType, query)
+	if err != nil {
+		return "", err
+	}
+	limit, next := query.Limit, ""
+	if limit > 0 {
+		// fetch one more row to know if there is a next page
+		limit++
+	}
+	rows, err := repository.Executor.QueryContext(repository.Context, fmt.Sprintf("SELECT %s FROM %s%s%s %s",
+		sqlColumnList(columns), quoteIdentifier(repository.Kind), where, orderBy,
+		repository.Dialect.LimitOffset(limit, query.Offset)), args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for count := 0; rows.Next(); count++ {
+		if query.Limit > 0 && count == query.Limit {
+			next = encodeOffsetCursor(query.Offset + query.Limit)
+			break
+		}
+		element := reflect.New(structType)
+		if err = rows.Scan(sqlScanTargets(element.Elem(), columns)...); err != nil {
+			return "", err
+		}
+		if elementType.Kind() != reflect.Ptr {
+			element = element.Elem()
+		}
+		slice.Set(reflect.Append(slice, element))
+	}
+	return next, rows.Err()
+}
+
+func (repository SQLRepository) Count(query Query) (int, error) {
+	structType, ok := sqlTables[repository.Kind]
+	if !ok {
+		return 0, fmt.Errorf("SQLRepository: no table registered for kind %s", repository.Kind)
+	}
+	start, err := decodeOffsetCursor(query.Cursor)
+	if err != nil {
+		return 0, err
+	}
+	query.Offset += start
+	where, args, err := repository.where(structType, query)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = repository.Executor.QueryRowContext(repository.Context, fmt.Sprintf("SELECT COUNT(*) FROM (SELECT %s FROM %s%s %s) AS counted",
+		quoteIdentifier("ID"), quoteIdentifier(repository.Kind), where,
+		repository.Dialect.LimitOffset(query.Limit, query.Offset)), args...).Scan(&count)
+	return count, err
+}
+
+// allocateID increments the sequence of the repository kind
+func (repository SQLRepository) allocateID() (int64, error) {
+	var id int64
+	err := repository.Executor.QueryRowContext(repository.Context, fmt.Sprintf(
+		"INSERT INTO %[1]s (%[2]s, %[3]s) VALUES (%[4]s, 1) ON CONFLICT (%[2]s) DO UPDATE SET %[3]s = %[1]s.%[3]s + 1 RETURNING %[3]s",
+		quoteIdentifier(SequenceTable), quoteIdentifier("Kind"), quoteIdentifier("Value"), repository.Dialect.Placeholder(1)),
+		repository.Kind).Scan(&id)
+	return id, err
+}
+
+// where translates the filters of query into a WHERE clause
+func (repository SQLRepository) where(structType reflect.Type, query Query) (string, []interface{}, error) {
+	keys := make([]string, 0, len(query.Query))
+	for key := range query.Query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	conditions, args := []string{}, []interface{}{}
+	columns := sqlColumns(structType)
+	for _, key := range keys {
+		filter, err := parseQueryFilter(key, query.Query[key])
+		if err != nil {
+			return "", nil, err
+		}
+		if _, ok := findSQLColumn(columns, filter.Property); !ok {
+			// like the datastore, entities without the property never match
+			conditions = append(conditions, "1 = 0")
+			continue
+		}
+		operator := filter.Operator
+		if operator == "!=" {
+			operator = "<>"
+		}
+		args = append(args, filter.Value)
+		conditions = append(conditions, quoteIdentifier(filter.Property)+" "+operator+" "+repository.Dialect.Placeholder(len(args)))
+	}
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// orderBy translates the order of query into an ORDER BY clause, ties are ordered by ID
+func (repository SQLRepository) orderBy(structType reflect.Type, query Query) (string, error) {
+	columns := sqlColumns(structType)
+	orders := []string{}
+	for _, order := range query.Order {
+		name, direction := strings.TrimPrefix(order, "-"), "ASC"
+		if strings.HasPrefix(order, "-") {
+			direction = "DESC"
+		}
+		if _, ok := findSQLColumn(columns, name); !ok {
+			return "", fmt.Errorf("SQLRepository: unknown property %q in order", name)
+		}
+		orders = append(orders, quoteIdentifier(name)+" "+direction)
+	}
+	orders = append(orders, quoteIdentifier("ID")+" ASC")
+	return " ORDER BY " + strings.Join(orders, ", "), nil
+}
+
+type sqlColumn struct {
+	Name  string
+	Index int
+}
+
+// sqlColumns returns the stored properties of a struct type
+func sqlColumns(structType reflect.Type) []sqlColumn {
+	columns := []sqlColumn{}
+	for i := 0; i < structType.NumField(); i++ {
+		if name, ok := propertyName(structType.Field(i)); ok {
+			columns = append(columns, sqlColumn{name, i})
+		}
+	}
+	return columns
+}
+
+func findSQLColumn(columns []sqlColumn, name string) (sqlColumn, bool) {
+	for _, column := range columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return sqlColumn{}, false
+}
+
+func sqlColumnList(columns []sqlColumn) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = quoteIdentifier(column.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func sqlScanTargets(value reflect.Value, columns []sqlColumn) []interface{} {
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		targets[i] = value.Field(column.Index).Addr().Interface()
+	}
+	return targets
+}
+
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
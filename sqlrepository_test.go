@@ -0,0 +1,166 @@
+package smartsnippets_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// SetUpSQLiteContext returns a context backed by an in-memory SQLite database
+func SetUpSQLiteContext(t *testing.T) (context.Context, *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	expect.Expect(t, err, nil)
+	// every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+	expect.Expect(t, app.CreateSQLTables(context.Background(), db, app.SQLiteDialect{}), nil)
+	return app.WithRepositoryFactory(context.Background(), app.NewSQLRepositoryFactory(db, app.SQLiteDialect{})), db
+}
+
+func TestCreateTableStatement(t *testing.T) {
+	statement, err := app.CreateTableStatement(app.SQLiteDialect{}, app.Kind.Categories, reflect.TypeOf(app.Category{}))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statement, `CREATE TABLE IF NOT EXISTS "Categories" ("ID" INTEGER NOT NULL PRIMARY KEY, "Title" TEXT NOT NULL, "Description" TEXT NOT NULL, "Created" DATETIME NOT NULL, "Updated" DATETIME NOT NULL, "Version" INTEGER NOT NULL)`)
+
+	t.Log("Fields ignored by the datastore are not stored")
+	statement, err = app.CreateTableStatement(app.PostgresDialect{}, app.Kind.Migrations, reflect.TypeOf(app.Migration{}))
+	expect.Expect(t, err, nil)
+	expect.Expect(t, statement, `CREATE TABLE IF NOT EXISTS "Migrations" ("ID" BIGINT NOT NULL PRIMARY KEY, "Name" TEXT NOT NULL, "Status" TEXT NOT NULL, "Checksum" TEXT NOT NULL, "Error" TEXT NOT NULL, "Processed" BIGINT NOT NULL, "Total" BIGINT NOT NULL, "Cursor" TEXT NOT NULL, "Created" TIMESTAMP WITH TIME ZONE NOT NULL, "Updated" TIMESTAMP WITH TIME ZONE NOT NULL, "Version" BIGINT NOT NULL)`)
+}
+
+func TestSQLTables(t *testing.T) {
+	for kind, prototype := range app.SQLTables() {
+		_, err := app.CreateTableStatement(app.PostgresDialect{}, kind, prototype)
+		expect.Expect(t, err, nil, kind)
+	}
+}
+
+func TestSQLRepository(t *testing.T) {
+	ctx, db := SetUpSQLiteContext(t)
+	defer db.Close()
+	repository := app.NewCategoryRepository(ctx)
+	category := &app.Category{Title: "Go", Description: "The Go language"}
+	expect.Expect(t, repository.Create(category), nil)
+	expect.Expect(t, category.ID > 0, true)
+	expect.Expect(t, category.Version, int64(1))
+	expect.Expect(t, category.Created.IsZero(), false)
+
+	result := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, result), nil)
+	expect.Expect(t, result.Title, "Go")
+	expect.Expect(t, result.Description, "The Go language")
+
+	result.Description = "Edited"
+	expect.Expect(t, repository.Update(result), nil)
+	expect.Expect(t, result.Version, int64(2))
+	edited := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, edited), nil)
+	expect.Expect(t, edited.Description, "Edited")
+
+	expect.Expect(t, repository.Delete(edited), nil)
+	expect.Expect(t, repository.FindByID(category.ID, &app.Category{}), datastore.ErrNoSuchEntity)
+	expect.Expect(t, repository.Update(edited), datastore.ErrNoSuchEntity)
+
+	t.Log("Entities created with an existing ID are rejected")
+	locks := app.NewSQLRepository(ctx, db, app.SQLiteDialect{}, app.Kind.Locks)
+	expect.Expect(t, locks.CreateWithID(&app.Lock{ID: 1, Name: "lock"}), nil)
+	expect.Expect(t, locks.CreateWithID(&app.Lock{ID: 1, Name: "lock"}), app.ErrDuplicateID)
+}
+
+func TestSQLRepository_FindBy(t *testing.T) {
+	ctx, db := SetUpSQLiteContext(t)
+	defer db.Close()
+	repository := app.NewSnippetRepository(ctx)
+	for _, snippet := range []*app.Snippet{
+		{Title: "C", CategoryID: 1},
+		{Title: "A", CategoryID: 2},
+		{Title: "B", CategoryID: 1},
+		{Title: "D", CategoryID: 1},
+	} {
+		expect.Expect(t, repository.Create(snippet), nil)
+	}
+	snippets := []*app.Snippet{}
+	err := repository.FindBy(app.Query{Query: map[string]interface{}{"CategoryID=": 1}, Order: []string{"-Title"}}, &snippets)
+	expect.Expect(t, err, nil)
+	titles := []string{}
+	for _, snippet := range snippets {
+		titles = append(titles, snippet.Title)
+	}
+	expect.Expect(t, titles, []string{"D", "C", "B"})
+
+	t.Log("Projections, limits and offsets")
+	snippets = []*app.Snippet{}
+	err = repository.FindBy(app.Query{Order: []string{"Title"}, Fields: []string{"Title"}, Limit: 2, Offset: 1}, &snippets)
+	expect.Expect(t, err, nil)
+	expect.Expect(t, len(snippets), 2)
+	expect.Expect(t, snippets[0].Title, "B")
+	expect.Expect(t, snippets[0].CategoryID, int64(0), "Only the projected fields are read")
+
+	count, err := repository.Count(app.Query{Query: map[string]interface{}{"CategoryID=": 1, "Title >": "B"}})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 2)
+}
+
+func TestSQLRepository_FindPage(t *testing.T) {
+	ctx, db := SetUpSQLiteContext(t)
+	defer db.Close()
+	repository := app.NewCategoryRepository(ctx)
+	for _, title := range []string{"A", "B", "C", "D", "E"} {
+		expect.Expect(t, repository.Create(&app.Category{Title: title}), nil)
+	}
+	titles, cursor, pages := []string{}, "", 0
+	for {
+		categories := []*app.Category{}
+		next, err := repository.FindPage(app.Query{Order: []string{"Title"}, Limit: 2, Cursor: cursor}, &categories)
+		expect.Expect(t, err, nil)
+		for _, category := range categories {
+			titles = append(titles, category.Title)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	expect.Expect(t, pages, 3)
+	expect.Expect(t, titles, []string{"A", "B", "C", "D", "E"})
+	_, err := repository.FindPage(app.Query{Cursor: "not a cursor"}, &[]*app.Category{})
+	expect.Expect(t, err, app.ErrInvalidCursor)
+}
+
+func TestSQLRepository_Update_VersionConflict(t *testing.T) {
+	ctx, db := SetUpSQLiteContext(t)
+	defer db.Close()
+	repository := app.NewCategoryRepository(ctx)
+	category := &app.Category{Title: "Go"}
+	expect.Expect(t, repository.Create(category), nil)
+	stale := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, stale), nil)
+	category.Description = "First"
+	expect.Expect(t, repository.Update(category), nil)
+	stale.Description = "Second"
+	expect.Expect(t, repository.Update(stale), app.VersionMismatchError{Current: 2, Received: 1})
+	result := &app.Category{}
+	expect.Expect(t, repository.FindByID(category.ID, result), nil)
+	expect.Expect(t, result.Description, "First")
+
+	t.Log("Entities without version deleted during an update")
+	deleteLock := signal.ListenerFunc(func(e signal.Event) error {
+		if event, ok := e.(app.BeforeEntityUpdatedEvent); ok {
+			_, err := db.Exec(`DELETE FROM "Locks" WHERE "ID" = ?`, event.Old.GetID())
+			return err
+		}
+		return nil
+	})
+	locks := app.NewSQLRepository(ctx, db, app.SQLiteDialect{}, app.Kind.Locks, deleteLock)
+	lock := &app.Lock{ID: 1, Name: "lock"}
+	expect.Expect(t, locks.CreateWithID(lock), nil)
+	lock.Owner = "owner"
+	expect.Expect(t, locks.Update(lock), datastore.ErrNoSuchEntity)
+}
@@ -0,0 +1,95 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	RegisterSnippetFormat("sublime", SublimeFormat{})
+}
+
+// SublimeFormat is the format of Sublime Text *.sublime-snippet files :
+//
+//	<snippet>
+//		<content><![CDATA[for ${1:i} := 0; $1 < ${2:n}; $1++ {
+//		$0
+//	}]]></content>
+//		<tabTrigger>for</tabTrigger>
+//		<scope>source.go</scope>
+//		<description>A for loop</description>
+//	</snippet>
+//
+// A single file or a zip archive of files is imported, snippets are exported as a zip archive
+// with a file per snippet named after its title. Sublime Text has a single tab trigger,
+// the first prefix of a snippet is exported.
+type SublimeFormat struct{}
+
+const sublimeSnippetExtension = ".sublime-snippet"
+
+type sublimeSnippet struct {
+	XMLName     xml.Name       `xml:"snippet"`
+	Content     sublimeContent `xml:"content"`
+	TabTrigger  string         `xml:"tabTrigger,omitempty"`
+	Scope       string         `xml:"scope,omitempty"`
+	Description string         `xml:"description,omitempty"`
+}
+
+// sublimeContent is written as a CDATA section so placeholders and code stay readable
+type sublimeContent struct {
+	Text string `xml:",cdata"`
+}
+
+func (SublimeFormat) ContentType() string { return "application/zip" }
+
+func (format SublimeFormat) Decode(r io.Reader) ([]*Snippet, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isZip(source) {
+		return decodeSnippetZip(source, []string{sublimeSnippetExtension}, format.decode)
+	}
+	return format.decode("", bytes.NewReader(source))
+}
+
+// decode reads a snippet file, a snippet without name is named after its description or its tab trigger
+func (SublimeFormat) decode(name string, r io.Reader) ([]*Snippet, error) {
+	file := sublimeSnippet{}
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	snippet := &Snippet{Title: name, Prefix: file.TabTrigger, Scope: file.Scope, Description: file.Description, Content: file.Content.Text}
+	for _, title := range []string{file.Description, file.TabTrigger, "snippet"} {
+		if snippet.Title == "" {
+			snippet.Title = title
+		}
+	}
+	if languages := textMateScopeLanguages(file.Scope); languages != "" {
+		snippet.Category = &Category{Title: languages}
+	}
+	return []*Snippet{snippet}, nil
+}
+
+func (format SublimeFormat) Encode(w io.Writer, snippets []*Snippet) error {
+	return encodeSnippetZip(w, snippets, sublimeSnippetExtension, format.encode)
+}
+
+func (SublimeFormat) encode(w io.Writer, snippet *Snippet) error {
+	file := sublimeSnippet{
+		Content:     sublimeContent{snippet.Content},
+		TabTrigger:  strings.TrimSpace(strings.Split(snippet.Prefix, ",")[0]),
+		Scope:       TextMateScope(snippet),
+		Description: snippet.Description,
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
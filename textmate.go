@@ -0,0 +1,150 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	RegisterSnippetFormat("textmate", TextMateFormat{})
+}
+
+// TextMateFormat is the format of TextMate *.tmSnippet property lists :
+//
+//	<plist version="1.0">
+//	<dict>
+//		<key>content</key>
+//		<string>for ${1:i} := 0; $1 &lt; ${2:n}; $1++ {
+//		$0
+//	}</string>
+//		<key>name</key>
+//		<string>For loop</string>
+//		<key>scope</key>
+//		<string>source.go</string>
+//		<key>tabTrigger</key>
+//		<string>for</string>
+//	</dict>
+//	</plist>
+//
+// A single file or a zip archive of files is imported, snippets are exported as a zip archive.
+// TextMate has a single tab trigger, the first prefix of a snippet is exported.
+type TextMateFormat struct{}
+
+const textMateSnippetExtension = ".tmSnippet"
+
+const textMateHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`
+
+// textMateEscaper escapes the text of a property list, new lines are kept as they are
+var textMateEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (TextMateFormat) ContentType() string { return "application/zip" }
+
+func (format TextMateFormat) Decode(r io.Reader) ([]*Snippet, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isZip(source) {
+		return decodeSnippetZip(source, []string{textMateSnippetExtension}, format.decode)
+	}
+	return format.decode("", bytes.NewReader(source))
+}
+
+// decode reads the string values of the top level dict of a property list,
+// the name of the file is the title of a snippet without name
+func (TextMateFormat) decode(name string, r io.Reader) ([]*Snippet, error) {
+	decoder := xml.NewDecoder(r)
+	values := map[string]string{}
+	key, depth := "", 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Local == "dict" && depth == 0:
+			depth++
+		case depth == 0:
+		case start.Name.Local == "key":
+			if err = decoder.DecodeElement(&key, &start); err != nil {
+				return nil, err
+			}
+		case start.Name.Local == "string":
+			value := ""
+			if err = decoder.DecodeElement(&value, &start); err != nil {
+				return nil, err
+			}
+			values[key] = value
+		default:
+			// arrays, dicts, numbers and booleans are not snippet fields
+			if err = decoder.Skip(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if depth == 0 {
+		return nil, fmt.Errorf("ErrInvalidPropertyList")
+	}
+	snippet := &Snippet{Title: values["name"], Prefix: values["tabTrigger"], Scope: values["scope"], Content: values["content"]}
+	for _, title := range []string{name, values["tabTrigger"], "snippet"} {
+		if snippet.Title == "" {
+			snippet.Title = title
+		}
+	}
+	if languages := textMateScopeLanguages(snippet.Scope); languages != "" {
+		snippet.Category = &Category{Title: languages}
+	}
+	return []*Snippet{snippet}, nil
+}
+
+func (format TextMateFormat) Encode(w io.Writer, snippets []*Snippet) error {
+	return encodeSnippetZip(w, snippets, textMateSnippetExtension, format.encode)
+}
+
+func (TextMateFormat) encode(w io.Writer, snippet *Snippet) error {
+	// the uuid identifies the snippet in TextMate bundles, it is derived from the snippet so exports are stable
+	sum := md5.Sum([]byte(fmt.Sprintf("%d:%s", snippet.ID, snippet.Title)))
+	sum[6], sum[8] = sum[6]&0x0f|0x30, sum[8]&0x3f|0x80
+	values := [][2]string{
+		{"content", snippet.Content},
+		{"name", snippet.Title},
+		{"scope", TextMateScope(snippet)},
+		{"tabTrigger", strings.TrimSpace(strings.Split(snippet.Prefix, ",")[0])},
+		{"uuid", fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:])},
+	}
+	if _, err := io.WriteString(w, textMateHeader); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if value[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\t<key>%s</key>\n\t<string>", value[0]); err != nil {
+			return err
+		}
+		if _, err := textMateEscaper.WriteString(w, value[1]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "</string>\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</dict>\n</plist>\n")
+	return err
+}
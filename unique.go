@@ -0,0 +1,318 @@
+package smartsnippets
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrUniqueViolation is returned when an entity has the value of a unique field of another entity
+type ErrUniqueViolation struct {
+	Kind  string
+	Field string
+}
+
+func (e ErrUniqueViolation) Error() string {
+	return fmt.Sprintf("ErrUniqueViolation : %s.%s is already used", e.Kind, e.Field)
+}
+
+// UniqueReservation reserves the value of a unique field for an entity.
+// Its ID is derived from the kind, the field and the value so that two entities
+// reserving the same value in concurrent transactions write the same entity.
+type UniqueReservation struct {
+	ID       int64
+	Kind     string
+	Field    string
+	Value    string
+	EntityID int64
+	Created  time.Time
+}
+
+func (r UniqueReservation) GetID() int64               { return r.ID }
+func (r *UniqueReservation) SetID(id int64)            { r.ID = id }
+func (r *UniqueReservation) SetCreated(date time.Time) { r.Created = date }
+func (r *UniqueReservation) SetUpdated(date time.Time) {}
+
+// uniqueConstraint is a field tagged with `unique:"true"` or `unique:"nocase"`
+type uniqueConstraint struct {
+	Field      string
+	IgnoreCase bool
+}
+
+var (
+	uniqueConstraints = map[string][]uniqueConstraint{}
+	uniquePrototypes  = map[string]reflect.Type{}
+)
+
+func init() {
+	RegisterUniqueConstraints(Kind.Users, User{})
+	RegisterUniqueConstraints(Kind.Categories, Category{})
+	RegisterUniqueConstraints(Kind.Roles, Role{})
+}
+
+// RegisterUniqueConstraints enforces the unique tags of prototype on the repositories of kind :
+//
+//	Nickname string `unique:"nocase"` // case insensitive
+//	Name     string `unique:"true"`
+//
+// Empty values are not reserved.
+func RegisterUniqueConstraints(kind string, prototype interface{}) {
+	prototypeType := reflect.Indirect(reflect.ValueOf(prototype)).Type()
+	constraints := []uniqueConstraint{}
+	for i := 0; i < prototypeType.NumField(); i++ {
+		switch field := prototypeType.Field(i); field.Tag.Get("unique") {
+		case "true":
+			constraints = append(constraints, uniqueConstraint{Field: field.Name})
+		case "nocase":
+			constraints = append(constraints, uniqueConstraint{Field: field.Name, IgnoreCase: true})
+		}
+	}
+	if len(constraints) > 0 {
+		uniqueConstraints[kind], uniquePrototypes[kind] = constraints, prototypeType
+	}
+}
+
+// value returns the normalized value of the constraint field of entity
+func (constraint uniqueConstraint) value(entity Entity) string {
+	value := fmt.Sprint(reflect.Indirect(reflect.ValueOf(entity)).FieldByName(constraint.Field).Interface())
+	if constraint.IgnoreCase {
+		value = strings.ToLower(value)
+	}
+	return value
+}
+
+// UniqueRepository is a Repository enforcing the unique constraints of its kind.
+// Writes run in a transaction that reserves the unique values of the entity.
+type UniqueRepository struct {
+	Repository
+	Context     context.Context
+	Kind        string
+	constraints []uniqueConstraint
+	listeners   []signal.Listener
+}
+
+// NewUniqueRepository creates a UniqueRepository enforcing the constraints registered for kind
+func NewUniqueRepository(ctx context.Context, kind string, listeners ...signal.Listener) *UniqueRepository {
+	return &UniqueRepository{
+		Repository:  newRepository(ctx, kind, listeners...),
+		Context:     ctx,
+		Kind:        kind,
+		constraints: uniqueConstraints[kind],
+		listeners:   listeners,
+	}
+}
+
+func (repository UniqueRepository) Create(entity Entity) error {
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		if err := newRepository(tx.GetContext(), repository.Kind, repository.listeners...).Create(entity); err != nil {
+			return err
+		}
+		return repository.reserve(tx.GetContext(), nil, entity)
+	})
+}
+
+func (repository UniqueRepository) Update(entity Entity) error {
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		kindRepository := newRepository(tx.GetContext(), repository.Kind, repository.listeners...)
+		old := reflect.New(reflect.Indirect(reflect.ValueOf(entity)).Type()).Interface().(Entity)
+		if err := kindRepository.FindByID(entity.GetID(), old); err != nil {
+			return err
+		}
+		if err := kindRepository.Update(entity); err != nil {
+			return err
+		}
+		return repository.reserve(tx.GetContext(), old, entity)
+	})
+}
+
+func (repository UniqueRepository) Delete(entity Entity) error {
+	return RunInTransaction(repository.Context, func(tx Repositories) error {
+		kindRepository := newRepository(tx.GetContext(), repository.Kind, repository.listeners...)
+		// the reserved values are those of the stored entity
+		old := reflect.New(reflect.Indirect(reflect.ValueOf(entity)).Type()).Interface().(Entity)
+		if err := kindRepository.FindByID(entity.GetID(), old); err != nil {
+			return err
+		}
+		if err := kindRepository.Delete(entity); err != nil {
+			return err
+		}
+		return repository.reserve(tx.GetContext(), old, nil)
+	})
+}
+
+// reserve reserves the unique values of new and releases the values of old that changed,
+// old or new can be nil
+func (repository UniqueRepository) reserve(ctx context.Context, old, new Entity) error {
+	reservations := GetRepositoryFactory(ctx).Create(ctx, Kind.UniqueReservations)
+	for _, constraint := range repository.constraints {
+		oldValue, newValue := "", ""
+		if old != nil {
+			oldValue = constraint.value(old)
+		}
+		if new != nil {
+			newValue = constraint.value(new)
+		}
+		if oldValue == newValue {
+			continue
+		}
+		if newValue != "" {
+			if err := reserveUniqueValue(reservations, repository.Kind, constraint.Field, newValue, new.GetID()); err != nil {
+				return err
+			}
+		}
+		if oldValue != "" {
+			if err := releaseUniqueValue(reservations, repository.Kind, constraint.Field, oldValue, old.GetID()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func reserveUniqueValue(reservations Repository, kind, field, value string, entityID int64) error {
+	reservation, freeID, err := findUniqueReservation(reservations, kind, field, value)
+	if err != nil {
+		return err
+	}
+	if reservation != nil {
+		if reservation.EntityID == entityID {
+			return nil
+		}
+		return ErrUniqueViolation{Kind: kind, Field: field}
+	}
+	if freeID == 0 {
+		return fmt.Errorf("No free reservation ID for a value of %s.%s", kind, field)
+	}
+	creator, ok := reservations.(IDCreator)
+	if !ok {
+		return fmt.Errorf("Repository of kind %s cannot create entities with a given ID", Kind.UniqueReservations)
+	}
+	err = creator.CreateWithID(&UniqueReservation{
+		ID: freeID, Kind: kind, Field: field, Value: value, EntityID: entityID,
+	})
+	if err == ErrDuplicateID {
+		// a concurrent transaction reserved the value first
+		return ErrUniqueViolation{Kind: kind, Field: field}
+	}
+	return err
+}
+
+func releaseUniqueValue(reservations Repository, kind, field, value string, entityID int64) error {
+	reservation, _, err := findUniqueReservation(reservations, kind, field, value)
+	if err != nil || reservation == nil || reservation.EntityID != entityID {
+		return err
+	}
+	return reservations.Delete(reservation)
+}
+
+// findUniqueEntityID returns the ID of the entity of kind whose field equals value, compared as the unique
+// constraint of field compares it, or 0. constrained is false if field has no unique constraint
+func findUniqueEntityID(ctx context.Context, kind, field string, value interface{}) (id int64, constrained bool, err error) {
+	for _, constraint := range uniqueConstraints[kind] {
+		if constraint.Field != field {
+			continue
+		}
+		normalized := fmt.Sprint(value)
+		if constraint.IgnoreCase {
+			normalized = strings.ToLower(normalized)
+		}
+		if normalized == "" {
+			return 0, true, nil
+		}
+		reservation, _, err := findUniqueReservation(GetRepositoryFactory(ctx).Create(ctx, Kind.UniqueReservations), kind, field, normalized)
+		if err != nil || reservation == nil {
+			return 0, true, err
+		}
+		return reservation.EntityID, true, nil
+	}
+	return 0, false, nil
+}
+
+// uniqueReservationProbes is the number of IDs a value can be reserved at. When another value
+// with the same hash holds uniqueReservationID, the value is reserved at one of the next IDs
+const uniqueReservationProbes = 3
+
+// findUniqueReservation returns the reservation of a value, or nil and the first free ID the value
+// can be reserved at. Every probe is read, a released reservation can be followed by a reserved one
+func findUniqueReservation(reservations Repository, kind, field, value string) (*UniqueReservation, int64, error) {
+	id, freeID := uniqueReservationID(kind, field, value), int64(0)
+	for i := int64(0); i < uniqueReservationProbes; i++ {
+		probe := id + i
+		if probe < id {
+			// the ID overflowed, probes continue from 1
+			probe = i
+		}
+		reservation := &UniqueReservation{}
+		err := reservations.FindByID(probe, reservation)
+		if err == datastore.ErrNoSuchEntity {
+			if freeID == 0 {
+				freeID = probe
+			}
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if reservation.Kind == kind && reservation.Field == field && reservation.Value == value {
+			return reservation, 0, nil
+		}
+	}
+	return nil, freeID, nil
+}
+
+// uniqueReservationID hashes a value of a unique field into a positive ID
+func uniqueReservationID(kind, field, value string) int64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s", kind, field, value)
+	if id := int64(hash.Sum64() & (1<<63 - 1)); id != 0 {
+		return id
+	}
+	return 1
+}
+
+// ReserveUniqueValues reserves the unique values of the entities stored before
+// their constraints were registered, the values already reserved are skipped
+func ReserveUniqueValues(ctx context.Context) error {
+	reservations := GetRepositoryFactory(ctx).Create(ctx, Kind.UniqueReservations)
+	for kind, constraints := range uniqueConstraints {
+		entities := reflect.New(reflect.SliceOf(reflect.PtrTo(uniquePrototypes[kind])))
+		if err := GetRepositoryFactory(ctx).Create(ctx, kind).FindAll(entities.Interface()); err != nil {
+			return err
+		}
+		for i := 0; i < entities.Elem().Len(); i++ {
+			entity := entities.Elem().Index(i).Interface().(Entity)
+			for _, constraint := range constraints {
+				value := constraint.value(entity)
+				if value == "" {
+					continue
+				}
+				err := reserveUniqueValue(reservations, kind, constraint.Field, value, entity.GetID())
+				if _, ok := err.(ErrUniqueViolation); err != nil && !ok {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ReleaseUniqueValues deletes every reservation, it reverts ReserveUniqueValues
+func ReleaseUniqueValues(ctx context.Context) error {
+	reservations := GetRepositoryFactory(ctx).Create(ctx, Kind.UniqueReservations)
+	found := []*UniqueReservation{}
+	if err := reservations.FindAll(&found); err != nil {
+		return err
+	}
+	for _, reservation := range found {
+		if err := reservations.Delete(reservation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package smartsnippets_test
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/Mparaiso/expect-go"
+	app "github.com/Mparaiso/snipped-go"
+)
+
+func TestUniqueRepository(t *testing.T) {
+	repository := app.NewCategoryRepository(SetUpMemoryContext())
+	golang := &app.Category{Title: "Go"}
+	expect.Expect(t, repository.Create(golang), nil)
+	err := repository.Create(&app.Category{Title: "GO"})
+	expect.Expect(t, err, app.ErrUniqueViolation{Kind: app.Kind.Categories, Field: "Title"})
+	count, err := repository.Count(app.Query{})
+	expect.Expect(t, err, nil)
+	expect.Expect(t, count, 1, "the violating category is not created")
+
+	t.Log("Updates release the previous value")
+	golang.Title = "Golang"
+	expect.Expect(t, repository.Update(golang), nil)
+	expect.Expect(t, repository.Create(&app.Category{Title: "go"}), nil)
+	golang.Title = "Go"
+	expect.Expect(t, repository.Update(golang), app.ErrUniqueViolation{Kind: app.Kind.Categories, Field: "Title"})
+
+	t.Log("Deletes release the value")
+	expect.Expect(t, repository.FindByID(golang.ID, golang), nil)
+	expect.Expect(t, repository.Delete(golang), nil)
+	expect.Expect(t, repository.Create(&app.Category{Title: "golang"}), nil)
+}
+
+func TestUniqueRepository_HashCollision(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	// another value holds the reservation ID of "go"
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s", app.Kind.Categories, "Title", "go")
+	id := int64(hash.Sum64() & (1<<63 - 1))
+	reservations := app.GetRepositoryFactory(ctx).Create(ctx, app.Kind.UniqueReservations)
+	expect.Expect(t, reservations.(app.IDCreator).CreateWithID(&app.UniqueReservation{ID: id, Kind: app.Kind.Categories, Field: "Title", Value: "colliding", EntityID: 1000}), nil)
+
+	repository := app.NewCategoryRepository(ctx)
+	golang := &app.Category{Title: "Go"}
+	expect.Expect(t, repository.Create(golang), nil, "The value is reserved at the next ID")
+	expect.Expect(t, repository.Create(&app.Category{Title: "GO"}), app.ErrUniqueViolation{Kind: app.Kind.Categories, Field: "Title"})
+	expect.Expect(t, repository.Delete(golang), nil)
+	result := &app.UniqueReservation{}
+	expect.Expect(t, reservations.FindByID(id, result), nil)
+	expect.Expect(t, result.Value, "colliding", "The colliding reservation is kept")
+	expect.Expect(t, repository.Create(&app.Category{Title: "go"}), nil)
+}
+
+func TestReserveUniqueValues(t *testing.T) {
+	ctx := SetUpMemoryContext()
+	// users stored before their constraints existed have no reservation
+	raw := app.GetRepositoryFactory(ctx).Create(ctx, app.Kind.Users)
+	expect.Expect(t, raw.Create(&app.User{Nickname: "JohnDoe", Email: "john.doe@acme.com"}), nil)
+	expect.Expect(t, app.ReserveUniqueValues(ctx), nil)
+	err := app.NewUserRepository(ctx).Repository.Create(&app.User{Nickname: "johndoe", Email: "johndoe@acme.com"})
+	expect.Expect(t, err, app.ErrUniqueViolation{Kind: app.Kind.Users, Field: "Nickname"})
+}
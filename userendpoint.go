@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	tiger "github.com/Mparaiso/tiger-go-framework"
+	"github.com/Mparaiso/tiger-go-framework/signal"
+	"github.com/Mparaiso/tiger-go-framework/validator"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/appengine/datastore"
 )
 
 func EncryptPassword(password string) (encryptedPassword string, err error) {
@@ -17,6 +22,31 @@ func EncryptPassword(password string) (encryptedPassword string, err error) {
 	return string(Bytes), nil
 }
 
+// NewUserPasswordListener keeps the /users endpoint from writing and reading passwords,
+// users choose their password with /users/register or a password reset
+func NewUserPasswordListener() signal.Listener {
+	return signal.ListenerFunc(func(e signal.Event) error {
+		switch event := e.(type) {
+		case *BeforeResourceCreateEvent:
+			if user, ok := event.Entity.(*User); ok {
+				user.SetPassword("")
+				user.SetEncryptedPassword("")
+			}
+		case *BeforeResourceUpdateEvent:
+			if user, ok := event.New.(*User); ok {
+				user.SetPassword("")
+				user.SetEncryptedPassword(event.Old.(*User).EncryptedPassworld)
+			}
+		case *AfterResourceReadEvent:
+			if user, ok := event.Entity.(*User); ok {
+				user.SetPassword("")
+				user.SetEncryptedPassword("")
+			}
+		}
+		return nil
+	})
+}
+
 type UserEndpointContainerFactory struct{}
 
 func NewUserEndpointContainerFactory() *UserEndpointContainerFactory {
@@ -24,12 +54,12 @@ func NewUserEndpointContainerFactory() *UserEndpointContainerFactory {
 }
 func (factory UserEndpointContainerFactory) Create(container tiger.Container) UserEndpointContainer {
 	return &DefaultUserEndpointContainer{
-		ContextAwareContainer: container.(*Container),
+		Container: container.(*Container),
 	}
 }
 
 type DefaultUserEndpointContainer struct {
-	ContextAwareContainer
+	*Container
 	UserRepository Repository
 }
 
@@ -47,7 +77,8 @@ func (container DefaultUserEndpointContainer) Validate(user *User) error {
 
 type UserEndpointContainer interface {
 	RepositoryProvider
-	tiger.Container
+	ContextAwareContainer
+	CurrentUserContainer
 	Validate(*User) error
 }
 
@@ -67,7 +98,129 @@ func (module UserEndpoint) Connect(routeCollection *tiger.RouteCollection) {
 		Use(func(container tiger.Container, next tiger.Handler) {
 			next(module.UserEndpointContainerFactory.Create(container))
 		}).
-		Post("/register", module.Wrap(module.Register))
+		Post("/register", module.Wrap(module.Register)).
+		Post("/login", module.Wrap(module.Login)).
+		Post("/logout", module.Wrap(module.Logout)).
+		Get("/me", module.Wrap(module.Me)).
+		Post("/password/forgot", module.Wrap(module.ForgotPassword)).
+		Post("/password/reset", module.Wrap(module.ResetPassword)).
+		Get("/:id/snippets", module.Wrap(module.Snippets))
+}
+
+// Snippets lists the snippets of a user, with the parameters of the snippet index
+func (module UserEndpoint) Snippets(container UserEndpointContainer) {
+	id, err := strconv.ParseInt(container.GetRequest().URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	if err = container.GetRepository().FindByID(id, &User{}); err == datastore.ErrNoSuchEntity {
+		container.Error(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	parameters := container.GetRequest().URL.Query()
+	parameters.Set("filter[AuthorID]", strconv.FormatInt(id, 10))
+	container.GetRequest().URL.RawQuery = parameters.Encode()
+	endpoint := NewEndpoint(new(SnippetEndPointContainerFactory))
+	endpoint.Index(endpoint.Create(container))
+}
+
+// ForgotPassword mails a password reset token to the user of the posted ForgotPassword.
+// It answers 202 whether the user exists or not
+func (module UserEndpoint) ForgotPassword(container UserEndpointContainer) {
+	forgotPassword := ForgotPassword{}
+	if err := json.NewDecoder(container.GetRequest().Body).Decode(&forgotPassword); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	err := RequestPasswordReset(container.GetContext(), forgotPassword.Login)
+	if err == ErrMailerNotFound {
+		container.Error(err, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets the password of the user of the posted NewPassword token
+func (module UserEndpoint) ResetPassword(container UserEndpointContainer) {
+	newPassword := NewPassword{}
+	if err := json.NewDecoder(container.GetRequest().Body).Decode(&newPassword); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	_, err := ResetPassword(container.GetContext(), newPassword.Token, newPassword.Password)
+	if _, ok := err.(validator.Error); ok || err == ErrInvalidResetToken {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+// Login verifies the posted Credentials and writes a bearer token
+func (module UserEndpoint) Login(container UserEndpointContainer) {
+	credentials := Credentials{}
+	if err := json.NewDecoder(container.GetRequest().Body).Decode(&credentials); err != nil {
+		container.Error(err, http.StatusBadRequest)
+		return
+	}
+	user, value, token, err := Login(container.GetContext(), credentials)
+	if err == ErrInvalidCredentials {
+		container.Error(err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if err = json.NewEncoder(container.GetResponseWriter()).Encode(struct {
+		Token      string
+		Expiration time.Time
+		UserID     int64
+	}{value, token.Expiration, user.ID}); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
+}
+
+// Logout revokes the bearer token of the request
+func (module UserEndpoint) Logout(container UserEndpointContainer) {
+	err := RevokeToken(container.GetContext(), BearerToken(container.GetRequest()))
+	if err == ErrInvalidToken {
+		container.GetResponseWriter().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		container.Error(err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		container.Error(err, http.StatusInternalServerError)
+		return
+	}
+	container.GetResponseWriter().WriteHeader(http.StatusNoContent)
+}
+
+// Me writes the current user, without its password
+func (module UserEndpoint) Me(container UserEndpointContainer) {
+	user := container.GetCurrentUser()
+	if user == nil {
+		container.GetResponseWriter().Header().Set("WWW-Authenticate", "Bearer")
+		container.Error(fmt.Errorf("Authentication is required"), http.StatusUnauthorized)
+		return
+	}
+	current := *user
+	current.SetPassword("")
+	current.SetEncryptedPassword("")
+	if err := json.NewEncoder(container.GetResponseWriter()).Encode(current); err != nil {
+		container.Error(err, http.StatusInternalServerError)
+	}
 }
 func (module UserEndpoint) Register(container UserEndpointContainer) {
 	user := &User{}
@@ -91,7 +244,12 @@ func (module UserEndpoint) Register(container UserEndpointContainer) {
 	user.SetPassword("")
 	user.SetEncryptedPassword(encryptedPassword)
 
-	if err = container.GetRepository().Create(user); err != nil {
+	err = container.GetRepository().Create(user)
+	if _, ok := err.(ErrUniqueViolation); ok {
+		container.Error(err, http.StatusConflict)
+		return
+	}
+	if err != nil {
 		container.Error(err, http.StatusInternalServerError)
 		return
 	}
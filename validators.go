@@ -62,14 +62,28 @@ func (v UserValidator) Validate(user *User) error {
 	validator.StringNotEmptyValidator("Nickname", user.Nickname, errors)
 	v.UniqueEntityValidator("Nickname", map[string]interface{}{"Nickname": user.Nickname}, errors)
 	validator.EmailValidator("Email", user.Email, errors)
-	validator.StringNotEmptyValidator("Password", user.Password, errors)
-	validator.StringLengthValidator("Password", user.Password, 7, 126, errors)
+	passwordValidator(user.Password, errors)
 	if errors.HasErrors() {
 		return errors
 	}
 	return nil
 }
 
+// ValidatePassword validates a new password with the rules of UserValidator
+func ValidatePassword(password string) error {
+	errors := validator.NewConcreteError()
+	passwordValidator(password, errors)
+	if errors.HasErrors() {
+		return errors
+	}
+	return nil
+}
+
+func passwordValidator(password string, errors validator.Error) {
+	validator.StringNotEmptyValidator("Password", password, errors)
+	validator.StringLengthValidator("Password", password, 7, 126, errors)
+}
+
 type DefaultUniqueEntityValidatorProvider struct {
 	Repository
 }
@@ -0,0 +1,192 @@
+package smartsnippets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterSnippetFormat("vscode", VSCodeFormat{})
+}
+
+// VSCodeFormat is the format of VS Code *.code-snippets files :
+//
+//	{
+//		"Print to console": {
+//			"scope": "javascript,typescript",
+//			"prefix": ["log", "console"],
+//			"body": ["console.log('$1');", "$2"],
+//			"description": "Log output to console"
+//		}
+//	}
+//
+// The first scope matching a category is kept on import, placeholders are stored as they are.
+type VSCodeFormat struct{}
+
+// vscodeSnippet is a snippet of a *.code-snippets file,
+// prefix and body are either a string or an array of strings
+type vscodeSnippet struct {
+	Scope       string          `json:"scope,omitempty"`
+	Prefix      json.RawMessage `json:"prefix,omitempty"`
+	Body        json.RawMessage `json:"body"`
+	Description string          `json:"description,omitempty"`
+}
+
+func (VSCodeFormat) ContentType() string { return "application/json" }
+
+func (VSCodeFormat) Decode(r io.Reader) ([]*Snippet, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	file := map[string]vscodeSnippet{}
+	if err = json.Unmarshal(stripJSONComments(source), &file); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range file {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snippets := []*Snippet{}
+	for _, name := range names {
+		entry := file[name]
+		prefixes, err := stringOrStrings(entry.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s : invalid prefix : %v", name, err)
+		}
+		body, err := stringOrStrings(entry.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s : invalid body : %v", name, err)
+		}
+		snippet := &Snippet{Title: name, Prefix: strings.Join(prefixes, ","), Description: entry.Description, Content: strings.Join(body, "\n")}
+		if entry.Scope != "" {
+			snippet.Scope, snippet.Category = entry.Scope, &Category{Title: entry.Scope}
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, nil
+}
+
+func (VSCodeFormat) Encode(w io.Writer, snippets []*Snippet) error {
+	file := map[string]vscodeSnippet{}
+	for _, snippet := range snippets {
+		entry := vscodeSnippet{Description: snippet.Description}
+		// scopes imported from other editors are selectors like source.go, not language identifiers
+		if snippet.Scope != "" && !strings.Contains(snippet.Scope, ".") {
+			entry.Scope = snippet.Scope
+		} else if snippet.Category != nil {
+			entry.Scope = CategoryLanguage(snippet.Category)
+		}
+		var err error
+		if prefixes := strings.Split(snippet.Prefix, ","); len(prefixes) > 1 {
+			entry.Prefix, err = json.Marshal(prefixes)
+		} else if snippet.Prefix != "" {
+			entry.Prefix, err = json.Marshal(snippet.Prefix)
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Body, err = json.Marshal(strings.Split(snippet.Content, "\n")); err != nil {
+			return err
+		}
+		// names are the keys of the file, they must be unique
+		name := snippet.Title
+		for i := 2; ; i++ {
+			if _, ok := file[name]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s (%d)", snippet.Title, i)
+		}
+		file[name] = entry
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(file)
+}
+
+// stringOrStrings decodes a JSON string or array of strings
+func stringOrStrings(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return []string{}, nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return []string{value}, nil
+	}
+	values := []string{}
+	err := json.Unmarshal(raw, &values)
+	return values, err
+}
+
+// stripJSONComments removes the comments and trailing commas VS Code allows in JSON files
+func stripJSONComments(source []byte) []byte {
+	result := new(bytes.Buffer)
+	for i := 0; i < len(source); i++ {
+		switch {
+		case source[i] == '"':
+			// copy the string, escaped characters included
+			start := i
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(source) {
+				i = len(source) - 1
+			}
+			result.Write(source[start : i+1])
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '/':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			if i < len(source) {
+				result.WriteByte('\n')
+			}
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '*':
+			end := bytes.Index(source[i+2:], []byte("*/"))
+			if end == -1 {
+				return result.Bytes()
+			}
+			i += end + 3
+		case source[i] == ',':
+			// a comma followed by a closing bracket is dropped
+			if next := nextSignificantByte(source, i+1); next == '}' || next == ']' {
+				continue
+			}
+			result.WriteByte(',')
+		default:
+			result.WriteByte(source[i])
+		}
+	}
+	return result.Bytes()
+}
+
+// nextSignificantByte returns the first byte of source from start which is not whitespace
+// or part of a comment, or 0 at the end of source
+func nextSignificantByte(source []byte, start int) byte {
+	for i := start; i < len(source); i++ {
+		switch {
+		case source[i] == ' ' || source[i] == '\t' || source[i] == '\r' || source[i] == '\n':
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '/':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '*':
+			end := bytes.Index(source[i+2:], []byte("*/"))
+			if end == -1 {
+				return 0
+			}
+			i += end + 3
+		default:
+			return source[i]
+		}
+	}
+	return 0
+}